@@ -0,0 +1,847 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/service"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// moneyFlag is a flag.Value for domain.Money
+type moneyFlag struct {
+	value domain.Money
+	set   bool
+}
+
+func (m *moneyFlag) String() string {
+	return m.value.String()
+}
+
+func (m *moneyFlag) Set(s string) error {
+	value, err := domain.StringToMoney(s)
+	if err != nil {
+		return err
+	}
+	m.value, m.set = value, true
+	return nil
+}
+
+// timeFlag is a flag.Value for time in RFC 3339 or YYYY-MM-DD format
+type timeFlag struct {
+	value *time.Time
+}
+
+func (t *timeFlag) String() string {
+	if t.value == nil {
+		return ""
+	}
+	return t.value.Format(time.RFC3339)
+}
+
+func (t *timeFlag) Set(s string) error {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if value, err := time.Parse(layout, s); err == nil {
+			t.value = &value
+			return nil
+		}
+	}
+	return fmt.Errorf("expected RFC 3339 or YYYY-MM-DD")
+}
+
+// splitFlag is a repeatable flag.Value for split USER=AMOUNT or USER=BASIS_POINTSbp
+type splitFlag []domain.Split
+
+func (f *splitFlag) String() string {
+	parts := make([]string, 0, len(*f))
+	for _, split := range *f {
+		if split.BasisPoints != 0 {
+			parts = append(parts, fmt.Sprintf("%d=%dbp", split.UserId, split.BasisPoints))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d=%s", split.UserId, split.Amount))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *splitFlag) Set(s string) error {
+	user, share, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("expected USER=AMOUNT or USER=BASIS_POINTSbp")
+	}
+	userId, err := strconv.ParseUint(user, 10, 0)
+	if err != nil {
+		return fmt.Errorf("invalid user: %v", err)
+	}
+	split := domain.Split{UserId: uint(userId)}
+	if basisPoints, ok := strings.CutSuffix(share, "bp"); ok {
+		split.BasisPoints, err = strconv.ParseInt(basisPoints, 10, 64)
+	} else {
+		split.Amount, err = domain.StringToMoney(share)
+	}
+	if err != nil {
+		return err
+	}
+	*f = append(*f, split)
+	return nil
+}
+
+func currencyVar(flags *flag.FlagSet, currency *domain.Currency) {
+	flags.StringVar((*string)(currency), "currency", string(domain.DefaultCurrency), "currency")
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: avitoctl %s\n", commands[name].usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func validate(flags *flag.FlagSet, dto domain.DTO) error {
+	if err := dto.Validate(); err != nil {
+		flags.Usage()
+		return fmt.Errorf("invalid arguments: %v", err)
+	}
+	return nil
+}
+
+func balanceCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("balance")
+	userId := flags.Uint("user", 0, "user id")
+	var currency domain.Currency
+	currencyVar(flags, &currency)
+	var at timeFlag
+	flags.Var(&at, "at", "print balance as of this time computed from transactions")
+	reservations := flags.Bool("reservations", false, "print open reservations in currency instead of wallets")
+	flags.Parse(args)
+
+	if at.value != nil {
+		atDTO := domain.GetBalanceAtDTO{UserId: *userId, Currency: currency, At: *at.value}
+		if err := validate(flags, atDTO); err != nil {
+			return err
+		}
+		balance, err := srv.GetBalanceAt(ctx, &atDTO)
+		if err != nil {
+			return err
+		}
+		return out.print(balance, []string{"USER_ID", "CURRENCY", "AT", "BALANCE", "RESERVED", "CREDIT_LIMIT", "AVAILABLE"},
+			[][]string{{strconv.Itoa(int(atDTO.UserId)), string(balance.Currency), balance.At.Format(time.RFC3339),
+				balance.Balance.String(), balance.Reserved.String(), balance.CreditLimit.String(), balance.Available.String()}})
+	}
+
+	if *reservations {
+		dto := domain.GetBalanceDTO{UserId: *userId, Currency: currency, Reservations: true}
+		if err := validate(flags, dto); err != nil {
+			return err
+		}
+		balance, err := srv.GetBalance(ctx, &dto)
+		if err != nil {
+			return err
+		}
+		if balance.Reservations == nil {
+			balance.Reservations = []domain.Reservation{}
+		}
+		rows := make([][]string, 0, len(balance.Reservations))
+		for _, r := range balance.Reservations {
+			rows = append(rows, []string{strconv.Itoa(int(r.TransactionId)), r.Kind, strconv.Itoa(int(r.ServiceId)),
+				r.ServiceName, strconv.Itoa(int(r.OrderId)), r.Amount.String(), r.Fee.String(),
+				(time.Duration(r.AgeSeconds) * time.Second).String()})
+		}
+		return out.print(balance.Reservations,
+			[]string{"TRANSACTION_ID", "KIND", "SERVICE_ID", "SERVICE", "ORDER_ID", "AMOUNT", "FEE", "AGE"}, rows)
+	}
+
+	dto := domain.GetBalanceDTO{UserId: *userId}
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	wallets, err := srv.GetWallets(ctx, &dto)
+	if err != nil {
+		return err
+	}
+	if wallets == nil {
+		wallets = []domain.Wallet{}
+	}
+
+	rows := make([][]string, 0, len(wallets))
+	for _, wallet := range wallets {
+		rows = append(rows, []string{strconv.Itoa(int(dto.UserId)), string(wallet.Currency), wallet.Balance.String(),
+			wallet.Reserved.String(), wallet.CreditLimit.String(), wallet.Available.String(), wallet.PromoBalance.String()})
+	}
+	return out.print(wallets, []string{"USER_ID", "CURRENCY", "BALANCE", "RESERVED", "CREDIT_LIMIT", "AVAILABLE", "PROMO"}, rows)
+}
+
+func historyCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("history")
+	dto := domain.GetHistoryDTO{}
+	var from, to timeFlag
+	flags.UintVar(&dto.UserId, "user", 0, "user id")
+	flags.IntVar(&dto.Limit, "limit", service.MaxHistoryRowPerRequest, "max number of rows")
+	flags.IntVar(&dto.Offset, "offset", 0, "number of rows to skip")
+	flags.StringVar(&dto.SortBy, "sort", domain.GetHistoryDTOSortByTimestamp, "sort by timestamp or amount")
+	flags.BoolVar(&dto.Reverse, "reverse", false, "reverse order")
+	flags.Var(&from, "from", "show operations since this time")
+	flags.Var(&to, "to", "show operations before this time")
+	flags.Parse(args)
+	dto.From, dto.To = from.value, to.value
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	history, err := srv.GetHistory(ctx, &dto)
+	if err != nil {
+		return err
+	}
+	if history == nil {
+		history = domain.History{}
+	}
+
+	rows := make([][]string, 0, len(history))
+	for _, row := range history {
+		rows = append(rows, []string{row.Timestamp.Format(time.RFC3339), row.Amount.String(), string(row.Currency), row.Description})
+	}
+	return out.print(history, []string{"TIMESTAMP", "AMOUNT", "CURRENCY", "DESCRIPTION"}, rows)
+}
+
+func replenishCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("replenish")
+	dto := domain.ReplenishBalanceDTO{}
+	var amount moneyFlag
+	flags.UintVar(&dto.UserId, "user", 0, "user id")
+	flags.Var(&amount, "amount", "amount, e.g. 100.50")
+	currencyVar(flags, &dto.Currency)
+	flags.StringVar(&dto.Description, "description", "", "description")
+	flags.StringVar(&dto.IdempotencyKey, "key", "", "idempotency key")
+	flags.Parse(args)
+	dto.Amount = amount.value
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	if err := srv.ReplenishBalance(ctx, &dto); err != nil {
+		return err
+	}
+	return out.ok()
+}
+
+func reserveCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("reserve")
+	dto := domain.ReserveMoneyDTO{}
+	var amount moneyFlag
+	flags.UintVar(&dto.UserId, "user", 0, "user id")
+	flags.Var(&amount, "amount", "amount, e.g. 100.50")
+	currencyVar(flags, &dto.Currency)
+	flags.UintVar(&dto.ServiceId, "service", 0, "service id")
+	flags.UintVar(&dto.OrderId, "order", 0, "order id")
+	flags.StringVar(&dto.ServiceName, "service-name", "", "name of service if it is added to catalog by the reservation")
+	flags.StringVar(&dto.Description, "description", "", "description")
+	flags.Parse(args)
+	dto.Amount = amount.value
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	if err := srv.ReserveMoney(ctx, &dto); err != nil {
+		return err
+	}
+	return out.ok()
+}
+
+func recognizeCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("recognize")
+	dto := domain.RecognizeRevenueDTO{}
+	var amount moneyFlag
+	flags.UintVar(&dto.UserId, "user", 0, "user id")
+	flags.Var(&amount, "amount", "amount, e.g. 100.50")
+	currencyVar(flags, &dto.Currency)
+	flags.UintVar(&dto.ServiceId, "service", 0, "service id")
+	flags.UintVar(&dto.OrderId, "order", 0, "order id")
+	flags.Var((*splitFlag)(&dto.Splits), "split", "pay out USER=AMOUNT or USER=BASIS_POINTSbp, may be repeated")
+	flags.UintVar(&dto.RemainderTo, "remainder-to", 0, "recipient of rounding remainder, the service keeps it if not set")
+	flags.Parse(args)
+	dto.Amount = amount.value
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	if err := srv.RecognizeRevenue(ctx, &dto); err != nil {
+		return err
+	}
+	return out.ok()
+}
+
+func cancelCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("cancel")
+	dto := domain.CancelTransactionDTO{}
+	var amount moneyFlag
+	flags.UintVar(&dto.UserId, "user", 0, "user id")
+	flags.Var(&amount, "amount", "amount, e.g. 100.50")
+	currencyVar(flags, &dto.Currency)
+	flags.UintVar(&dto.ServiceId, "service", 0, "service id")
+	flags.UintVar(&dto.OrderId, "order", 0, "order id")
+	flags.Parse(args)
+	dto.Amount = amount.value
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	if err := srv.CancelTransaction(ctx, &dto); err != nil {
+		return err
+	}
+	return out.ok()
+}
+
+func adjustCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("adjust")
+	dto := domain.AdjustBalanceDTO{}
+	var amount moneyFlag
+	flags.UintVar(&dto.UserId, "user", 0, "user id")
+	flags.Var(&amount, "amount", "signed amount, e.g. -100.50")
+	currencyVar(flags, &dto.Currency)
+	flags.StringVar(&dto.Reason, "reason", "", "reason of adjustment, required")
+	flags.StringVar(&dto.Operator, "operator", os.Getenv("USER"), "name of support operator")
+	flags.BoolVar(&dto.Refund, "refund", false, "return money to user, allowed for frozen account")
+	flags.Parse(args)
+	dto.Amount = amount.value
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	if err := srv.AdjustBalance(ctx, &dto); err != nil {
+		return err
+	}
+	return out.ok()
+}
+
+func reportCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("report")
+	dto := domain.GetMonthlyReportDTO{}
+	now := time.Now()
+	flags.IntVar(&dto.Year, "year", now.Year(), "year")
+	flags.IntVar(&dto.Month, "month", int(now.Month()), "month")
+	file := flags.Bool("file", false, "write CSV report to file server directory and print its path")
+	flags.Parse(args)
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+
+	if *file {
+		path, err := srv.GetMonthlyReportPath(ctx, &dto)
+		if err != nil {
+			return err
+		}
+		return out.print(struct {
+			Path string `json:"path"`
+		}{Path: path}, []string{"PATH"}, [][]string{{path}})
+	}
+
+	report, err := srv.GetMonthlyReport(ctx, &dto)
+	if err != nil {
+		return err
+	}
+	if report == nil {
+		report = domain.MonthlyReport{}
+	}
+	rows := make([][]string, 0, len(report))
+	for _, row := range report {
+		rows = append(rows, []string{row.ServiceName, string(row.Currency), row.Revenue.String(), row.PromoRevenue.String(),
+			row.Payouts.String()})
+	}
+	return out.print(report, []string{"SERVICE", "CURRENCY", "REVENUE", "PROMO", "PAYOUTS"}, rows)
+}
+
+func ratesCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("rates")
+	file := flags.String("file", "", "load rates from CSV: base,quote,rate,valid_from[,valid_to]")
+	flags.Parse(args)
+
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		rates, err := service.ParseExchangeRates(data)
+		if err != nil {
+			return err
+		}
+		if err := srv.LoadExchangeRates(ctx, &domain.LoadExchangeRatesDTO{Rates: rates}); err != nil {
+			return err
+		}
+	}
+
+	rates, err := srv.GetExchangeRates(ctx)
+	if err != nil {
+		return err
+	}
+	if rates == nil {
+		rates = []domain.ExchangeRate{}
+	}
+	rows := make([][]string, 0, len(rates))
+	for _, rate := range rates {
+		validTo := ""
+		if rate.ValidTo != nil {
+			validTo = rate.ValidTo.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{string(rate.Base), string(rate.Quote), rate.Rate, rate.ValidFrom.Format(time.RFC3339), validTo})
+	}
+	return out.print(rates, []string{"BASE", "QUOTE", "RATE", "VALID_FROM", "VALID_TO"}, rows)
+}
+
+func convertCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("convert")
+	dto := domain.ConvertCurrencyDTO{}
+	var amount moneyFlag
+	flags.UintVar(&dto.UserId, "user", 0, "user id")
+	flags.Var(&amount, "amount", "amount in source currency, e.g. 100.50")
+	flags.StringVar((*string)(&dto.From), "from", "", "source currency")
+	flags.StringVar((*string)(&dto.To), "to", "", "target currency")
+	flags.UintVar(&dto.QuoteId, "quote", 0, "quote id, current rate is used if it isn't set")
+	flags.Parse(args)
+	dto.Amount = amount.value
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	conversion, err := srv.ConvertCurrency(ctx, &dto)
+	if err != nil {
+		return err
+	}
+	return out.print(&conversion, []string{"FROM", "TO", "RATE", "RESIDUE"}, [][]string{{
+		conversion.FromAmount.String() + " " + string(conversion.From),
+		conversion.ToAmount.String() + " " + string(conversion.To),
+		conversion.Rate,
+		conversion.Residue,
+	}})
+}
+
+func promoCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("promo")
+	dto := domain.GrantPromoDTO{}
+	var amount moneyFlag
+	var expires timeFlag
+	flags.UintVar(&dto.UserId, "user", 0, "user id")
+	flags.Var(&amount, "amount", "amount, e.g. 100.50")
+	currencyVar(flags, &dto.Currency)
+	flags.Var(&expires, "expires", "expiry time of credits")
+	flags.StringVar(&dto.Description, "description", "", "description")
+	flags.Parse(args)
+	dto.Amount = amount.value
+	if expires.value != nil {
+		dto.ExpiresAt = *expires.value
+	}
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	grant, err := srv.GrantPromo(ctx, &dto)
+	if err != nil {
+		return err
+	}
+	return out.print(&grant, []string{"ID", "USER_ID", "CURRENCY", "AMOUNT", "EXPIRES_AT"}, [][]string{{
+		strconv.Itoa(int(grant.Id)),
+		strconv.Itoa(int(grant.UserId)),
+		string(grant.Currency),
+		grant.Amount.String(),
+		grant.ExpiresAt.Format(time.RFC3339),
+	}})
+}
+
+func creditLimitCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("credit-limit")
+	dto := domain.SetCreditLimitDTO{}
+	var limit moneyFlag
+	flags.UintVar(&dto.UserId, "user", 0, "user id")
+	flags.Var(&limit, "limit", "credit limit, 0 disables credit")
+	currencyVar(flags, &dto.Currency)
+	flags.StringVar(&dto.Reason, "reason", "", "reason of change, required")
+	flags.StringVar(&dto.Operator, "operator", os.Getenv("USER"), "name of operator")
+	flags.Parse(args)
+	dto.Limit = limit.value
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	if !limit.set {
+		flags.Usage()
+		return fmt.Errorf("invalid arguments: limit: cannot be blank")
+	}
+	if err := srv.SetCreditLimit(ctx, &dto); err != nil {
+		return err
+	}
+	return out.ok()
+}
+
+func violationsCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("violations")
+	dto := domain.GetVelocityViolationsDTO{}
+	flags.UintVar(&dto.UserId, "user", 0, "user id, all users if not set")
+	flags.IntVar(&dto.Limit, "limit", 0, "max number of violations")
+	flags.Parse(args)
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	violations, err := srv.GetVelocityViolations(ctx, &dto)
+	if err != nil {
+		return err
+	}
+	if violations == nil {
+		violations = []domain.VelocityViolation{}
+	}
+
+	rows := make([][]string, 0, len(violations))
+	for _, v := range violations {
+		rows = append(rows, []string{v.CreatedAt.Format(time.RFC3339), strconv.Itoa(int(v.UserId)), strconv.Itoa(int(v.RuleId)),
+			v.Operation, v.Amount.String(), string(v.Currency), v.Used})
+	}
+	return out.print(violations, []string{"TIMESTAMP", "USER_ID", "RULE_ID", "OPERATION", "AMOUNT", "CURRENCY", "USED"}, rows)
+}
+
+func accountCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("account")
+	dto := domain.SetAccountStatusDTO{}
+	flags.UintVar(&dto.UserId, "user", 0, "user id")
+	flags.StringVar(&dto.Status, "status", "", "new status: ACTIVE, FROZEN or CLOSED, prints current status if not set")
+	flags.StringVar(&dto.Reason, "reason", "", "reason of change, required with status")
+	flags.StringVar(&dto.Operator, "operator", os.Getenv("USER"), "name of operator")
+	flags.Parse(args)
+
+	if dto.Status == "" {
+		getDTO := domain.GetAccountDTO{UserId: dto.UserId}
+		if err := validate(flags, getDTO); err != nil {
+			return err
+		}
+		account, err := srv.GetAccount(ctx, &getDTO)
+		if err != nil {
+			return err
+		}
+		return out.print(account, []string{"USER_ID", "STATUS"},
+			[][]string{{strconv.Itoa(int(account.UserId)), account.Status}})
+	}
+
+	dto.Status = strings.ToUpper(dto.Status)
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	if err := srv.SetAccountStatus(ctx, &dto); err != nil {
+		return err
+	}
+	return out.ok()
+}
+
+func createUserCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("create-user")
+	dto := domain.CreateUserDTO{}
+	var metadata string
+	flags.UintVar(&dto.UserId, "user", 0, "user id")
+	flags.StringVar(&dto.ExternalRef, "ref", "", "id of the user in external system")
+	flags.StringVar(&metadata, "metadata", "", "JSON object")
+	flags.Parse(args)
+	dto.Metadata = json.RawMessage(metadata)
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	user, err := srv.CreateUser(ctx, &dto)
+	if err != nil {
+		return err
+	}
+	return printUser(out, user)
+}
+
+func userCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("user")
+	dto := domain.GetUserDTO{}
+	flags.UintVar(&dto.UserId, "user", 0, "user id")
+	flags.Parse(args)
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	user, err := srv.GetUser(ctx, &dto)
+	if err != nil {
+		return err
+	}
+	return printUser(out, user)
+}
+
+func printUser(out *printer, user domain.User) error {
+	return out.print(user, []string{"USER_ID", "STATUS", "EXTERNAL_REF", "CREATED_AT", "METADATA"},
+		[][]string{{strconv.Itoa(int(user.Id)), user.Status, user.ExternalRef, user.CreatedAt.Format(time.RFC3339), string(user.Metadata)}})
+}
+
+func servicesCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("services")
+	dto := domain.GetServicesDTO{}
+	flags.StringVar(&dto.Category, "category", "", "category")
+	flags.BoolVar(&dto.ActiveOnly, "active", false, "only active services")
+	flags.Parse(args)
+
+	services, err := srv.GetServices(ctx, &dto)
+	if err != nil {
+		return err
+	}
+	if services == nil {
+		services = []domain.Service{}
+	}
+
+	rows := make([][]string, 0, len(services))
+	for _, s := range services {
+		rows = append(rows, []string{strconv.Itoa(int(s.Id)), s.Name, s.Category, strconv.FormatBool(s.Active),
+			s.DefaultPrice.String(), string(s.Currency)})
+	}
+	return out.print(services, []string{"ID", "NAME", "CATEGORY", "ACTIVE", "DEFAULT_PRICE", "CURRENCY"}, rows)
+}
+
+func orderCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("order")
+	dto := domain.ChangeOrderDTO{}
+	var recognize, cancel, refund bool
+	flags.UintVar(&dto.OrderId, "order", 0, "order id")
+	flags.UintVar(&dto.ServiceId, "service", 0, "service id of order line, all lines if not set")
+	flags.BoolVar(&recognize, "recognize", false, "recognize revenue of reserved lines")
+	flags.BoolVar(&cancel, "cancel", false, "cancel created or reserved lines")
+	flags.BoolVar(&refund, "refund", false, "refund fulfilled lines")
+	flags.StringVar(&dto.Description, "description", "", "description of refund")
+	flags.Parse(args)
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	var order domain.Order
+	var err error
+	switch {
+	case recognize:
+		order, err = srv.RecognizeOrder(ctx, &dto)
+	case cancel:
+		order, err = srv.CancelOrder(ctx, &dto)
+	case refund:
+		order, err = srv.RefundOrder(ctx, &dto)
+	default:
+		order, err = srv.GetOrder(ctx, &domain.GetOrderDTO{OrderId: dto.OrderId})
+	}
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(order.Lines))
+	for _, line := range order.Lines {
+		rows = append(rows, []string{strconv.Itoa(int(order.Id)), strconv.Itoa(int(order.UserId)), order.Status,
+			strconv.Itoa(int(line.ServiceId)), line.Amount.String(), string(order.Currency), line.Status})
+	}
+	return out.print(order, []string{"ID", "USER_ID", "STATUS", "SERVICE_ID", "AMOUNT", "CURRENCY", "LINE_STATUS"}, rows)
+}
+
+func subscriptionsCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("subscriptions")
+	dto := domain.SubscriptionDTO{}
+	var cancel, resume bool
+	flags.UintVar(&dto.UserId, "user", 0, "user id")
+	flags.UintVar(&dto.SubscriptionId, "subscription", 0, "subscription id, required to cancel or resume")
+	flags.BoolVar(&cancel, "cancel", false, "cancel subscription")
+	flags.BoolVar(&resume, "resume", false, "resume paused subscription")
+	flags.Parse(args)
+
+	var subscriptions []domain.Subscription
+	if cancel || resume {
+		if err := validate(flags, dto); err != nil {
+			return err
+		}
+		var subscription domain.Subscription
+		var err error
+		if cancel {
+			subscription, err = srv.CancelSubscription(ctx, &dto)
+		} else {
+			subscription, err = srv.ResumeSubscription(ctx, &dto)
+		}
+		if err != nil {
+			return err
+		}
+		subscriptions = []domain.Subscription{subscription}
+	} else {
+		getDTO := domain.GetSubscriptionsDTO{UserId: dto.UserId}
+		if err := validate(flags, getDTO); err != nil {
+			return err
+		}
+		var err error
+		subscriptions, err = srv.GetSubscriptions(ctx, &getDTO)
+		if err != nil {
+			return err
+		}
+		if subscriptions == nil {
+			subscriptions = []domain.Subscription{}
+		}
+	}
+
+	rows := make([][]string, 0, len(subscriptions))
+	for _, s := range subscriptions {
+		rows = append(rows, []string{strconv.Itoa(int(s.Id)), strconv.Itoa(int(s.ServiceId)), s.Amount.String(),
+			string(s.Currency), s.Period, s.Status, s.NextRunAt.Format(time.RFC3339), strconv.Itoa(s.Failures)})
+	}
+	return out.print(subscriptions,
+		[]string{"ID", "SERVICE_ID", "AMOUNT", "CURRENCY", "PERIOD", "STATUS", "NEXT_RUN_AT", "FAILURES"}, rows)
+}
+
+func escrowsCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("escrows")
+	var userId, escrowId uint
+	var release, refund, dispute bool
+	var reason string
+	flags.UintVar(&userId, "user", 0, "user id, lists escrows where user is buyer or seller")
+	flags.UintVar(&escrowId, "escrow", 0, "escrow id")
+	flags.BoolVar(&release, "release", false, "release escrow to seller")
+	flags.BoolVar(&refund, "refund", false, "refund escrow to buyer")
+	flags.BoolVar(&dispute, "dispute", false, "dispute held escrow")
+	flags.StringVar(&reason, "reason", "", "reason of dispute")
+	flags.Parse(args)
+
+	var escrows []domain.Escrow
+	if escrowId == 0 {
+		dto := domain.GetEscrowsDTO{UserId: userId}
+		if err := validate(flags, dto); err != nil {
+			return err
+		}
+		var err error
+		escrows, err = srv.GetEscrows(ctx, &dto)
+		if err != nil {
+			return err
+		}
+		if escrows == nil {
+			escrows = []domain.Escrow{}
+		}
+	} else {
+		var escrow domain.Escrow
+		var err error
+		dto := domain.GetEscrowDTO{EscrowId: escrowId}
+		switch {
+		case release:
+			escrow, err = srv.ReleaseEscrow(ctx, &dto)
+		case refund:
+			escrow, err = srv.RefundEscrow(ctx, &dto)
+		case dispute:
+			disputeDTO := domain.DisputeEscrowDTO{EscrowId: escrowId, Reason: reason}
+			if err := validate(flags, disputeDTO); err != nil {
+				return err
+			}
+			escrow, err = srv.DisputeEscrow(ctx, &disputeDTO)
+		default:
+			escrow, err = srv.GetEscrow(ctx, &dto)
+		}
+		if err != nil {
+			return err
+		}
+		escrows = []domain.Escrow{escrow}
+	}
+
+	rows := make([][]string, 0, len(escrows))
+	for _, e := range escrows {
+		rows = append(rows, []string{strconv.Itoa(int(e.Id)), strconv.Itoa(int(e.BuyerId)), strconv.Itoa(int(e.SellerId)),
+			e.Amount.String(), string(e.Currency), e.Status, e.TimeoutPolicy, e.ExpiresAt.Format(time.RFC3339)})
+	}
+	return out.print(escrows,
+		[]string{"ID", "BUYER_ID", "SELLER_ID", "AMOUNT", "CURRENCY", "STATUS", "TIMEOUT_POLICY", "EXPIRES_AT"}, rows)
+}
+
+func auditCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("audit")
+	dto := domain.GetAuditLogDTO{}
+	var from, to timeFlag
+	flags.UintVar(&dto.UserId, "user", 0, "user id, all users if not set")
+	flags.StringVar(&dto.RequestId, "request", "", "request id")
+	flags.Var(&from, "from", "show entries since this time")
+	flags.Var(&to, "to", "show entries before this time")
+	flags.UintVar(&dto.AfterId, "after", 0, "show entries after this id")
+	flags.IntVar(&dto.Limit, "limit", service.MaxHistoryRowPerRequest, "max number of entries")
+	exportCSV := flags.Bool("csv", false, "write all matching entries as CSV, limit is ignored")
+	verify := flags.Bool("verify", false, "verify hash chain of the whole log")
+	flags.Parse(args)
+	dto.From, dto.To = from.value, to.value
+
+	if *verify {
+		verification, err := srv.VerifyAuditLog(ctx)
+		if err != nil {
+			return err
+		}
+		result := "OK"
+		if verification.BrokenId != 0 {
+			result = fmt.Sprintf("BROKEN AT %d", verification.BrokenId)
+		}
+		return out.print(verification, []string{"RESULT", "CHECKED", "LAST_ID", "LAST_HASH"},
+			[][]string{{result, strconv.Itoa(verification.Checked), strconv.Itoa(int(verification.LastId)), verification.LastHash}})
+	}
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	if *exportCSV {
+		return srv.ExportAuditLog(ctx, &dto, out.w)
+	}
+	entries, err := srv.GetAuditLog(ctx, &dto)
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = []domain.AuditEntry{}
+	}
+
+	rows := make([][]string, 0, len(entries))
+	for _, e := range entries {
+		rows = append(rows, []string{strconv.Itoa(int(e.Id)), e.CreatedAt.Format(time.RFC3339), e.Actor, e.Operation,
+			strconv.Itoa(int(e.UserId)), string(e.Currency), e.BalanceBefore.String() + " -> " + e.BalanceAfter.String(),
+			e.ReservedBefore.String() + " -> " + e.ReservedAfter.String(), e.RequestId})
+	}
+	return out.print(entries, []string{"ID", "TIMESTAMP", "ACTOR", "OPERATION", "USER_ID", "CURRENCY", "BALANCE", "RESERVED",
+		"REQUEST_ID"}, rows)
+}
+
+func chainCmd(ctx context.Context, srv service.Service, out *printer, args []string) error {
+	flags := newFlagSet("chain")
+	dto := domain.GetChainRootsDTO{}
+	var from, to timeFlag
+	flags.Var(&from, "from", "first day of roots")
+	flags.Var(&to, "to", "last day of roots")
+	verify := flags.Bool("verify", false, "verify hash chain of all transactions instead of printing roots")
+	flags.Parse(args)
+	dto.From, dto.To = from.value, to.value
+
+	if *verify {
+		verification, err := srv.VerifyTransactionChain(ctx)
+		if err != nil {
+			return err
+		}
+		result := "OK"
+		if verification.BrokenSeq != 0 {
+			result = fmt.Sprintf("BROKEN AT %d (TRANSACTION %d)", verification.BrokenSeq, verification.BrokenId)
+		} else if verification.Unchained != 0 {
+			result = fmt.Sprintf("%d UNCHAINED", verification.Unchained)
+		}
+		return out.print(verification, []string{"RESULT", "CHECKED", "LAST_SEQ", "LAST_HASH"},
+			[][]string{{result, strconv.Itoa(verification.Checked), strconv.Itoa(int(verification.LastSeq)), verification.LastHash}})
+	}
+
+	if err := validate(flags, dto); err != nil {
+		return err
+	}
+	roots, err := srv.GetChainRoots(ctx, &dto)
+	if err != nil {
+		return err
+	}
+	if roots == nil {
+		roots = []domain.ChainRoot{}
+	}
+
+	rows := make([][]string, 0, len(roots))
+	for _, root := range roots {
+		rows = append(rows, []string{root.Day.Format("2006-01-02"), strconv.Itoa(root.Count), strconv.Itoa(int(root.LastSeq)),
+			root.RootHash})
+	}
+	return out.print(roots, []string{"DAY", "COUNT", "LAST_SEQ", "ROOT_HASH"}, rows)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/manimadzis/avito-job/internal/config"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository/postgres"
+	"github.com/manimadzis/avito-job/internal/service"
+	dbclient "github.com/manimadzis/avito-job/pkg/dbclient/postgres"
+	"github.com/manimadzis/avito-job/pkg/logging"
+	"os"
+	"os/signal"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, srv service.Service, out *printer, args []string) error
+}
+
+var commands map[string]command
+
+// commands are initialized in init because they refer to the map for usage
+func init() {
+	commands = map[string]command{
+		"create-user":   {"create-user -user ID [-ref REF] [-metadata JSON]", createUserCmd},
+		"user":          {"user -user ID", userCmd},
+		"balance":       {"balance -user ID [-at TIME | -reservations] [-currency CODE]", balanceCmd},
+		"history":       {"history -user ID [-limit N] [-offset N] [-sort timestamp|amount] [-reverse] [-from TIME] [-to TIME]", historyCmd},
+		"replenish":     {"replenish -user ID -amount AMOUNT [-currency CODE] [-description TEXT] [-key KEY]", replenishCmd},
+		"reserve":       {"reserve -user ID -amount AMOUNT [-currency CODE] -service ID -order ID [-service-name NAME] [-description TEXT]", reserveCmd},
+		"recognize":     {"recognize -user ID -amount AMOUNT [-currency CODE] -service ID -order ID [-split USER=AMOUNT|USER=BPbp]... [-remainder-to USER]", recognizeCmd},
+		"cancel":        {"cancel -user ID -amount AMOUNT [-currency CODE] -service ID -order ID", cancelCmd},
+		"order":         {"order -order ID [-service ID] [-recognize | -cancel | -refund [-description TEXT]]", orderCmd},
+		"adjust":        {"adjust -user ID -amount [-]AMOUNT [-currency CODE] -reason TEXT [-operator NAME] [-refund]", adjustCmd},
+		"account":       {"account -user ID [-status ACTIVE|FROZEN|CLOSED -reason TEXT] [-operator NAME]", accountCmd},
+		"report":        {"report -year YEAR -month MONTH [-file]", reportCmd},
+		"credit-limit":  {"credit-limit -user ID -limit AMOUNT [-currency CODE] -reason TEXT [-operator NAME]", creditLimitCmd},
+		"promo":         {"promo -user ID -amount AMOUNT [-currency CODE] -expires TIME [-description TEXT]", promoCmd},
+		"rates":         {"rates [-file PATH]", ratesCmd},
+		"services":      {"services [-category NAME] [-active]", servicesCmd},
+		"convert":       {"convert -user ID -amount AMOUNT -from CODE -to CODE [-quote ID]", convertCmd},
+		"violations":    {"violations [-user ID] [-limit N]", violationsCmd},
+		"subscriptions": {"subscriptions -user ID [-subscription ID -cancel | -resume]", subscriptionsCmd},
+		"escrows":       {"escrows -user ID | -escrow ID [-release | -refund | -dispute -reason TEXT]", escrowsCmd},
+		"audit":         {"audit [-user ID] [-request ID] [-from TIME] [-to TIME] [-after ID] [-limit N] [-csv] | -verify", auditCmd},
+		"chain":         {"chain [-from DATE] [-to DATE] | -verify", chainCmd},
+	}
+}
+
+func main() {
+	flags := flag.NewFlagSet("avitoctl", flag.ExitOnError)
+	configPath := flags.String("config", "./configs/config.yaml", "path to config")
+	output := flags.String("o", outputTable, "output format: table or json")
+	flags.Usage = func() { usage(flags) }
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 || (*output != outputTable && *output != outputJSON) {
+		flags.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	// logs go to stdout and would break the output
+	if err := logging.Init("panic"); err != nil {
+		fatal(err)
+	}
+	logger := logging.Get()
+
+	db, err := dbclient.New(dbclient.Config{
+		Host:     conf.DBHost,
+		Port:     conf.DBPort,
+		Username: conf.DBUsername,
+		Password: conf.DBPassword,
+		Database: conf.DatabaseName,
+	})
+	if err != nil {
+		fatal(fmt.Errorf("can't connect to database: %v", err))
+	}
+	defer db.Close()
+
+	repo := postgres.NewRepository(db, *logger)
+	srv := service.NewService(&service.Config{
+		FileServerDirectory:     conf.FileServerDirectory,
+		FXQuoteTTL:              conf.FXQuoteTTL,
+		PromoMaxBasisPoints:     conf.PromoMaxBasisPoints,
+		PromoExcludedServices:   conf.PromoExcludedServices,
+		ImplicitUserCreation:    conf.ImplicitUserCreation,
+		ImplicitServiceCreation: conf.ImplicitServiceCreation,
+	}, repo, nil, *logger)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx = domain.WithAuditInfo(ctx, domain.AuditInfo{
+		Actor:     os.Getenv("USER"),
+		Client:    "avitoctl",
+		Operation: "avitoctl " + flags.Arg(0),
+	})
+	if err := cmd.run(ctx, srv, &printer{format: *output, w: os.Stdout}, flags.Args()[1:]); err != nil {
+		db.Close()
+		fatal(err)
+	}
+}
+
+func usage(flags *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "Usage: avitoctl [-config PATH] [-o table|json] COMMAND [FLAGS]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nGlobal flags:")
+	flags.PrintDefaults()
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "avitoctl: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/manimadzis/avito-job/internal/config"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository/postgres"
+	"github.com/manimadzis/avito-job/internal/service"
+	dbclient "github.com/manimadzis/avito-job/pkg/dbclient/postgres"
+	"github.com/manimadzis/avito-job/pkg/logging"
+	"log"
+	"os"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// Imports balance replenishments from CSV: user_id, amount, description[, key].
+// Only validates the file unless -commit is passed
+func main() {
+	configPath := flag.String("config", "./configs/config.yaml", "path to config")
+	filePath := flag.String("file", "", "path to CSV file")
+	commit := flag.Bool("commit", false, "replenish balances, dry run otherwise")
+	comma := flag.String("comma", ",", "CSV delimiter")
+	currency := flag.String("currency", string(domain.DefaultCurrency), "currency of all lines")
+	flag.Parse()
+
+	if *filePath == "" || utf8.RuneCountInString(*comma) != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Cant load config: %v", err)
+	}
+	if err := logging.Init(conf.LogLevel); err != nil {
+		log.Fatalf("Cant' init logger: %v", err)
+	}
+	logger := logging.Get()
+
+	data, err := os.ReadFile(*filePath)
+	if err != nil {
+		log.Fatalf("Can't read %s: %v", *filePath, err)
+	}
+
+	db, err := dbclient.New(dbclient.Config{
+		Host:     conf.DBHost,
+		Port:     conf.DBPort,
+		Username: conf.DBUsername,
+		Password: conf.DBPassword,
+		Database: conf.DatabaseName,
+	})
+	if err != nil {
+		log.Fatalf("Can't connect to database: %v", err)
+	}
+	defer db.Close()
+
+	repo := postgres.NewRepository(db, *logger)
+	srv := service.NewService(&service.Config{
+		FileServerDirectory:     conf.FileServerDirectory,
+		FXQuoteTTL:              conf.FXQuoteTTL,
+		PromoMaxBasisPoints:     conf.PromoMaxBasisPoints,
+		PromoExcludedServices:   conf.PromoExcludedServices,
+		ImplicitUserCreation:    conf.ImplicitUserCreation,
+		ImplicitServiceCreation: conf.ImplicitServiceCreation,
+	}, repo, nil, *logger)
+
+	dto := domain.ImportReplenishmentsDTO{Data: data, Commit: *commit, Currency: domain.Currency(*currency)}
+	if err := dto.Validate(); err != nil {
+		log.Fatalf("Invalid arguments: %v", err)
+	}
+	dto.Comma, _ = utf8.DecodeRuneInString(*comma)
+	report, err := srv.ImportReplenishments(context.Background(), &dto)
+	if err != nil && !errors.Is(err, service.ErrImportHasInvalidLines) {
+		log.Fatalf("Import failed: %v", err)
+	}
+
+	printReport(&report)
+	if err != nil {
+		fmt.Println("Nothing imported: fix invalid lines and try again")
+		os.Exit(1)
+	}
+	if !report.Committed {
+		fmt.Println("Dry run: pass -commit to replenish balances")
+	}
+	if report.Invalid > 0 || report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func printReport(report *domain.ImportReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tUSER_ID\tAMOUNT\tSTATUS\tERROR")
+	for _, line := range report.Lines {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", line.Line, line.UserId, line.Amount.String(), line.Status, line.Error)
+	}
+	w.Flush()
+	fmt.Printf("total: %d, valid: %d, invalid: %d, duplicates: %d, imported: %d, failed: %d\n",
+		report.Total, report.Valid, report.Invalid, report.Duplicates, report.Imported, report.Failed)
+}
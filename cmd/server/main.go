@@ -34,16 +34,16 @@ func main() {
 	wg.Add(2)
 
 	go func() {
-		s := make(chan os.Signal)
+		s := make(chan os.Signal, 1)
 		signal.Notify(s, os.Interrupt)
 		<-s
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
 
-		if err := a.Shutdown(ctx); err != nil && err != http.ErrServerClosed {
-			logger.Fatalf("Error occured while shuting down server: %v", err)
-		} else if err == context.Canceled {
-			cancel()
+		if err := a.Shutdown(ctx); err == context.DeadlineExceeded {
 			logger.Fatalf("Can't close server. Timeout")
+		} else if err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("Error occured while shuting down server: %v", err)
 		}
 
 		logger.Info("Shutdown server")
@@ -1,6 +1,6 @@
 module github.com/manimadzis/avito-job
 
-go 1.19
+go 1.20
 
 require (
 	github.com/go-ozzo/ozzo-validation v3.6.0+incompatible
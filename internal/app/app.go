@@ -3,22 +3,40 @@ package app
 import (
 	"context"
 	"github.com/jmoiron/sqlx"
+	"github.com/manimadzis/avito-job/internal/archive"
 	"github.com/manimadzis/avito-job/internal/config"
+	"github.com/manimadzis/avito-job/internal/escrow"
+	"github.com/manimadzis/avito-job/internal/promo"
 	"github.com/manimadzis/avito-job/internal/repository"
 	"github.com/manimadzis/avito-job/internal/repository/postgres"
 	"github.com/manimadzis/avito-job/internal/server"
 	"github.com/manimadzis/avito-job/internal/service"
+	"github.com/manimadzis/avito-job/internal/snapshot"
+	"github.com/manimadzis/avito-job/internal/subscription"
+	"github.com/manimadzis/avito-job/internal/webhook"
 	dbclient "github.com/manimadzis/avito-job/pkg/dbclient/postgres"
 	"github.com/manimadzis/avito-job/pkg/logging"
+	"sync"
 )
 
 type App struct {
-	config  *config.Config
-	logger  logging.Logger
-	db      *sqlx.DB
-	repo    repository.Repository
-	service service.Service
-	server  server.Server
+	config     *config.Config
+	logger     logging.Logger
+	db         *sqlx.DB
+	repo       repository.Repository
+	notifier   repository.Notifier
+	service    service.Service
+	server     server.Server
+	dispatcher webhook.Dispatcher
+	sweeper    promo.Sweeper
+	scheduler  subscription.Scheduler
+	escrows    escrow.Sweeper
+	snapshots  snapshot.Snapshotter
+	archiver   archive.Archiver
+
+	// cancel stops background workers
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
 }
 
 func NewApp(config *config.Config, logger logging.Logger) *App {
@@ -31,25 +49,93 @@ func NewApp(config *config.Config, logger logging.Logger) *App {
 func (a *App) Start() error {
 	a.logger.Info("Starting app...")
 	var err error
-	a.db, err = dbclient.New(dbclient.Config{
+	dbConfig := dbclient.Config{
 		Host:     a.config.DBHost,
 		Port:     a.config.DBPort,
 		Username: a.config.DBUsername,
 		Password: a.config.DBPassword,
 		Database: a.config.DatabaseName,
-	})
+	}
+	a.db, err = dbclient.New(dbConfig)
 	if err != nil {
 		return err
 	}
 	defer a.db.Close()
 	a.repo = postgres.NewRepository(a.db, a.logger)
-	a.service = service.NewService(&service.Config{FileServerDirectory: a.config.FileServerDirectory}, a.repo, a.logger)
+	a.notifier = postgres.NewNotifier(dbclient.NewListener(dbConfig, nil), a.logger)
+	a.service = service.NewService(&service.Config{
+		FileServerDirectory:     a.config.FileServerDirectory,
+		FXQuoteTTL:              a.config.FXQuoteTTL,
+		PromoMaxBasisPoints:     a.config.PromoMaxBasisPoints,
+		PromoExcludedServices:   a.config.PromoExcludedServices,
+		ImplicitUserCreation:    a.config.ImplicitUserCreation,
+		ImplicitServiceCreation: a.config.ImplicitServiceCreation,
+	}, a.repo, a.notifier, a.logger)
 	a.server = server.NewServer(&server.Config{
-		Host: a.config.ServerHost,
-		Port: a.config.ServerPort,
+		Host:           a.config.ServerHost,
+		Port:           a.config.ServerPort,
+		APITokens:      a.config.APITokens,
+		TrustedProxies: a.config.TrustedProxies,
 	}, a.service, a.logger)
+	a.dispatcher = webhook.NewDispatcher(&webhook.Config{
+		PollInterval:   a.config.WebhookPollInterval,
+		BatchSize:      a.config.WebhookBatchSize,
+		RequestTimeout: a.config.WebhookRequestTimeout,
+		MaxAttempts:    a.config.WebhookMaxAttempts,
+		BaseBackoff:    a.config.WebhookBaseBackoff,
+		MaxBackoff:     a.config.WebhookMaxBackoff,
+	}, a.repo, a.logger)
+	a.sweeper = promo.NewSweeper(&promo.Config{
+		SweepInterval: a.config.PromoSweepInterval,
+	}, a.repo, a.logger)
+	a.scheduler = subscription.NewScheduler(&subscription.Config{
+		PollInterval:  a.config.SubscriptionPollInterval,
+		BatchSize:     a.config.SubscriptionBatchSize,
+		RetryInterval: a.config.SubscriptionRetryInterval,
+		GracePeriod:   a.config.SubscriptionGracePeriod,
+		MaxFailures:   a.config.SubscriptionMaxFailures,
+	}, a.repo, a.service, a.logger)
+	a.escrows = escrow.NewSweeper(&escrow.Config{
+		SweepInterval: a.config.EscrowSweepInterval,
+		BatchSize:     a.config.EscrowBatchSize,
+	}, a.repo, a.logger)
+	a.snapshots = snapshot.NewSnapshotter(&snapshot.Config{
+		Interval: a.config.BalanceSnapshotInterval,
+		Lag:      a.config.BalanceSnapshotLag,
+	}, a.repo, a.logger)
+	a.archiver = archive.NewArchiver(&archive.Config{
+		Interval:       a.config.TransactionArchiveInterval,
+		Horizon:        a.config.TransactionArchiveHorizon,
+		EventRetention: a.config.UserEventRetention,
+		BatchSize:      a.config.TransactionArchiveBatchSize,
+	}, a.repo, a.logger)
+
+	var ctx context.Context
+	ctx, a.cancel = context.WithCancel(context.Background())
+	a.runWorker(func() { a.dispatcher.Run(ctx) })
+	a.runWorker(func() { a.sweeper.Run(ctx) })
+	a.runWorker(func() { a.scheduler.Run(ctx) })
+	a.runWorker(func() { a.escrows.Run(ctx) })
+	a.runWorker(func() { a.snapshots.Run(ctx) })
+	a.runWorker(func() { a.archiver.Run(ctx) })
+	a.runWorker(func() {
+		if err := a.notifier.Run(ctx); err != nil {
+			a.logger.Errorf("Notifier stopped: %v", err)
+		}
+	})
+
+	err = a.server.ListenAndServe()
+	a.cancel()
+	a.workers.Wait()
+	return err
+}
 
-	return a.server.ListenAndServe()
+func (a *App) runWorker(worker func()) {
+	a.workers.Add(1)
+	go func() {
+		defer a.workers.Done()
+		worker()
+	}()
 }
 
 func (a *App) Shutdown(ctx context.Context) error {
@@ -0,0 +1,86 @@
+package archive
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"github.com/manimadzis/avito-job/pkg/logging"
+	"time"
+)
+
+// Archiver moves transactions settled earlier than Horizon ago to archive every Interval.
+// History and reports still reach them, balances are kept in snapshots.
+// User events older than EventRetention are removed at the same time
+type Archiver interface {
+	// Run blocks until ctx is done
+	Run(ctx context.Context)
+}
+
+type archiver struct {
+	config *Config
+	repo   repository.Repository
+	logger logging.Logger
+}
+
+func NewArchiver(config *Config, repo repository.Repository, logger logging.Logger) Archiver {
+	return &archiver{
+		config: config,
+		repo:   repo,
+		logger: logger,
+	}
+}
+
+func (a *archiver) Run(ctx context.Context) {
+	a.logger.Info("Starting transaction archiver...")
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			a.logger.Info("Transaction archiver stopped")
+			return
+		case <-ticker.C:
+			a.archive(ctx)
+			a.pruneEvents(ctx)
+		}
+	}
+}
+
+// archive moves batches until the last one isn't full
+func (a *archiver) archive(ctx context.Context) {
+	before := time.Now().Add(-a.config.Horizon)
+	total := 0
+	for ctx.Err() == nil {
+		archived, err := a.repo.ArchiveTransactions(ctx, before, a.config.BatchSize)
+		if err != nil {
+			a.logger.Errorf("Can't archive transactions: %v", err)
+			break
+		}
+		total += archived
+		if archived < a.config.BatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		a.logger.Infof("Archived %d transactions", total)
+	}
+}
+
+// pruneEvents removes batches of user events until the last one isn't full
+func (a *archiver) pruneEvents(ctx context.Context) {
+	before := time.Now().Add(-a.config.EventRetention)
+	total := 0
+	for ctx.Err() == nil {
+		pruned, err := a.repo.PruneUserEvents(ctx, before, a.config.BatchSize)
+		if err != nil {
+			a.logger.Errorf("Can't prune user events: %v", err)
+			break
+		}
+		total += pruned
+		if pruned < a.config.BatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		a.logger.Infof("Pruned %d user events", total)
+	}
+}
@@ -0,0 +1,12 @@
+package archive
+
+import "time"
+
+type Config struct {
+	Interval time.Duration
+	// Horizon is age of settlement after which transactions are archived
+	Horizon time.Duration
+	// EventRetention is how long user events are kept to resume event streams
+	EventRetention time.Duration
+	BatchSize      int
+}
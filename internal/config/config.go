@@ -2,6 +2,7 @@ package config
 
 import (
 	"github.com/spf13/viper"
+	"time"
 )
 
 type Config struct {
@@ -14,10 +15,71 @@ type Config struct {
 	DatabaseName        string `mapstructure:"database_name"`
 	LogLevel            string `mapstructure:"log_level"`
 	FileServerDirectory string `mapstructure:"file_server_directory"`
+
+	// APITokens maps actor name to API token sent as Authorization: Bearer <token>
+	APITokens      map[string]string `mapstructure:"api_tokens"`
+	TrustedProxies []string          `mapstructure:"trusted_proxies"`
+
+	WebhookPollInterval   time.Duration `mapstructure:"webhook_poll_interval"`
+	WebhookBatchSize      int           `mapstructure:"webhook_batch_size"`
+	WebhookRequestTimeout time.Duration `mapstructure:"webhook_request_timeout"`
+	WebhookMaxAttempts    int           `mapstructure:"webhook_max_attempts"`
+	WebhookBaseBackoff    time.Duration `mapstructure:"webhook_base_backoff"`
+	WebhookMaxBackoff     time.Duration `mapstructure:"webhook_max_backoff"`
+
+	FXQuoteTTL time.Duration `mapstructure:"fx_quote_ttl"`
+
+	PromoMaxBasisPoints   int64         `mapstructure:"promo_max_basis_points"`
+	PromoExcludedServices []uint        `mapstructure:"promo_excluded_services"`
+	PromoSweepInterval    time.Duration `mapstructure:"promo_sweep_interval"`
+
+	SubscriptionPollInterval  time.Duration `mapstructure:"subscription_poll_interval"`
+	SubscriptionBatchSize     int           `mapstructure:"subscription_batch_size"`
+	SubscriptionRetryInterval time.Duration `mapstructure:"subscription_retry_interval"`
+	SubscriptionGracePeriod   time.Duration `mapstructure:"subscription_grace_period"`
+	SubscriptionMaxFailures   int           `mapstructure:"subscription_max_failures"`
+
+	EscrowSweepInterval time.Duration `mapstructure:"escrow_sweep_interval"`
+	EscrowBatchSize     int           `mapstructure:"escrow_batch_size"`
+
+	BalanceSnapshotInterval time.Duration `mapstructure:"balance_snapshot_interval"`
+	BalanceSnapshotLag      time.Duration `mapstructure:"balance_snapshot_lag"`
+
+	TransactionArchiveInterval  time.Duration `mapstructure:"transaction_archive_interval"`
+	TransactionArchiveHorizon   time.Duration `mapstructure:"transaction_archive_horizon"`
+	TransactionArchiveBatchSize int           `mapstructure:"transaction_archive_batch_size"`
+	UserEventRetention          time.Duration `mapstructure:"user_event_retention"`
+
+	ImplicitUserCreation    bool `mapstructure:"implicit_user_creation"`
+	ImplicitServiceCreation bool `mapstructure:"implicit_service_creation"`
 }
 
 func Load(src string) (*Config, error) {
 	viper.SetConfigFile(src)
+	viper.SetDefault("webhook_poll_interval", time.Second)
+	viper.SetDefault("webhook_batch_size", 50)
+	viper.SetDefault("webhook_request_timeout", 5*time.Second)
+	viper.SetDefault("webhook_max_attempts", 10)
+	viper.SetDefault("webhook_base_backoff", 5*time.Second)
+	viper.SetDefault("webhook_max_backoff", time.Hour)
+	viper.SetDefault("fx_quote_ttl", 30*time.Second)
+	viper.SetDefault("promo_max_basis_points", 10000)
+	viper.SetDefault("promo_sweep_interval", time.Minute)
+	viper.SetDefault("subscription_poll_interval", time.Minute)
+	viper.SetDefault("subscription_batch_size", 50)
+	viper.SetDefault("subscription_retry_interval", time.Hour)
+	viper.SetDefault("subscription_grace_period", 72*time.Hour)
+	viper.SetDefault("subscription_max_failures", 3)
+	viper.SetDefault("escrow_sweep_interval", time.Minute)
+	viper.SetDefault("escrow_batch_size", 50)
+	viper.SetDefault("balance_snapshot_interval", time.Hour)
+	viper.SetDefault("balance_snapshot_lag", 10*time.Minute)
+	viper.SetDefault("transaction_archive_interval", 24*time.Hour)
+	viper.SetDefault("transaction_archive_horizon", 365*24*time.Hour)
+	viper.SetDefault("transaction_archive_batch_size", 1000)
+	viper.SetDefault("user_event_retention", 72*time.Hour)
+	viper.SetDefault("implicit_user_creation", true)
+	viper.SetDefault("implicit_service_creation", true)
 
 	err := viper.ReadInConfig()
 	if err != nil {
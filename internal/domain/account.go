@@ -0,0 +1,33 @@
+package domain
+
+import "time"
+
+const (
+	AccountStatusActive = "ACTIVE"
+	// AccountStatusFrozen allows only reads, cancellations of reservations and support refunds
+	AccountStatusFrozen = "FROZEN"
+	// AccountStatusClosed allows only reads. Closed account can't be reopened
+	AccountStatusClosed = "CLOSED"
+)
+
+var AccountStatuses = []interface{}{
+	AccountStatusActive,
+	AccountStatusFrozen,
+	AccountStatusClosed,
+}
+
+type Account struct {
+	UserId uint   `json:"user_id" db:"id"`
+	Status string `json:"status" db:"status"`
+}
+
+// AccountStatusChange is a record of audit trail of account statuses
+type AccountStatusChange struct {
+	Id        uint      `json:"id" db:"id"`
+	UserId    uint      `json:"user_id" db:"user_id"`
+	OldStatus string    `json:"old_status" db:"old_status"`
+	NewStatus string    `json:"new_status" db:"new_status"`
+	Operator  string    `json:"operator" db:"operator"`
+	Reason    string    `json:"reason" db:"reason"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
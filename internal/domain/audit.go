@@ -0,0 +1,63 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// AuditInfo describes who makes the change. It is attached to context by the caller
+// and written to audit log in the same transaction as money changes
+type AuditInfo struct {
+	Actor     string
+	Client    string
+	RequestId string
+	SourceIP  string
+	Operation string
+	// PayloadHash returns sha256 of request body read so far in hex, it may be nil
+	PayloadHash func() string
+}
+
+type auditInfoKey struct{}
+
+func WithAuditInfo(ctx context.Context, info AuditInfo) context.Context {
+	return context.WithValue(ctx, auditInfoKey{}, info)
+}
+
+func AuditInfoFrom(ctx context.Context) (AuditInfo, bool) {
+	info, ok := ctx.Value(auditInfoKey{}).(AuditInfo)
+	return info, ok
+}
+
+// AuditEntry is change of wallet balances or new state of another entity as json in Details.
+// Hash covers the entry and PrevHash, so changed or removed entries break the chain
+type AuditEntry struct {
+	Id             uint            `json:"id" db:"id"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+	Actor          string          `json:"actor" db:"actor"`
+	Client         string          `json:"client" db:"client"`
+	RequestId      string          `json:"request_id" db:"request_id"`
+	SourceIP       string          `json:"source_ip" db:"source_ip"`
+	Operation      string          `json:"operation" db:"operation"`
+	Entity         string          `json:"entity" db:"entity"`
+	EntityId       string          `json:"entity_id" db:"entity_id"`
+	UserId         uint            `json:"user_id" db:"user_id"`
+	Currency       Currency        `json:"currency" db:"currency"`
+	BalanceBefore  Money           `json:"balance_before" db:"balance_before"`
+	BalanceAfter   Money           `json:"balance_after" db:"balance_after"`
+	ReservedBefore Money           `json:"reserved_before" db:"reserved_before"`
+	ReservedAfter  Money           `json:"reserved_after" db:"reserved_after"`
+	Details        json.RawMessage `json:"details" db:"details"`
+	PayloadHash    string          `json:"payload_hash" db:"payload_hash"`
+	PrevHash       string          `json:"prev_hash" db:"prev_hash"`
+	Hash           string          `json:"hash" db:"hash"`
+}
+
+// AuditVerification is result of walking the hash chain. BrokenId is the first entry
+// which doesn't match its hash or the previous entry, zero if the chain is intact
+type AuditVerification struct {
+	Checked  int    `json:"checked" db:"checked"`
+	BrokenId uint   `json:"broken_id" db:"broken_id"`
+	LastId   uint   `json:"last_id" db:"last_id"`
+	LastHash string `json:"last_hash" db:"last_hash"`
+}
@@ -0,0 +1,44 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// BatchOperation is one of ReplenishBalanceDTO, ReserveMoneyDTO, RecognizeRevenueDTO
+// or CancelTransactionDTO selected by "type" field
+type BatchOperation struct {
+	Type string
+	DTO  DTO
+}
+
+func (o *BatchOperation) UnmarshalJSON(data []byte) error {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return err
+	}
+
+	switch head.Type {
+	case BatchOperationReplenish:
+		o.DTO = &ReplenishBalanceDTO{}
+	case BatchOperationReserve:
+		o.DTO = &ReserveMoneyDTO{}
+	case BatchOperationRecognize:
+		o.DTO = &RecognizeRevenueDTO{}
+	case BatchOperationCancel:
+		o.DTO = &CancelTransactionDTO{}
+	default:
+		return fmt.Errorf("unknown operation type %q", head.Type)
+	}
+	o.Type = head.Type
+	return json.Unmarshal(data, o.DTO)
+}
+
+type BatchResult struct {
+	Index int    `json:"index"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	Err   error  `json:"-"`
+}
@@ -0,0 +1,23 @@
+package domain
+
+import "time"
+
+// TransactionChainVerification is result of walking the transaction hash chain of transactions and their
+// settlements. BrokenSeq and BrokenId refer to the first broken link and its transaction, zero if the chain
+// is intact. Unchained is number of transactions without hash, they are written bypassing the chain
+type TransactionChainVerification struct {
+	Checked   int    `json:"checked" db:"checked"`
+	BrokenSeq uint   `json:"broken_seq" db:"broken_seq"`
+	BrokenId  uint   `json:"broken_id" db:"broken_id"`
+	Unchained int    `json:"unchained" db:"unchained"`
+	LastSeq   uint   `json:"last_seq" db:"last_seq"`
+	LastHash  string `json:"last_hash" db:"last_hash"`
+}
+
+// ChainRoot is hash of the last link chained by the end of Day. It covers all links before
+type ChainRoot struct {
+	Day      time.Time `json:"day" db:"day"`
+	Count    int       `json:"count" db:"count"`
+	LastSeq  uint      `json:"last_seq" db:"last_seq"`
+	RootHash string    `json:"root_hash" db:"root_hash"`
+}
@@ -4,3 +4,25 @@ const (
 	GetHistoryDTOSortByTimestamp = "timestamp"
 	GetHistoryDTOSortByAmount    = "amount"
 )
+
+const (
+	BatchModeAtomic     = "atomic"
+	BatchModeBestEffort = "best_effort"
+)
+
+const (
+	BatchOperationReplenish = "replenish"
+	BatchOperationReserve   = "reserve"
+	BatchOperationRecognize = "recognize"
+	BatchOperationCancel    = "cancel"
+)
+
+const MaxBatchSize = 10000
+
+const (
+	ImportLineValid     = "valid"
+	ImportLineInvalid   = "invalid"
+	ImportLineDuplicate = "duplicate"
+	ImportLineImported  = "imported"
+	ImportLineFailed    = "failed"
+)
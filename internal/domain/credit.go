@@ -0,0 +1,15 @@
+package domain
+
+import "time"
+
+// CreditLimitChange is a record of audit trail of credit limits
+type CreditLimitChange struct {
+	Id        uint      `json:"id" db:"id"`
+	UserId    uint      `json:"user_id" db:"user_id"`
+	Currency  Currency  `json:"currency" db:"currency"`
+	OldLimit  Money     `json:"old_limit" db:"old_limit"`
+	NewLimit  Money     `json:"new_limit" db:"new_limit"`
+	Operator  string    `json:"operator" db:"operator"`
+	Reason    string    `json:"reason" db:"reason"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
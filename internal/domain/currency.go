@@ -0,0 +1,115 @@
+package domain
+
+import (
+	"fmt"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"time"
+)
+
+type Currency string
+
+const (
+	CurrencyRUB Currency = "RUB"
+	CurrencyUSD Currency = "USD"
+	CurrencyEUR Currency = "EUR"
+
+	// DefaultCurrency is used when operation doesn't specify currency
+	DefaultCurrency = CurrencyRUB
+)
+
+// MoneyExponent is number of fraction digits kept by Money
+const MoneyExponent = 2
+
+// currencyExponents is number of digits of minor unit. Must match currency table
+var currencyExponents = map[Currency]int{
+	CurrencyRUB: 2,
+	CurrencyUSD: 2,
+	CurrencyEUR: 2,
+}
+
+var Currencies = []interface{}{
+	CurrencyRUB,
+	CurrencyUSD,
+	CurrencyEUR,
+}
+
+func (c Currency) Exponent() int {
+	return currencyExponents[c]
+}
+
+// AmountFitsCurrency rejects amounts with more fraction digits than currency minor unit has.
+// Unknown and empty currencies are left to other rules
+func AmountFitsCurrency(c Currency) validation.Rule {
+	return validation.By(func(value interface{}) error {
+		exponent, ok := currencyExponents[c]
+		if !ok {
+			return nil
+		}
+		var amount Money
+		switch v := value.(type) {
+		case Money:
+			amount = v
+		case *Money:
+			amount = *v
+		default:
+			return fmt.Errorf("must be money")
+		}
+		unit := Money(1)
+		for i := exponent; i < MoneyExponent; i++ {
+			unit *= 10
+		}
+		if amount%unit != 0 {
+			return fmt.Errorf("%s has %d fraction digits", c, exponent)
+		}
+		return nil
+	})
+}
+
+type Wallet struct {
+	Currency Currency `json:"currency" db:"currency"`
+	Balance  Money    `json:"balance" db:"balance"`
+	// Reserved is held by pending operations, it isn't part of Balance
+	Reserved Money `json:"reserved" db:"reserved_balance"`
+	// Available is Balance with CreditLimit, it is what reservations may spend
+	CreditLimit Money `json:"credit_limit" db:"credit_limit"`
+	Available   Money `json:"available" db:"available"`
+	// PromoBalance is unexpired promo credits, they are not part of Balance
+	PromoBalance Money `json:"promo_balance" db:"promo_balance"`
+}
+
+// Balance of wallet. Balance is money which isn't on hold, Reserved is held by pending operations
+// and Total is their sum. Available is Balance with CreditLimit, it is what reservations may spend
+type Balance struct {
+	Currency     Currency      `json:"currency" db:"-"`
+	Balance      Money         `json:"balance" db:"balance"`
+	Reserved     Money         `json:"reserved" db:"reserved_balance"`
+	Total        Money         `json:"total" db:"total"`
+	CreditLimit  Money         `json:"credit_limit" db:"credit_limit"`
+	Available    Money         `json:"available" db:"available"`
+	Reservations []Reservation `json:"reservations,omitempty" db:"-"`
+}
+
+// Reservation is pending debit which holds Amount in reserved balance. Amount includes Fee and
+// excludes PromoAmount paid with promo credits. ServiceId is zero for escrow
+type Reservation struct {
+	TransactionId uint      `json:"transaction_id" db:"transaction_id"`
+	Kind          string    `json:"kind" db:"kind"`
+	ServiceId     uint      `json:"service_id" db:"service_id"`
+	ServiceName   string    `json:"service_name" db:"service_name"`
+	OrderId       uint      `json:"order_id" db:"order_id"`
+	Amount        Money     `json:"amount" db:"amount"`
+	Fee           Money     `json:"fee" db:"fee"`
+	PromoAmount   Money     `json:"promo_amount" db:"promo_amount"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	AgeSeconds    int64     `json:"age_seconds" db:"age_seconds"`
+}
+
+// BalanceAt is wallet as of At computed from transactions. Available is Balance with CreditLimit as of At
+type BalanceAt struct {
+	Currency    Currency  `json:"currency" db:"-"`
+	At          time.Time `json:"at" db:"-"`
+	Balance     Money     `json:"balance" db:"balance"`
+	Reserved    Money     `json:"reserved" db:"reserved_balance"`
+	CreditLimit Money     `json:"credit_limit" db:"credit_limit"`
+	Available   Money     `json:"available" db:"-"`
+}
@@ -1,18 +1,42 @@
 package domain
 
-import validation "github.com/go-ozzo/ozzo-validation"
+import (
+	"encoding/json"
+	"fmt"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+	"time"
+)
 
 type DTO interface {
 	Validate() error
 }
 
 type GetBalanceDTO struct {
-	UserId uint `json:"user_id"`
+	UserId   uint     `json:"user_id"`
+	Currency Currency `json:"currency"`
+	// Reservations requests open reservations with the balance
+	Reservations bool `json:"reservations"`
 }
 
 func (d GetBalanceDTO) Validate() error {
 	return validation.ValidateStruct(&d,
 		validation.Field(&d.UserId, validation.Required, validation.Min(uint(0))),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+	)
+}
+
+type GetBalanceAtDTO struct {
+	UserId   uint      `json:"user_id"`
+	Currency Currency  `json:"currency"`
+	At       time.Time `json:"at"`
+}
+
+func (d GetBalanceAtDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+		validation.Field(&d.At, validation.Required),
 	)
 }
 
@@ -29,65 +53,96 @@ func (d GetMonthlyReportDTO) Validate() error {
 }
 
 type RecognizeRevenueDTO struct {
-	UserId    uint  `json:"user_id"`
-	Amount    Money `json:"amount"`
-	ServiceId uint  `json:"service_id"`
-	OrderId   uint  `json:"order_id"`
+	UserId    uint     `json:"user_id"`
+	Amount    Money    `json:"amount"`
+	Currency  Currency `json:"currency"`
+	ServiceId uint     `json:"service_id"`
+	OrderId   uint     `json:"order_id"`
+	// Splits pay out parts of Amount to recipients, the rest is revenue of the service
+	Splits []Split `json:"splits"`
+	// RemainderTo is recipient who gets remainder of rounding of BasisPoints splits.
+	// The service keeps it if RemainderTo isn't set
+	RemainderTo uint `json:"remainder_to"`
+	// Payouts are computed from Splits by service
+	Payouts           []Payout `json:"-"`
+	PayoutDescription string   `json:"-"`
 }
 
 func (d RecognizeRevenueDTO) Validate() error {
 	return validation.ValidateStruct(&d,
 		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
-		validation.Field(&d.Amount, validation.Required, validation.Min(Money(0))),
+		validation.Field(&d.Amount, PositiveAmount, AmountFitsCurrency(d.Currency)),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
 		validation.Field(&d.ServiceId, validation.Required, validation.Min(uint(1))),
 		validation.Field(&d.OrderId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Splits, validation.Length(0, MaxSplits),
+			splitsRule(d.Splits, d.UserId, d.Amount, d.Currency, d.RemainderTo)),
 	)
 }
 
 type CancelTransactionDTO struct {
-	UserId    uint  `json:"user_id"`
-	Amount    Money `json:"amount"`
-	ServiceId uint  `json:"service_id"`
-	OrderId   uint  `json:"order_id"`
+	UserId    uint     `json:"user_id"`
+	Amount    Money    `json:"amount"`
+	Currency  Currency `json:"currency"`
+	ServiceId uint     `json:"service_id"`
+	OrderId   uint     `json:"order_id"`
 }
 
 func (d CancelTransactionDTO) Validate() error {
 	return validation.ValidateStruct(&d,
 		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
-		validation.Field(&d.Amount, validation.Required, validation.Min(Money(0))),
+		validation.Field(&d.Amount, PositiveAmount, AmountFitsCurrency(d.Currency)),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
 		validation.Field(&d.ServiceId, validation.Required, validation.Min(uint(1))),
 		validation.Field(&d.OrderId, validation.Required, validation.Min(uint(1))),
 	)
 }
 
 type ReserveMoneyDTO struct {
-	UserId      uint   `json:"user_id"`
-	Amount      Money  `json:"amount"`
-	ServiceId   uint   `json:"service_id"`
-	OrderId     uint   `json:"order_id"`
-	Description string `json:"description"`
+	UserId      uint     `json:"user_id"`
+	Amount      Money    `json:"amount"`
+	Currency    Currency `json:"currency"`
+	ServiceId   uint     `json:"service_id"`
+	OrderId     uint     `json:"order_id"`
+	Description string   `json:"description"`
+	// ServiceName names service if it is added to catalog by the reservation, it doesn't rename existing service
 	ServiceName string `json:"service_name"`
+	// Fee is computed by fee schedule of the service and reserved on top of Amount
+	Fee            Money  `json:"-"`
+	FeeDescription string `json:"-"`
+	// PromoLimit is max part of Amount which may be paid with promo credits
+	PromoLimit Money `json:"-"`
+	// CreateService adds unknown service to catalog with ServiceName. Set by service from config
+	CreateService bool `json:"-"`
 }
 
 func (d ReserveMoneyDTO) Validate() error {
 	return validation.ValidateStruct(&d,
 		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
-		validation.Field(&d.Amount, validation.Required, validation.Min(Money(0))),
+		validation.Field(&d.Amount, PositiveAmount, AmountFitsCurrency(d.Currency)),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
 		validation.Field(&d.ServiceId, validation.Required, validation.Min(uint(1))),
 		validation.Field(&d.OrderId, validation.Required, validation.Min(uint(1))),
 	)
 }
 
 type ReplenishBalanceDTO struct {
-	UserId      uint   `json:"user_id"`
-	Amount      Money  `json:"amount"`
-	Description string `json:"description"`
+	UserId      uint     `json:"user_id"`
+	Amount      Money    `json:"amount"`
+	Currency    Currency `json:"currency"`
+	Description string   `json:"description"`
+	// IdempotencyKey is optional. Replenishment with used key is rejected
+	IdempotencyKey string `json:"idempotency_key"`
+	// CreateUser creates user on the first replenishment. Set by service from config
+	CreateUser bool `json:"-"`
 }
 
 func (d ReplenishBalanceDTO) Validate() error {
 	return validation.ValidateStruct(&d,
 		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
-		validation.Field(&d.Amount, validation.Required, validation.Min(Money(0))),
+		validation.Field(&d.Amount, PositiveAmount, AmountFitsCurrency(d.Currency)),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+		validation.Field(&d.IdempotencyKey, validation.Length(0, 128)),
 	)
 }
 
@@ -97,6 +152,9 @@ type GetHistoryDTO struct {
 	Limit   int    `json:"limit"`
 	SortBy  string `json:"sort_by"`
 	Reverse bool   `json:"reverse"`
+	// From and To are optional bounds of operation time, To is exclusive
+	From *time.Time `json:"from"`
+	To   *time.Time `json:"to"`
 }
 
 func (d GetHistoryDTO) Validate() error {
@@ -107,3 +165,639 @@ func (d GetHistoryDTO) Validate() error {
 		validation.Field(&d.SortBy, validation.In(GetHistoryDTOSortByTimestamp, GetHistoryDTOSortByAmount)),
 	)
 }
+
+type CreateWebhookDTO struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+func (d CreateWebhookDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.URL, validation.Required, is.URL),
+		validation.Field(&d.Events, validation.Required, validation.Each(validation.In(EventTypes...))),
+		validation.Field(&d.Secret, validation.Required, validation.Length(16, 0)),
+	)
+}
+
+type DeleteWebhookDTO struct {
+	WebhookId uint `json:"webhook_id"`
+}
+
+func (d DeleteWebhookDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.WebhookId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+type SubscribeUserEventsDTO struct {
+	UserId      uint `json:"user_id"`
+	LastEventId uint `json:"last_event_id"`
+}
+
+func (d SubscribeUserEventsDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+type BatchDTO struct {
+	Mode       string           `json:"mode"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+func (d BatchDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.Mode, validation.Required, validation.In(BatchModeAtomic, BatchModeBestEffort)),
+		validation.Field(&d.Operations, validation.Required, validation.Length(1, MaxBatchSize)),
+	)
+}
+
+type ImportReplenishmentsDTO struct {
+	Data     []byte   `json:"-"`
+	Commit   bool     `json:"commit"`
+	Currency Currency `json:"currency"`
+	// Comma is CSV delimiter, ',' by default
+	Comma rune `json:"-"`
+}
+
+func (d ImportReplenishmentsDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.Data, validation.Required),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+	)
+}
+
+type AdjustBalanceDTO struct {
+	UserId uint `json:"user_id"`
+	// Amount is added to balance and may be negative
+	Amount   Money    `json:"amount"`
+	Currency Currency `json:"currency"`
+	Reason   string   `json:"reason"`
+	Operator string   `json:"operator"`
+	// Refund returns money to user. It must be positive and is allowed for frozen account
+	Refund bool `json:"refund"`
+}
+
+func (d AdjustBalanceDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Amount, NonZeroAmount, AmountFitsCurrency(d.Currency), validation.By(func(interface{}) error {
+			if d.Refund && d.Amount < 0 {
+				return fmt.Errorf("refund must be positive")
+			}
+			return nil
+		})),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+		validation.Field(&d.Reason, validation.Required, validation.Length(3, 500)),
+	)
+}
+
+type LoadExchangeRatesDTO struct {
+	Rates []ExchangeRate `json:"rates"`
+}
+
+func (d LoadExchangeRatesDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.Rates, validation.Required),
+	)
+}
+
+type CreateFXQuoteDTO struct {
+	UserId uint     `json:"user_id"`
+	From   Currency `json:"from"`
+	To     Currency `json:"to"`
+}
+
+func (d CreateFXQuoteDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.From, validation.Required, validation.In(Currencies...)),
+		validation.Field(&d.To, validation.Required, validation.In(Currencies...),
+			validation.NotIn(d.From).Error("must differ from source currency")),
+	)
+}
+
+type ConvertCurrencyDTO struct {
+	UserId uint     `json:"user_id"`
+	Amount Money    `json:"amount"`
+	From   Currency `json:"from"`
+	To     Currency `json:"to"`
+	// QuoteId is optional. Current rate is used if it isn't set
+	QuoteId uint `json:"quote_id"`
+}
+
+func (d ConvertCurrencyDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Amount, PositiveAmount, AmountFitsCurrency(d.From)),
+		validation.Field(&d.From, validation.Required, validation.In(Currencies...)),
+		validation.Field(&d.To, validation.Required, validation.In(Currencies...),
+			validation.NotIn(d.From).Error("must differ from source currency")),
+	)
+}
+
+type SetFeeScheduleDTO struct {
+	ServiceId uint `json:"service_id"`
+	// Rules replace the whole schedule of the service, empty Rules remove fees
+	Rules []FeeRule `json:"rules"`
+}
+
+func (d SetFeeScheduleDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.ServiceId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Rules, validation.By(func(value interface{}) error {
+			tiers := make(map[Currency]map[Money]bool)
+			for _, rule := range d.Rules {
+				currency := rule.Currency
+				if currency == "" {
+					currency = DefaultCurrency
+				}
+				if tiers[currency] == nil {
+					tiers[currency] = make(map[Money]bool)
+				}
+				if tiers[currency][rule.MinAmount] {
+					return fmt.Errorf("duplicate tier %s %s", rule.MinAmount, currency)
+				}
+				tiers[currency][rule.MinAmount] = true
+			}
+			return nil
+		})),
+	)
+}
+
+type GetFeeScheduleDTO struct {
+	ServiceId uint `json:"service_id"`
+}
+
+func (d GetFeeScheduleDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.ServiceId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+type GrantPromoDTO struct {
+	UserId      uint      `json:"user_id"`
+	Amount      Money     `json:"amount"`
+	Currency    Currency  `json:"currency"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Description string    `json:"description"`
+}
+
+func (d GrantPromoDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Amount, PositiveAmount, AmountFitsCurrency(d.Currency)),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+		validation.Field(&d.ExpiresAt, validation.Required, validation.By(func(value interface{}) error {
+			if !d.ExpiresAt.After(time.Now()) {
+				return fmt.Errorf("must be in the future")
+			}
+			return nil
+		})),
+		validation.Field(&d.Description, validation.Length(0, 500)),
+	)
+}
+
+type GetPromoGrantsDTO struct {
+	UserId uint `json:"user_id"`
+}
+
+func (d GetPromoGrantsDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+type SetCreditLimitDTO struct {
+	UserId   uint     `json:"user_id"`
+	Currency Currency `json:"currency"`
+	// Limit is how far below zero reservations may take balance, 0 disables credit
+	Limit    Money  `json:"limit"`
+	Reason   string `json:"reason"`
+	Operator string `json:"operator"`
+}
+
+func (d SetCreditLimitDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+		validation.Field(&d.Limit, NonNegativeAmount, AmountFitsCurrency(d.Currency)),
+		validation.Field(&d.Reason, validation.Required, validation.Length(3, 500)),
+	)
+}
+
+type GetCreditLimitChangesDTO struct {
+	UserId uint `json:"user_id"`
+}
+
+func (d GetCreditLimitChangesDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+type CreateVelocityRuleDTO struct {
+	// UserId is optional, rule is global if it isn't set
+	UserId        uint     `json:"user_id"`
+	Currency      Currency `json:"currency"`
+	Metric        string   `json:"metric"`
+	WindowSeconds int      `json:"window_seconds"`
+	MaxAmount     Money    `json:"max_amount"`
+	MaxCount      int      `json:"max_count"`
+}
+
+func (d CreateVelocityRuleDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+		validation.Field(&d.Metric, validation.Required, validation.In(VelocityMetricAmount, VelocityMetricCount)),
+		validation.Field(&d.WindowSeconds, validation.Required, validation.Min(1)),
+		validation.Field(&d.MaxAmount, NonNegativeAmount, AmountFitsCurrency(d.Currency), validation.By(func(interface{}) error {
+			if d.Metric == VelocityMetricAmount && d.MaxAmount <= 0 {
+				return fmt.Errorf("must be positive for %s metric", VelocityMetricAmount)
+			}
+			return nil
+		})),
+		validation.Field(&d.MaxCount, validation.Min(0), validation.By(func(interface{}) error {
+			if d.Metric == VelocityMetricCount && d.MaxCount <= 0 {
+				return fmt.Errorf("must be positive for %s metric", VelocityMetricCount)
+			}
+			return nil
+		})),
+	)
+}
+
+type GetVelocityRulesDTO struct {
+	// UserId is optional. Rules of the user and global rules are returned if it is set
+	UserId uint `json:"user_id"`
+}
+
+func (d GetVelocityRulesDTO) Validate() error {
+	return nil
+}
+
+type DeleteVelocityRuleDTO struct {
+	RuleId uint `json:"rule_id"`
+}
+
+func (d DeleteVelocityRuleDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.RuleId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+type GetVelocityViolationsDTO struct {
+	// UserId is optional, violations of all users are returned if it isn't set
+	UserId uint `json:"user_id"`
+	Limit  int  `json:"limit"`
+}
+
+func (d GetVelocityViolationsDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.Limit, validation.Min(0)),
+	)
+}
+
+type SetAccountStatusDTO struct {
+	UserId   uint   `json:"user_id"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason"`
+	Operator string `json:"operator"`
+}
+
+func (d SetAccountStatusDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Status, validation.Required, validation.In(AccountStatuses...)),
+		validation.Field(&d.Reason, validation.Required, validation.Length(3, 500)),
+	)
+}
+
+type GetAccountDTO struct {
+	UserId uint `json:"user_id"`
+}
+
+func (d GetAccountDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+type CreateUserDTO struct {
+	UserId uint `json:"id"`
+	// ExternalRef is optional id of the user in external system, it must be unique
+	ExternalRef string `json:"external_ref"`
+	// Metadata is optional JSON object
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+func (d CreateUserDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.ExternalRef, validation.Length(0, 255)),
+		validation.Field(&d.Metadata, validation.By(validMetadata)),
+	)
+}
+
+type GetUserDTO struct {
+	UserId uint `json:"user_id"`
+}
+
+func (d GetUserDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+type CreateServiceDTO struct {
+	ServiceId    uint     `json:"id"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	Category     string   `json:"category"`
+	DefaultPrice Money    `json:"default_price"`
+	Currency     Currency `json:"currency"`
+	// Active is true if it isn't set
+	Active *bool `json:"active"`
+}
+
+func (d CreateServiceDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.ServiceId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Name, validation.Required, validation.Length(1, 255)),
+		validation.Field(&d.Description, validation.Length(0, 1000)),
+		validation.Field(&d.Category, validation.Length(0, 255)),
+		validation.Field(&d.DefaultPrice, NonNegativeAmount, AmountFitsCurrency(d.Currency)),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+	)
+}
+
+// UpdateServiceDTO replaces all fields of the service
+type UpdateServiceDTO struct {
+	ServiceId    uint     `json:"-"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	Category     string   `json:"category"`
+	DefaultPrice Money    `json:"default_price"`
+	Currency     Currency `json:"currency"`
+	// Active is true if it isn't set
+	Active *bool `json:"active"`
+}
+
+func (d UpdateServiceDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.Name, validation.Required, validation.Length(1, 255)),
+		validation.Field(&d.Description, validation.Length(0, 1000)),
+		validation.Field(&d.Category, validation.Length(0, 255)),
+		validation.Field(&d.DefaultPrice, NonNegativeAmount, AmountFitsCurrency(d.Currency)),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+	)
+}
+
+type GetServiceDTO struct {
+	ServiceId uint `json:"service_id"`
+}
+
+func (d GetServiceDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.ServiceId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+type GetServicesDTO struct {
+	// Category is optional filter
+	Category   string `json:"category"`
+	ActiveOnly bool   `json:"active_only"`
+}
+
+func (d GetServicesDTO) Validate() error {
+	return nil
+}
+
+type OrderLineDTO struct {
+	ServiceId uint  `json:"service_id"`
+	Amount    Money `json:"amount"`
+	// ServiceName names service if it is added to catalog by reservation of the order
+	ServiceName string `json:"service_name"`
+}
+
+// orderLinesRule checks lines of order in currency. Services of lines must be distinct
+func orderLinesRule(lines []OrderLineDTO, currency Currency) validation.Rule {
+	return validation.By(func(interface{}) error {
+		services := make(map[uint]bool, len(lines))
+		for i, line := range lines {
+			err := validation.ValidateStruct(&line,
+				validation.Field(&line.ServiceId, validation.Required, validation.Min(uint(1))),
+				validation.Field(&line.Amount, PositiveAmount, AmountFitsCurrency(currency)),
+				validation.Field(&line.ServiceName, validation.Length(0, 255)),
+			)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", i, err)
+			}
+			if services[line.ServiceId] {
+				return fmt.Errorf("line %d: duplicate service %d", i, line.ServiceId)
+			}
+			services[line.ServiceId] = true
+		}
+		return nil
+	})
+}
+
+type CreateOrderDTO struct {
+	OrderId  uint           `json:"id"`
+	UserId   uint           `json:"user_id"`
+	Currency Currency       `json:"currency"`
+	Lines    []OrderLineDTO `json:"lines"`
+}
+
+func (d CreateOrderDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.OrderId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+		validation.Field(&d.Lines, validation.Required, validation.Length(1, MaxOrderLines), orderLinesRule(d.Lines, d.Currency)),
+	)
+}
+
+// ReserveOrderDTO reserves all lines of the order atomically
+type ReserveOrderDTO struct {
+	UserId      uint           `json:"-"`
+	OrderId     uint           `json:"order_id"`
+	Currency    Currency       `json:"currency"`
+	Description string         `json:"description"`
+	Lines       []OrderLineDTO `json:"lines"`
+}
+
+func (d ReserveOrderDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.OrderId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+		validation.Field(&d.Lines, validation.Required, validation.Length(1, MaxOrderLines), orderLinesRule(d.Lines, d.Currency)),
+	)
+}
+
+type GetOrderDTO struct {
+	OrderId uint `json:"order_id"`
+}
+
+func (d GetOrderDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.OrderId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+// ChangeOrderDTO refers to the line of ServiceId or to all lines of the order if ServiceId isn't set.
+// Description is used by refund
+type ChangeOrderDTO struct {
+	OrderId     uint   `json:"-"`
+	ServiceId   uint   `json:"-"`
+	Description string `json:"description"`
+}
+
+func (d ChangeOrderDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.OrderId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Description, validation.Length(0, 255)),
+	)
+}
+
+type CreateSubscriptionDTO struct {
+	UserId      uint     `json:"-"`
+	ServiceId   uint     `json:"service_id"`
+	Amount      Money    `json:"amount"`
+	Currency    Currency `json:"currency"`
+	Period      string   `json:"period"`
+	Description string   `json:"description"`
+	// NextRunAt is time of the first charge, now if it isn't set
+	NextRunAt *time.Time `json:"next_run_at"`
+}
+
+func (d CreateSubscriptionDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.ServiceId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Amount, PositiveAmount, AmountFitsCurrency(d.Currency)),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+		validation.Field(&d.Period, validation.Required, validation.In(SubscriptionPeriods...)),
+		validation.Field(&d.Description, validation.Length(0, 255)),
+	)
+}
+
+type GetSubscriptionsDTO struct {
+	UserId uint `json:"user_id"`
+}
+
+func (d GetSubscriptionsDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+// SubscriptionDTO refers to subscription of the user
+type SubscriptionDTO struct {
+	UserId         uint `json:"user_id"`
+	SubscriptionId uint `json:"subscription_id"`
+}
+
+func (d SubscriptionDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.SubscriptionId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+type CreateEscrowDTO struct {
+	BuyerId       uint      `json:"buyer_id"`
+	SellerId      uint      `json:"seller_id"`
+	Amount        Money     `json:"amount"`
+	Currency      Currency  `json:"currency"`
+	Description   string    `json:"description"`
+	TimeoutPolicy string    `json:"timeout_policy"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+func (d CreateEscrowDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.BuyerId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.SellerId, validation.Required, validation.Min(uint(1)),
+			validation.NotIn(d.BuyerId).Error("must differ from buyer_id")),
+		validation.Field(&d.Amount, PositiveAmount, AmountFitsCurrency(d.Currency)),
+		validation.Field(&d.Currency, validation.In(Currencies...)),
+		validation.Field(&d.Description, validation.Length(0, 255)),
+		validation.Field(&d.TimeoutPolicy, validation.Required, validation.In(EscrowTimeoutPolicies...)),
+		validation.Field(&d.ExpiresAt, validation.Required, validation.By(func(interface{}) error {
+			if !d.ExpiresAt.After(time.Now()) {
+				return fmt.Errorf("must be in the future")
+			}
+			return nil
+		})),
+	)
+}
+
+// GetEscrowDTO refers to escrow, it is used to release and refund escrow too
+type GetEscrowDTO struct {
+	EscrowId uint `json:"escrow_id"`
+}
+
+func (d GetEscrowDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.EscrowId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+// GetEscrowsDTO refers to escrows where user is buyer or seller
+type GetEscrowsDTO struct {
+	UserId uint `json:"user_id"`
+}
+
+func (d GetEscrowsDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.UserId, validation.Required, validation.Min(uint(1))),
+	)
+}
+
+type DisputeEscrowDTO struct {
+	EscrowId uint   `json:"-"`
+	Reason   string `json:"reason"`
+}
+
+func (d DisputeEscrowDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.EscrowId, validation.Required, validation.Min(uint(1))),
+		validation.Field(&d.Reason, validation.Required, validation.Length(1, 255)),
+	)
+}
+
+// GetAuditLogDTO filters audit log. All fields are optional, entries are returned in order of id
+type GetAuditLogDTO struct {
+	UserId    uint   `json:"user_id"`
+	RequestId string `json:"request_id"`
+	// From and To are optional bounds of entry time, To is exclusive
+	From    *time.Time `json:"from"`
+	To      *time.Time `json:"to"`
+	AfterId uint       `json:"after_id"`
+	Limit   int        `json:"limit"`
+}
+
+func (d GetAuditLogDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.Limit, validation.Min(0)),
+	)
+}
+
+// GetChainRootsDTO bounds days of chain roots, both are optional and inclusive
+type GetChainRootsDTO struct {
+	From *time.Time `json:"from"`
+	To   *time.Time `json:"to"`
+}
+
+func (d GetChainRootsDTO) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.To, validation.By(func(interface{}) error {
+			if d.From != nil && d.To != nil && d.To.Before(*d.From) {
+				return fmt.Errorf("must not be before from")
+			}
+			return nil
+		})),
+	)
+}
@@ -0,0 +1,39 @@
+package domain
+
+import "time"
+
+// Allowed transitions of escrow: HELD -> RELEASED, REFUNDED, DISPUTED; DISPUTED -> RELEASED, REFUNDED
+const (
+	EscrowStatusHeld     = "HELD"
+	EscrowStatusDisputed = "DISPUTED"
+	EscrowStatusReleased = "RELEASED"
+	EscrowStatusRefunded = "REFUNDED"
+)
+
+// Settlement of held escrow after ExpiresAt
+const (
+	EscrowTimeoutPolicyRelease = "RELEASE"
+	EscrowTimeoutPolicyRefund  = "REFUND"
+)
+
+var EscrowTimeoutPolicies = []interface{}{
+	EscrowTimeoutPolicyRelease,
+	EscrowTimeoutPolicyRefund,
+}
+
+// Escrow holds Amount of buyer like reservation until it is released to seller or refunded.
+// Disputed escrow isn't settled by timeout
+type Escrow struct {
+	Id            uint      `json:"id" db:"id"`
+	BuyerId       uint      `json:"buyer_id" db:"buyer_id"`
+	SellerId      uint      `json:"seller_id" db:"seller_id"`
+	Amount        Money     `json:"amount" db:"amount"`
+	Currency      Currency  `json:"currency" db:"currency"`
+	Description   string    `json:"description" db:"description"`
+	Status        string    `json:"status" db:"status"`
+	TimeoutPolicy string    `json:"timeout_policy" db:"timeout_policy"`
+	ExpiresAt     time.Time `json:"expires_at" db:"expires_at"`
+	DisputeReason string    `json:"dispute_reason,omitempty" db:"dispute_reason"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
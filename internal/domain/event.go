@@ -0,0 +1,20 @@
+package domain
+
+import "time"
+
+// UserEvent is a change of user transaction together with user balances after the change.
+// Id grows in order of commit and is used to resume event stream
+type UserEvent struct {
+	Id              uint      `json:"id" db:"id"`
+	TransactionId   uint      `json:"transaction_id" db:"transaction_id"`
+	UserId          uint      `json:"user_id" db:"user_id"`
+	Amount          Money     `json:"amount" db:"amount"`
+	Currency        Currency  `json:"currency" db:"currency"`
+	Status          string    `json:"status" db:"status"`
+	ServiceId       uint      `json:"service_id" db:"service_id"`
+	OrderId         uint      `json:"order_id" db:"order_id"`
+	Description     string    `json:"description" db:"description"`
+	Timestamp       time.Time `json:"timestamp" db:"timestamp"`
+	Balance         Money     `json:"balance" db:"balance"`
+	ReservedBalance Money     `json:"reserved_balance" db:"reserved_balance"`
+}
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"fmt"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// PlatformServiceId is the service id under which fees are shown in monthly report
+const PlatformServiceId = 0
+
+const PlatformServiceName = "Комиссия платформы"
+
+// MaxBasisPoints is 100%
+const MaxBasisPoints = 10000
+
+// FeeRule is a tier of fee schedule of a service. Rule applies to amounts starting from MinAmount
+// up to MinAmount of the next tier. Fee is Fixed plus BasisPoints of amount (1 bp = 0.01%)
+// limited by MinFee and MaxFee. Zero MaxFee means no upper limit
+type FeeRule struct {
+	Id          uint     `json:"id" db:"id"`
+	ServiceId   uint     `json:"service_id" db:"service_id"`
+	Currency    Currency `json:"currency" db:"currency"`
+	MinAmount   Money    `json:"min_amount" db:"min_amount"`
+	Fixed       Money    `json:"fixed" db:"fixed"`
+	BasisPoints int64    `json:"basis_points" db:"basis_points"`
+	MinFee      Money    `json:"min_fee" db:"min_fee"`
+	MaxFee      Money    `json:"max_fee" db:"max_fee"`
+}
+
+func (r FeeRule) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Currency, validation.In(Currencies...)),
+		validation.Field(&r.MinAmount, NonNegativeAmount),
+		validation.Field(&r.Fixed, NonNegativeAmount, AmountFitsCurrency(r.Currency)),
+		validation.Field(&r.BasisPoints, validation.Min(0), validation.Max(MaxBasisPoints)),
+		validation.Field(&r.MinFee, NonNegativeAmount, AmountFitsCurrency(r.Currency)),
+		validation.Field(&r.MaxFee, NonNegativeAmount, AmountFitsCurrency(r.Currency),
+			validation.By(func(value interface{}) error {
+				if r.MaxFee != 0 && r.MaxFee < r.MinFee {
+					return fmt.Errorf("must not be less than min_fee")
+				}
+				return nil
+			})),
+	)
+}
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"fmt"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MaxRateFractionDigits must match scale of rate columns
+const MaxRateFractionDigits = 10
+
+var rateRegexp = regexp.MustCompile(fmt.Sprintf(`^[0-9]{1,10}(\.[0-9]{1,%d})?$`, MaxRateFractionDigits))
+
+// ExchangeRate means 1 Base = Rate Quote while ValidFrom <= t < ValidTo.
+// Rate is a decimal string to keep precision. ValidTo is optional
+type ExchangeRate struct {
+	Id        uint       `json:"id" db:"id"`
+	Base      Currency   `json:"base" db:"base"`
+	Quote     Currency   `json:"quote" db:"quote"`
+	Rate      string     `json:"rate" db:"rate"`
+	ValidFrom time.Time  `json:"valid_from" db:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to" db:"valid_to"`
+}
+
+func (r ExchangeRate) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Base, validation.Required, validation.In(Currencies...)),
+		validation.Field(&r.Quote, validation.Required, validation.In(Currencies...),
+			validation.NotIn(r.Base).Error("must differ from base")),
+		validation.Field(&r.Rate, validation.Required, validation.By(validRate)),
+		validation.Field(&r.ValidFrom, validation.Required),
+		validation.Field(&r.ValidTo, validation.By(func(value interface{}) error {
+			if r.ValidTo != nil && !r.ValidTo.After(r.ValidFrom) {
+				return fmt.Errorf("must be after valid_from")
+			}
+			return nil
+		})),
+	)
+}
+
+func validRate(value interface{}) error {
+	rate, _ := value.(string)
+	if !rateRegexp.MatchString(rate) {
+		return fmt.Errorf("must be a positive decimal with up to %d fraction digits", MaxRateFractionDigits)
+	}
+	if strings.Trim(rate, "0.") == "" {
+		return fmt.Errorf("must be positive")
+	}
+	return nil
+}
+
+// FXQuote locks Rate of conversion From -> To for user until ExpiresAt. Quote can be used once
+type FXQuote struct {
+	Id        uint      `json:"id" db:"id"`
+	UserId    uint      `json:"user_id" db:"-"`
+	From      Currency  `json:"from" db:"-"`
+	To        Currency  `json:"to" db:"-"`
+	Rate      string    `json:"rate" db:"rate"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// Conversion is a result of currency conversion. Residue is the part of exact
+// converted amount lost to rounding to minor unit of To
+type Conversion struct {
+	Id         uint     `json:"id" db:"id"`
+	From       Currency `json:"from" db:"-"`
+	To         Currency `json:"to" db:"-"`
+	FromAmount Money    `json:"from_amount" db:"-"`
+	ToAmount   Money    `json:"to_amount" db:"to_amount"`
+	Rate       string   `json:"rate" db:"rate"`
+	Residue    string   `json:"residue" db:"residue"`
+}
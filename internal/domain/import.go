@@ -0,0 +1,23 @@
+package domain
+
+// ImportLine is a parsed line of replenishments CSV: user_id, amount, description[, key]
+type ImportLine struct {
+	Line           int    `json:"line"`
+	UserId         uint   `json:"user_id"`
+	Amount         Money  `json:"amount"`
+	Description    string `json:"description"`
+	IdempotencyKey string `json:"idempotency_key"`
+	Status         string `json:"status"`
+	Error          string `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	Committed  bool         `json:"committed"`
+	Total      int          `json:"total"`
+	Valid      int          `json:"valid"`
+	Invalid    int          `json:"invalid"`
+	Duplicates int          `json:"duplicates"`
+	Imported   int          `json:"imported"`
+	Failed     int          `json:"failed"`
+	Lines      []ImportLine `json:"lines"`
+}
@@ -0,0 +1,245 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+// Money is amount in minor units, MoneyExponent digits after the point
+type Money int64
+
+var (
+	ErrInvalidMoney  = errors.New("invalid money")
+	ErrMoneyOverflow = errors.New("money overflow")
+)
+
+// moneyScale is 10^MoneyExponent
+const moneyScale = 100
+
+// PositiveAmount, NonNegativeAmount and NonZeroAmount are used instead of Required and Min
+// because validation rules compare result of Money.Value which is a string
+var (
+	PositiveAmount = validation.By(func(value interface{}) error {
+		if amount, ok := value.(Money); !ok || amount <= 0 {
+			return fmt.Errorf("must be greater than 0")
+		}
+		return nil
+	})
+	NonNegativeAmount = validation.By(func(value interface{}) error {
+		if amount, ok := value.(Money); !ok || amount < 0 {
+			return fmt.Errorf("must be no less than 0")
+		}
+		return nil
+	})
+	NonZeroAmount = validation.By(func(value interface{}) error {
+		if amount, ok := value.(Money); !ok || amount == 0 {
+			return fmt.Errorf("cannot be blank")
+		}
+		return nil
+	})
+)
+
+// StringToMoney parses decimal like "-1.5" or "100.25". Sign is optional and only minus is allowed,
+// integer part is required and fraction part has 1 to MoneyExponent digits
+func StringToMoney(s string) (Money, error) {
+	if len(s) == 0 {
+		return 0, fmt.Errorf("%w: empty string", ErrInvalidMoney)
+	}
+	negative := s[0] == '-'
+	if negative {
+		s = s[1:]
+	}
+
+	var magnitude uint64
+	intDigits, fracDigits := 0, -1
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '.' {
+			if fracDigits >= 0 || intDigits == 0 {
+				return 0, fmt.Errorf("%w: unexpected '.'", ErrInvalidMoney)
+			}
+			fracDigits = 0
+			continue
+		}
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("%w: unexpected %q", ErrInvalidMoney, c)
+		}
+		if fracDigits >= 0 {
+			fracDigits++
+			if fracDigits > MoneyExponent {
+				return 0, fmt.Errorf("%w: max precision is %d", ErrInvalidMoney, MoneyExponent)
+			}
+		} else {
+			intDigits++
+		}
+		if magnitude > (math.MaxUint64-9)/10 {
+			return 0, ErrMoneyOverflow
+		}
+		magnitude = magnitude*10 + uint64(c-'0')
+	}
+	if intDigits == 0 {
+		return 0, fmt.Errorf("%w: no digits", ErrInvalidMoney)
+	}
+	if fracDigits == 0 {
+		return 0, fmt.Errorf("%w: no digits after '.'", ErrInvalidMoney)
+	}
+	if fracDigits < 0 {
+		fracDigits = 0
+	}
+	for i := fracDigits; i < MoneyExponent; i++ {
+		if magnitude > math.MaxUint64/10 {
+			return 0, ErrMoneyOverflow
+		}
+		magnitude *= 10
+	}
+
+	if negative {
+		if magnitude > uint64(math.MaxInt64)+1 {
+			return 0, ErrMoneyOverflow
+		}
+		return Money(-magnitude), nil
+	}
+	if magnitude > math.MaxInt64 {
+		return 0, ErrMoneyOverflow
+	}
+	return Money(magnitude), nil
+}
+
+func (m Money) String() string {
+	sign := ""
+	magnitude := uint64(m)
+	if m < 0 {
+		sign = "-"
+		magnitude = -magnitude
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, magnitude/moneyScale, magnitude%moneyScale)
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	sum := m + other
+	if (other > 0 && sum < m) || (other < 0 && sum > m) {
+		return 0, ErrMoneyOverflow
+	}
+	return sum, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	diff := m - other
+	if (other > 0 && diff > m) || (other < 0 && diff < m) {
+		return 0, ErrMoneyOverflow
+	}
+	return diff, nil
+}
+
+// MulRatio return m * num / den rounded half to even
+func (m Money) MulRatio(num, den int64) (Money, error) {
+	if den == 0 {
+		return 0, fmt.Errorf("%w: zero denominator", ErrInvalidMoney)
+	}
+	product := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(num))
+	divisor := big.NewInt(den)
+	quo, rem := new(big.Int).QuoRem(product, divisor, new(big.Int))
+
+	// compare 2*|rem| with |den| to decide rounding of truncated quotient
+	twiceRem := new(big.Int).Abs(rem)
+	twiceRem.Lsh(twiceRem, 1)
+	cmp := twiceRem.Cmp(new(big.Int).Abs(divisor))
+	if cmp > 0 || (cmp == 0 && quo.Bit(0) == 1) {
+		if product.Sign()*divisor.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	if !quo.IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+	return Money(quo.Int64()), nil
+}
+
+// MulRatioTruncated return m * num / den rounded toward zero
+func (m Money) MulRatioTruncated(num, den int64) (Money, error) {
+	if den == 0 {
+		return 0, fmt.Errorf("%w: zero denominator", ErrInvalidMoney)
+	}
+	product := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(num))
+	quo := product.Quo(product, big.NewInt(den))
+	if !quo.IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+	return Money(quo.Int64()), nil
+}
+
+// MarshalJSON encodes money as a decimal string to keep it exact
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(m.String())), nil
+}
+
+// UnmarshalJSON accepts a decimal string or a bare number with the same syntax. null is ignored
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	value, err := StringToMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = value
+	return nil
+}
+
+func (m Money) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalText(data []byte) error {
+	value, err := StringToMoney(string(data))
+	if err != nil {
+		return err
+	}
+	*m = value
+	return nil
+}
+
+// Value passes money to database as a decimal string
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan reads decimal strings written by Value. Integer column holds whole major units, so int64
+// is scaled to minor units and 5 is read as 5.00. It doesn't round trip with Value, store money
+// in numeric columns
+func (m *Money) Scan(src interface{}) error {
+	var err error
+	var value Money
+	switch src := src.(type) {
+	case string:
+		value, err = StringToMoney(src)
+	case []byte:
+		value, err = StringToMoney(string(src))
+	case int64:
+		if src > math.MaxInt64/moneyScale || src < math.MinInt64/moneyScale {
+			return ErrMoneyOverflow
+		}
+		value = Money(src * moneyScale)
+	case nil:
+		err = fmt.Errorf("%w: NULL", ErrInvalidMoney)
+	default:
+		err = fmt.Errorf("src must be a string, []byte or int64 not %s", reflect.TypeOf(src).String())
+	}
+	if err != nil {
+		return err
+	}
+	*m = value
+	return nil
+}
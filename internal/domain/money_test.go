@@ -0,0 +1,209 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func FuzzStringToMoney(f *testing.F) {
+	for _, seed := range []string{"0", "-0", "1.5", "-1.05", "100.25", "92233720368547758.07",
+		"-92233720368547758.08", "92233720368547758.08", "1.", ".5", "1.234", "--1", "+1", "", "0x10"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		m, err := StringToMoney(s)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidMoney) && !errors.Is(err, ErrMoneyOverflow) {
+				t.Fatalf("StringToMoney(%q) returned unexpected error %v", s, err)
+			}
+			return
+		}
+		parsed, err := StringToMoney(m.String())
+		if err != nil {
+			t.Fatalf("StringToMoney(%q) of %q failed: %v", m.String(), s, err)
+		}
+		if parsed != m {
+			t.Fatalf("StringToMoney(%q) = %d, want %d parsed from %q", m.String(), parsed, m, s)
+		}
+	})
+}
+
+func FuzzMoneyRoundTrip(f *testing.F) {
+	for _, seed := range []int64{0, 1, -1, 99, -100, 12345, math.MaxInt64, math.MinInt64} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, minor int64) {
+		m := Money(minor)
+
+		parsed, err := StringToMoney(m.String())
+		if err != nil || parsed != m {
+			t.Fatalf("String: got %d, %v, want %d", parsed, err, m)
+		}
+
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("MarshalJSON(%d): %v", m, err)
+		}
+		var fromJSON Money
+		if err := json.Unmarshal(data, &fromJSON); err != nil || fromJSON != m {
+			t.Fatalf("JSON %s: got %d, %v, want %d", data, fromJSON, err, m)
+		}
+
+		text, err := m.MarshalText()
+		if err != nil {
+			t.Fatalf("MarshalText(%d): %v", m, err)
+		}
+		var fromText Money
+		if err := fromText.UnmarshalText(text); err != nil || fromText != m {
+			t.Fatalf("Text %s: got %d, %v, want %d", text, fromText, err, m)
+		}
+
+		value, err := m.Value()
+		if err != nil {
+			t.Fatalf("Value(%d): %v", m, err)
+		}
+		var scanned Money
+		if err := scanned.Scan(value); err != nil || scanned != m {
+			t.Fatalf("Scan(%v): got %d, %v, want %d", value, scanned, err, m)
+		}
+		if err := scanned.Scan([]byte(value.(string))); err != nil || scanned != m {
+			t.Fatalf("Scan([]byte %v): got %d, %v, want %d", value, scanned, err, m)
+		}
+	})
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		data string
+		want Money
+		err  error
+	}{
+		{`"1.50"`, 150, nil},
+		{`1.5`, 150, nil},
+		{`-0.01`, -1, nil},
+		{`"1.234"`, 0, ErrInvalidMoney},
+		{`1e2`, 0, ErrInvalidMoney},
+		{`"92233720368547758.08"`, 0, ErrMoneyOverflow},
+	}
+	for _, test := range tests {
+		var m Money
+		err := json.Unmarshal([]byte(test.data), &m)
+		if !errors.Is(err, test.err) || m != test.want {
+			t.Errorf("Unmarshal(%s) = %d, %v, want %d, %v", test.data, m, err, test.want, test.err)
+		}
+	}
+
+	m := Money(7)
+	if err := json.Unmarshal([]byte("null"), &m); err != nil || m != 7 {
+		t.Errorf("Unmarshal(null) = %d, %v, want 7 unchanged", m, err)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		src  interface{}
+		want Money
+		err  error
+	}{
+		{"12.34", 1234, nil},
+		{[]byte("-0.05"), -5, nil},
+		{int64(5), 500, nil},
+		{int64(-3), -300, nil},
+		{int64(math.MaxInt64/moneyScale + 1), 0, ErrMoneyOverflow},
+		{int64(math.MinInt64/moneyScale - 1), 0, ErrMoneyOverflow},
+		{nil, 0, ErrInvalidMoney},
+	}
+	for _, test := range tests {
+		var m Money
+		err := m.Scan(test.src)
+		if !errors.Is(err, test.err) || m != test.want {
+			t.Errorf("Scan(%#v) = %d, %v, want %d, %v", test.src, m, err, test.want, test.err)
+		}
+	}
+
+	var m Money
+	if err := m.Scan(1.5); err == nil {
+		t.Errorf("Scan(float64) succeeded with %d", m)
+	}
+}
+
+func TestMoneyAddSub(t *testing.T) {
+	tests := []struct {
+		a, b    Money
+		sum     Money
+		sumErr  error
+		diff    Money
+		diffErr error
+	}{
+		{150, 25, 175, nil, 125, nil},
+		{-150, 25, -125, nil, -175, nil},
+		{math.MaxInt64, 1, 0, ErrMoneyOverflow, math.MaxInt64 - 1, nil},
+		{math.MinInt64, -1, 0, ErrMoneyOverflow, math.MinInt64 + 1, nil},
+		{math.MinInt64, 1, math.MinInt64 + 1, nil, 0, ErrMoneyOverflow},
+		{math.MaxInt64, -1, math.MaxInt64 - 1, nil, 0, ErrMoneyOverflow},
+		{0, math.MinInt64, math.MinInt64, nil, 0, ErrMoneyOverflow},
+		{-1, math.MinInt64, 0, ErrMoneyOverflow, math.MaxInt64, nil},
+	}
+	for _, test := range tests {
+		sum, err := test.a.Add(test.b)
+		if !errors.Is(err, test.sumErr) || sum != test.sum {
+			t.Errorf("%d.Add(%d) = %d, %v, want %d, %v", test.a, test.b, sum, err, test.sum, test.sumErr)
+		}
+		diff, err := test.a.Sub(test.b)
+		if !errors.Is(err, test.diffErr) || diff != test.diff {
+			t.Errorf("%d.Sub(%d) = %d, %v, want %d, %v", test.a, test.b, diff, err, test.diff, test.diffErr)
+		}
+	}
+}
+
+func TestMoneyMulRatio(t *testing.T) {
+	tests := []struct {
+		m        Money
+		num, den int64
+		want     Money
+		err      error
+	}{
+		{100, 1, 3, 33, nil},
+		{200, 1, 3, 67, nil},
+		// ties go to even
+		{5, 1, 2, 2, nil},
+		{15, 1, 2, 8, nil},
+		{25, 1, 2, 12, nil},
+		{-5, 1, 2, -2, nil},
+		{-15, 1, 2, -8, nil},
+		{15, -1, 2, -8, nil},
+		{15, 1, -2, -8, nil},
+		{-15, -1, -2, -8, nil},
+		{1000, 150, 10000, 15, nil},
+		{math.MaxInt64, 2, 2, math.MaxInt64, nil},
+		{math.MaxInt64, 3, 2, 0, ErrMoneyOverflow},
+		{math.MinInt64, -1, 1, 0, ErrMoneyOverflow},
+		{100, 1, 0, 0, ErrInvalidMoney},
+	}
+	for _, test := range tests {
+		got, err := test.m.MulRatio(test.num, test.den)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Errorf("%d.MulRatio(%d, %d) = %d, %v, want %d, %v", test.m, test.num, test.den, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestMoneyMulRatioTruncated(t *testing.T) {
+	tests := []struct {
+		m        Money
+		num, den int64
+		want     Money
+	}{
+		{15, 1, 2, 7},
+		{-15, 1, 2, -7},
+		{200, 1, 3, 66},
+	}
+	for _, test := range tests {
+		got, err := test.m.MulRatioTruncated(test.num, test.den)
+		if err != nil || got != test.want {
+			t.Errorf("%d.MulRatioTruncated(%d, %d) = %d, %v, want %d", test.m, test.num, test.den, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package domain
+
+import "time"
+
+// Allowed transitions of order lines: CREATED -> RESERVED, CANCELED; RESERVED -> FULFILLED, CANCELED;
+// FULFILLED -> REFUNDED
+const (
+	OrderStatusCreated   = "CREATED"
+	OrderStatusReserved  = "RESERVED"
+	OrderStatusFulfilled = "FULFILLED"
+	OrderStatusCanceled  = "CANCELED"
+	OrderStatusRefunded  = "REFUNDED"
+)
+
+const MaxOrderLines = 100
+
+// Order is created by POST /v1/orders or by the first reservation with its order id.
+// Amount is sum of lines. Order is CREATED or RESERVED while any line is, CANCELED if all lines
+// are canceled, FULFILLED while any line is fulfilled and REFUNDED otherwise
+type Order struct {
+	Id        uint        `json:"id" db:"id"`
+	UserId    uint        `json:"user_id" db:"user_id"`
+	Amount    Money       `json:"amount" db:"amount"`
+	Currency  Currency    `json:"currency" db:"currency"`
+	Status    string      `json:"status" db:"status"`
+	CreatedAt time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at" db:"updated_at"`
+	Lines     []OrderLine `json:"lines" db:"-"`
+}
+
+// OrderLine is reserved, recognized and canceled as a separate transaction, so revenue is reported by its service
+type OrderLine struct {
+	ServiceId uint      `json:"service_id" db:"service_id"`
+	Amount    Money     `json:"amount" db:"amount"`
+	Status    string    `json:"status" db:"status"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
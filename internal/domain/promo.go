@@ -0,0 +1,17 @@
+package domain
+
+import "time"
+
+// PromoGrant is promotional credit of user. Remaining can be spent on services until ExpiresAt,
+// after that it is moved to Expired
+type PromoGrant struct {
+	Id          uint      `json:"id" db:"id"`
+	UserId      uint      `json:"user_id" db:"user_id"`
+	Currency    Currency  `json:"currency" db:"currency"`
+	Amount      Money     `json:"amount" db:"amount"`
+	Remaining   Money     `json:"remaining" db:"remaining"`
+	Expired     Money     `json:"expired" db:"expired"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+	Description string    `json:"description" db:"description"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,21 @@
+package domain
+
+import "time"
+
+// Service is an entry of service catalog. Reservations for inactive service are rejected
+type Service struct {
+	Id           uint      `json:"id" db:"id"`
+	Name         string    `json:"name" db:"name"`
+	Description  string    `json:"description" db:"description"`
+	Category     string    `json:"category" db:"category"`
+	Active       bool      `json:"active" db:"active"`
+	DefaultPrice Money     `json:"default_price" db:"default_price"`
+	Currency     Currency  `json:"currency" db:"currency"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// ServiceName is a name which service had since ValidFrom
+type ServiceName struct {
+	Name      string    `json:"name" db:"name"`
+	ValidFrom time.Time `json:"valid_from" db:"valid_from"`
+}
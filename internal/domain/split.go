@@ -0,0 +1,63 @@
+package domain
+
+import (
+	"fmt"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// MaxSplits limits recipients of one recognized payment
+const MaxSplits = 20
+
+// Split pays out part of recognized payment to recipient. Part is either fixed Amount
+// or BasisPoints of the payment rounded down to minor unit
+type Split struct {
+	UserId      uint  `json:"user_id"`
+	Amount      Money `json:"amount"`
+	BasisPoints int64 `json:"basis_points"`
+}
+
+// Payout is part of payment credited to recipient, computed from Split
+type Payout struct {
+	UserId uint  `json:"user_id"`
+	Amount Money `json:"amount"`
+}
+
+// splitsRule checks splits of amount in currency. Recipients must be distinct and differ from payer,
+// remainderTo must be one of them if it is set
+func splitsRule(splits []Split, payer uint, amount Money, currency Currency, remainderTo uint) validation.Rule {
+	return validation.By(func(interface{}) error {
+		recipients := make(map[uint]bool, len(splits))
+		var fixed Money
+		var basisPoints int64
+		for i, split := range splits {
+			err := validation.ValidateStruct(&split,
+				validation.Field(&split.UserId, validation.Required, validation.Min(uint(1)),
+					validation.NotIn(payer).Error("must differ from payer")),
+				validation.Field(&split.Amount, NonNegativeAmount, AmountFitsCurrency(currency)),
+				validation.Field(&split.BasisPoints, validation.Min(0), validation.Max(MaxBasisPoints)),
+			)
+			if err != nil {
+				return fmt.Errorf("split %d: %v", i, err)
+			}
+			if (split.Amount == 0) == (split.BasisPoints == 0) {
+				return fmt.Errorf("split %d: exactly one of amount and basis_points must be set", i)
+			}
+			if recipients[split.UserId] {
+				return fmt.Errorf("split %d: duplicate recipient %d", i, split.UserId)
+			}
+			recipients[split.UserId] = true
+			fixed += split.Amount
+			basisPoints += split.BasisPoints
+		}
+		if fixed > amount {
+			return fmt.Errorf("fixed splits exceed amount")
+		}
+		if basisPoints > MaxBasisPoints {
+			return fmt.Errorf("basis_points of splits exceed %d", MaxBasisPoints)
+		}
+		if remainderTo != 0 && !recipients[remainderTo] {
+			return fmt.Errorf("remainder_to must be one of recipients")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,44 @@
+package domain
+
+import "time"
+
+const (
+	SubscriptionPeriodDay   = "DAY"
+	SubscriptionPeriodWeek  = "WEEK"
+	SubscriptionPeriodMonth = "MONTH"
+	SubscriptionPeriodYear  = "YEAR"
+)
+
+var SubscriptionPeriods = []interface{}{
+	SubscriptionPeriodDay,
+	SubscriptionPeriodWeek,
+	SubscriptionPeriodMonth,
+	SubscriptionPeriodYear,
+}
+
+const (
+	SubscriptionStatusActive = "ACTIVE"
+	// SubscriptionStatusPaused isn't charged until resumed
+	SubscriptionStatusPaused = "PAUSED"
+	// SubscriptionStatusCanceled can't be resumed
+	SubscriptionStatusCanceled = "CANCELED"
+)
+
+// Subscription charges Amount for ServiceId every Period starting at NextRunAt.
+// Failures counts failed attempts since the last successful charge
+type Subscription struct {
+	Id          uint       `json:"id" db:"id"`
+	UserId      uint       `json:"user_id" db:"user_id"`
+	ServiceId   uint       `json:"service_id" db:"service_id"`
+	Amount      Money      `json:"amount" db:"amount"`
+	Currency    Currency   `json:"currency" db:"currency"`
+	Period      string     `json:"period" db:"period"`
+	Description string     `json:"description" db:"description"`
+	Status      string     `json:"status" db:"status"`
+	NextRunAt   time.Time  `json:"next_run_at" db:"next_run_at"`
+	Failures    int        `json:"failures" db:"failures"`
+	LastError   string     `json:"last_error,omitempty" db:"last_error"`
+	RetryAt     *time.Time `json:"retry_at,omitempty" db:"retry_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+}
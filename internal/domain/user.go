@@ -0,0 +1,33 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// MaxMetadataSize is max size of user metadata in bytes
+const MaxMetadataSize = 4096
+
+type User struct {
+	Id          uint            `json:"id"`
+	Status      string          `json:"status"`
+	ExternalRef string          `json:"external_ref,omitempty"`
+	Metadata    json.RawMessage `json:"metadata"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+func validMetadata(value interface{}) error {
+	metadata, _ := value.(json.RawMessage)
+	if len(metadata) == 0 || string(metadata) == "null" {
+		return nil
+	}
+	if len(metadata) > MaxMetadataSize {
+		return fmt.Errorf("must be at most %d bytes", MaxMetadataSize)
+	}
+	if trimmed := bytes.TrimSpace(metadata); len(trimmed) == 0 || trimmed[0] != '{' {
+		return fmt.Errorf("must be a JSON object")
+	}
+	return nil
+}
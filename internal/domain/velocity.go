@@ -0,0 +1,46 @@
+package domain
+
+import "time"
+
+const (
+	VelocityMetricAmount = "AMOUNT"
+	VelocityMetricCount  = "COUNT"
+)
+
+// Operations checked by velocity rules
+const (
+	SpendingOperationReserve = "reserve"
+	SpendingOperationConvert = "convert"
+	SpendingOperationEscrow  = "escrow"
+)
+
+// VelocityRule limits spent amount or number of operations of user in Currency during the last
+// WindowSeconds. Rule with zero UserId is global
+type VelocityRule struct {
+	Id            uint      `json:"id" db:"id"`
+	UserId        uint      `json:"user_id,omitempty" db:"user_id"`
+	Currency      Currency  `json:"currency" db:"currency"`
+	Metric        string    `json:"metric" db:"metric"`
+	WindowSeconds int       `json:"window_seconds" db:"window_seconds"`
+	MaxAmount     Money     `json:"max_amount" db:"max_amount"`
+	MaxCount      int       `json:"max_count" db:"max_count"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// Spending is spent amount and number of operations during a window
+type Spending struct {
+	Amount Money `db:"amount"`
+	Count  int   `db:"count"`
+}
+
+type VelocityViolation struct {
+	Id        uint     `json:"id" db:"id"`
+	UserId    uint     `json:"user_id" db:"user_id"`
+	Currency  Currency `json:"currency" db:"currency"`
+	RuleId    uint     `json:"rule_id" db:"rule_id"`
+	Operation string   `json:"operation" db:"operation"`
+	Amount    Money    `json:"amount" db:"amount"`
+	// Used is spent amount or number of operations in window of the rule before the operation
+	Used      string    `json:"used" db:"used"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	EventReplenished = "replenished"
+	EventReserved    = "reserved"
+	EventRecognized  = "recognized"
+	EventCanceled    = "canceled"
+	EventRefunded    = "refunded"
+	EventAdjusted    = "adjusted"
+	EventConverted   = "converted"
+	// EventCharged is sent for subscription charge which is paid without reservation
+	EventCharged = "charged"
+	// EventPaidOut is sent to recipient of part of recognized payment
+	EventPaidOut = "paid_out"
+	// EventEscrowed is sent to buyer whose money is held by escrow
+	EventEscrowed = "escrowed"
+	// EventReleased is sent to seller who gets money of escrow. Refunded escrow is sent as EventRefunded
+	EventReleased = "released"
+)
+
+var EventTypes = []interface{}{
+	EventReplenished,
+	EventReserved,
+	EventRecognized,
+	EventCanceled,
+	EventRefunded,
+	EventAdjusted,
+	EventConverted,
+	EventCharged,
+	EventPaidOut,
+	EventEscrowed,
+	EventReleased,
+}
+
+const (
+	WebhookDeliveryStatusPending = "PENDING"
+	WebhookDeliveryStatusDone    = "DONE"
+	WebhookDeliveryStatusDead    = "DEAD"
+)
+
+// Event describes money change which is delivered to webhook subscribers
+type Event struct {
+	Type      string   `json:"event"`
+	UserId    uint     `json:"user_id"`
+	Amount    Money    `json:"amount"`
+	Currency  Currency `json:"currency"`
+	ServiceId uint     `json:"service_id,omitempty"`
+	OrderId   uint     `json:"order_id,omitempty"`
+	EscrowId  uint     `json:"escrow_id,omitempty"`
+	// Fee is platform fee reserved together with Amount
+	Fee Money `json:"fee,omitempty"`
+	// ToAmount and ToCurrency are set for conversion, Amount is taken from Currency wallet
+	ToAmount   Money     `json:"to_amount,omitempty"`
+	ToCurrency Currency  `json:"to_currency,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+type Webhook struct {
+	Id        uint      `json:"id" db:"id"`
+	URL       string    `json:"url" db:"url"`
+	Events    []string  `json:"events" db:"events"`
+	Secret    string    `json:"-" db:"secret"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// WebhookDelivery is an outbox row joined with its subscription
+type WebhookDelivery struct {
+	Id        uint            `db:"id"`
+	WebhookId uint            `db:"subscription_id"`
+	URL       string          `db:"url"`
+	Secret    string          `db:"secret"`
+	Event     string          `db:"event"`
+	Payload   json.RawMessage `db:"payload"`
+	Attempts  int             `db:"attempts"`
+}
@@ -0,0 +1,8 @@
+package escrow
+
+import "time"
+
+type Config struct {
+	SweepInterval time.Duration
+	BatchSize     int
+}
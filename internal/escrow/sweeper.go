@@ -0,0 +1,68 @@
+package escrow
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"github.com/manimadzis/avito-job/pkg/logging"
+	"time"
+)
+
+// Sweeper releases or refunds held escrows after their expiry by timeout policy. Disputed escrows are skipped
+type Sweeper interface {
+	// Run blocks until ctx is done
+	Run(ctx context.Context)
+}
+
+type sweeper struct {
+	config *Config
+	repo   repository.Repository
+	logger logging.Logger
+}
+
+func NewSweeper(config *Config, repo repository.Repository, logger logging.Logger) Sweeper {
+	return &sweeper{
+		config: config,
+		repo:   repo,
+		logger: logger,
+	}
+}
+
+func (s *sweeper) Run(ctx context.Context) {
+	s.logger.Info("Starting escrow sweeper...")
+	ctx = domain.WithAuditInfo(ctx, domain.AuditInfo{Actor: "escrow-sweeper", Operation: "expire escrow"})
+	ticker := time.NewTicker(s.config.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Escrow sweeper stopped")
+			return
+		case <-ticker.C:
+			s.sweep(ctx)
+		}
+	}
+}
+
+func (s *sweeper) sweep(ctx context.Context) {
+	ids, err := s.repo.GetExpiredEscrows(ctx, s.config.BatchSize)
+	if err != nil {
+		s.logger.Errorf("Can't get expired escrows: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		escrow, err := s.repo.ExpireEscrow(ctx, id)
+		if err == repository.ErrEscrowNotExpired {
+			// settled or disputed after it was selected
+			continue
+		} else if err != nil {
+			// escrow stays held and is retried on the next sweep
+			s.logger.Errorf("Can't expire escrow %d: %v", id, err)
+			continue
+		}
+		s.logger.Infof("Escrow %d expired and %s", escrow.Id, escrow.Status)
+	}
+}
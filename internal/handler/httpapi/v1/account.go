@@ -0,0 +1,99 @@
+package v1
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"net/http"
+)
+
+func (h *Handler) getAccount(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getAccount handle request %v", r)
+	var err error
+	dto := domain.GetAccountDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidUserId.Error()})
+		h.logger.Error(ErrInvalidUserId, " ", err)
+		return
+	}
+
+	account, err := h.service.GetAccount(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get account: %v", err)
+		if err == repository.ErrUnknownUser {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusOK, account)
+}
+
+func (h *Handler) setAccountStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("setAccountStatus handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.SetAccountStatusDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	err = h.service.SetAccountStatus(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to set account status: %v", err)
+		switch err {
+		case repository.ErrUnknownUser, repository.ErrInvalidStatusTransition:
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		case repository.ErrAccountNotEmpty:
+			h.sendError(w, http.StatusConflict, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusNoContent, nil)
+}
+
+func (h *Handler) getAccountStatusChanges(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getAccountStatusChanges handle request %v", r)
+	var err error
+	dto := domain.GetAccountDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidUserId.Error()})
+		h.logger.Error(ErrInvalidUserId, " ", err)
+		return
+	}
+
+	changes, err := h.service.GetAccountStatusChanges(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get account status changes: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if changes == nil {
+		changes = []domain.AccountStatusChange{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  changes,
+		Length: len(changes),
+	})
+}
@@ -0,0 +1,254 @@
+package v1
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	headerAuthorization = "Authorization"
+	headerForwardedFor  = "X-Forwarded-For"
+	headerClientId      = "X-Client-Id"
+	headerRequestId     = "X-Request-Id"
+
+	anonymousActor = "anonymous"
+	bearerPrefix   = "Bearer "
+)
+
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// withAuditInfo attaches audit info to request context. Request id is generated unless client sends it
+// and is returned in X-Request-Id. Body is hashed while handler reads it
+func (h *Handler) withAuditInfo(w http.ResponseWriter, r *http.Request) *http.Request {
+	requestId := r.Header.Get(headerRequestId)
+	if requestId == "" {
+		requestId = newRequestId()
+	}
+	w.Header().Set(headerRequestId, requestId)
+
+	actor := h.actor(r)
+	client := r.Header.Get(headerClientId)
+	if client == "" {
+		client = r.UserAgent()
+	}
+
+	payload := sha256.New()
+	r.Body = teeReadCloser{Reader: io.TeeReader(r.Body, payload), Closer: r.Body}
+	return r.WithContext(domain.WithAuditInfo(r.Context(), domain.AuditInfo{
+		Actor:     actor,
+		Client:    client,
+		RequestId: requestId,
+		SourceIP:  h.sourceIP(r),
+		Operation: h.operation(r),
+		PayloadHash: func() string {
+			return hex.EncodeToString(payload.Sum(nil))
+		},
+	}))
+}
+
+// operation return method and route of request, e.g. POST /v1/user/:user_id/reserve
+func (h *Handler) operation(r *http.Request) string {
+	path := r.URL.Path
+	if _, ps, _ := h.router.Lookup(r.Method, path); len(ps) > 0 {
+		segments := strings.Split(path, "/")
+		for _, param := range ps {
+			for i, segment := range segments {
+				if segment == param.Value {
+					segments[i] = ":" + param.Key
+					break
+				}
+			}
+		}
+		path = strings.Join(segments, "/")
+	}
+	return r.Method + " " + path
+}
+
+// actor returns the name of API token sent in Authorization header. Requests without known token are anonymous
+func (h *Handler) actor(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get(headerAuthorization), bearerPrefix)
+	if !ok || token == "" {
+		return anonymousActor
+	}
+	actor := anonymousActor
+	for name, known := range h.config.APITokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			actor = name
+		}
+	}
+	return actor
+}
+
+// sourceIP returns address of the peer. X-Forwarded-For is trusted only when the peer is a configured proxy,
+// then the nearest address not belonging to trusted proxies is returned
+func (h *Handler) sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !h.trustedProxy(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values(headerForwardedFor), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !h.trustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+func (h *Handler) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range h.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses addresses and CIDR networks of proxies. Invalid entries are skipped
+func (h *Handler) parseTrustedProxies() {
+	for _, proxy := range h.config.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			h.logger.Errorf("Skip invalid trusted proxy %q: %v", proxy, err)
+			continue
+		}
+		h.trustedProxies = append(h.trustedProxies, network)
+	}
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (h *Handler) getAuditLog(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getAuditLog handle request %v", r)
+	dto, err := h.getAuditLogDTO(r)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		return
+	}
+
+	entries, err := h.service.GetAuditLog(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get audit log: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if entries == nil {
+		entries = []domain.AuditEntry{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  entries,
+		Length: len(entries),
+	})
+}
+
+func (h *Handler) exportAuditLog(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("exportAuditLog handle request %v", r)
+	dto, err := h.getAuditLogDTO(r)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	// status is already sent when export fails, so the error is only logged
+	if err := h.service.ExportAuditLog(r.Context(), &dto, w); err != nil {
+		h.logger.Errorf("Failed to export audit log: %v", err)
+	}
+}
+
+func (h *Handler) verifyAuditLog(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("verifyAuditLog handle request %v", r)
+	verification, err := h.service.VerifyAuditLog(r.Context())
+	if err != nil {
+		h.logger.Errorf("Failed to verify audit log: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	h.sendResponse(w, http.StatusOK, struct {
+		domain.AuditVerification
+		Valid bool `json:"valid"`
+	}{
+		AuditVerification: verification,
+		Valid:             verification.BrokenId == 0,
+	})
+}
+
+// getAuditLogDTO parses filters user_id, request_id, from, to, after_id and limit of query
+func (h *Handler) getAuditLogDTO(r *http.Request) (domain.GetAuditLogDTO, error) {
+	dto := domain.GetAuditLogDTO{}
+	var err error
+	dto.UserId, err = h.getUserIdQuery(r)
+	if err != nil {
+		return dto, err
+	}
+	query := r.URL.Query()
+	dto.RequestId = query.Get("request_id")
+	if from := query.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return dto, ErrInvalidFrom
+		}
+		dto.From = &t
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return dto, ErrInvalidTo
+		}
+		dto.To = &t
+	}
+	if afterId := query.Get("after_id"); afterId != "" {
+		id, err := strconv.ParseUint(afterId, 10, 0)
+		if err != nil {
+			return dto, ErrInvalidAfterId
+		}
+		dto.AfterId = uint(id)
+	}
+	if limit := query.Get("limit"); limit != "" {
+		dto.Limit, err = strconv.Atoi(limit)
+		if err != nil || dto.Limit < 0 {
+			return dto, ErrInvalidLimit
+		}
+	}
+	return dto, dto.Validate()
+}
@@ -0,0 +1,127 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"github.com/manimadzis/avito-job/internal/service"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	batchFlushEvery   = 100
+	batchWriteTimeout = 15 * time.Second
+)
+
+type BatchErrorResponse struct {
+	Msg   string `json:"msg"`
+	Index int    `json:"index"`
+}
+
+func (h *Handler) executeBatch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("executeBatch handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.BatchDTO{}
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	if dto.Mode == domain.BatchModeAtomic {
+		var results []domain.BatchResult
+		err := h.service.ExecuteBatch(r.Context(), &dto, func(result domain.BatchResult) error {
+			results = append(results, result)
+			return nil
+		})
+		var batchErr *service.BatchError
+		if errors.As(err, &batchErr) && isClientError(batchErr.Err) {
+			h.sendResponse(w, http.StatusBadRequest, BatchErrorResponse{Msg: batchErr.Err.Error(), Index: batchErr.Index})
+			return
+		} else if err != nil {
+			h.logger.Errorf("Atomic batch failed: %v", err)
+			h.sendResponse(w, http.StatusInternalServerError, nil)
+			return
+		}
+		h.sendResponse(w, http.StatusOK, Collection{
+			Items:  results,
+			Length: len(results),
+		})
+		return
+	}
+
+	// best-effort results are streamed as soon as operations complete
+	flusher, _ := w.(http.Flusher)
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, `{"items":[`)
+
+	length := 0
+	err = h.service.ExecuteBatch(r.Context(), &dto, func(result domain.BatchResult) error {
+		if result.Err != nil {
+			result.Error = h.batchErrorMessage(result.Err)
+		}
+		item, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		if length > 0 {
+			io.WriteString(w, ",")
+		}
+		if _, err := w.Write(item); err != nil {
+			return err
+		}
+		length++
+		if length%batchFlushEvery == 0 && flusher != nil {
+			controller.SetWriteDeadline(time.Now().Add(batchWriteTimeout))
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// status is already sent, client gets truncated body
+		h.logger.Errorf("Best-effort batch interrupted: %v", err)
+		return
+	}
+
+	io.WriteString(w, `],"length":`+strconv.Itoa(length)+"}")
+}
+
+func (h *Handler) batchErrorMessage(err error) string {
+	if isClientError(err) {
+		return err.Error()
+	}
+	h.logger.Errorf("Batch operation failed: %v", err)
+	return "internal error"
+}
+
+func isClientError(err error) bool {
+	return errors.Is(err, service.ErrInvalidOperation) ||
+		errors.Is(err, repository.ErrUnknownUser) ||
+		errors.Is(err, repository.ErrNotEnoughMoney) ||
+		errors.Is(err, repository.ErrCreditLimitExceeded) ||
+		errors.Is(err, service.ErrVelocityLimitExceeded) ||
+		errors.Is(err, repository.ErrAccountFrozen) ||
+		errors.Is(err, repository.ErrAccountClosed) ||
+		errors.Is(err, repository.ErrUnknownService) ||
+		errors.Is(err, repository.ErrServiceInactive) ||
+		errors.Is(err, repository.ErrUnknownTransaction) ||
+		errors.Is(err, repository.ErrTransactionAlreadyExists) ||
+		errors.Is(err, repository.ErrUnknownCurrency) ||
+		errors.Is(err, repository.ErrCurrencyMismatch) ||
+		errors.Is(err, repository.ErrOrderMismatch) ||
+		errors.Is(err, repository.ErrInvalidOrderTransition) ||
+		errors.Is(err, repository.ErrUnknownRecipient) ||
+		errors.Is(err, repository.ErrRecipientInactive) ||
+		errors.Is(err, service.ErrSplitsExceedAmount)
+}
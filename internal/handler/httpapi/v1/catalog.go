@@ -0,0 +1,184 @@
+package v1
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"net/http"
+	"strconv"
+)
+
+func (h *Handler) createService(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("createService handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.CreateServiceDTO{}
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	service, err := h.service.CreateService(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to create service: %v", err)
+		if err == repository.ErrServiceAlreadyExists {
+			h.sendError(w, http.StatusConflict, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrUnknownCurrency {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusCreated, service)
+}
+
+func (h *Handler) updateService(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("updateService handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.UpdateServiceDTO{}
+	dto.ServiceId, err = h.getServiceId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	service, err := h.service.UpdateService(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to update service: %v", err)
+		if err == repository.ErrUnknownService {
+			h.sendError(w, http.StatusNotFound, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrUnknownCurrency {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusOK, service)
+}
+
+func (h *Handler) deactivateService(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("deactivateService handle request %v", r)
+	var err error
+	dto := domain.GetServiceDTO{}
+	dto.ServiceId, err = h.getServiceId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	err = h.service.DeactivateService(r.Context(), &dto)
+	if err != nil {
+		if err == repository.ErrUnknownService {
+			h.sendError(w, http.StatusNotFound, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to deactivate service: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusNoContent, nil)
+}
+
+func (h *Handler) getService(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getService handle request %v", r)
+	var err error
+	dto := domain.GetServiceDTO{}
+	dto.ServiceId, err = h.getServiceId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	service, err := h.service.GetService(r.Context(), &dto)
+	if err != nil {
+		if err == repository.ErrUnknownService {
+			h.sendError(w, http.StatusNotFound, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to get service: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusOK, service)
+}
+
+func (h *Handler) getServices(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getServices handle request %v", r)
+	query := r.URL.Query()
+	dto := domain.GetServicesDTO{Category: query.Get("category")}
+	if active := query.Get("active"); active != "" {
+		var err error
+		dto.ActiveOnly, err = strconv.ParseBool(active)
+		if err != nil {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidActive.Error()})
+			return
+		}
+	}
+
+	services, err := h.service.GetServices(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get services: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if services == nil {
+		services = []domain.Service{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  services,
+		Length: len(services),
+	})
+}
+
+func (h *Handler) getServiceNames(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getServiceNames handle request %v", r)
+	var err error
+	dto := domain.GetServiceDTO{}
+	dto.ServiceId, err = h.getServiceId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	names, err := h.service.GetServiceNames(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get service names: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if names == nil {
+		names = []domain.ServiceName{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  names,
+		Length: len(names),
+	})
+}
@@ -0,0 +1,68 @@
+package v1
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"net/http"
+	"time"
+)
+
+const dayLayout = "2006-01-02"
+
+func (h *Handler) verifyTransactionChain(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("verifyTransactionChain handle request %v", r)
+	verification, err := h.service.VerifyTransactionChain(r.Context())
+	if err != nil {
+		h.logger.Errorf("Failed to verify transaction chain: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	h.sendResponse(w, http.StatusOK, struct {
+		domain.TransactionChainVerification
+		Valid bool `json:"valid"`
+	}{
+		TransactionChainVerification: verification,
+		Valid:                        verification.BrokenSeq == 0 && verification.Unchained == 0,
+	})
+}
+
+func (h *Handler) getChainRoots(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getChainRoots handle request %v", r)
+	dto := domain.GetChainRootsDTO{}
+	query := r.URL.Query()
+	if from := query.Get("from"); from != "" {
+		day, err := time.Parse(dayLayout, from)
+		if err != nil {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidFromDay.Error()})
+			return
+		}
+		dto.From = &day
+	}
+	if to := query.Get("to"); to != "" {
+		day, err := time.Parse(dayLayout, to)
+		if err != nil {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidToDay.Error()})
+			return
+		}
+		dto.To = &day
+	}
+	if err := dto.Validate(); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		return
+	}
+
+	roots, err := h.service.GetChainRoots(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get chain roots: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if roots == nil {
+		roots = []domain.ChainRoot{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  roots,
+		Length: len(roots),
+	})
+}
@@ -3,4 +3,8 @@ package v1
 type Config struct {
 	Directory string
 	ServerURI string
+	// APITokens maps actor name to its API token
+	APITokens map[string]string
+	// TrustedProxies are addresses or CIDR networks of proxies allowed to set X-Forwarded-For
+	TrustedProxies []string
 }
@@ -0,0 +1,70 @@
+package v1
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"net/http"
+)
+
+func (h *Handler) setCreditLimit(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("setCreditLimit handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.SetCreditLimitDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	err = h.service.SetCreditLimit(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to set credit limit: %v", err)
+		if err == repository.ErrUnknownUser || err == repository.ErrUnknownCurrency {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusNoContent, nil)
+}
+
+func (h *Handler) getCreditLimitChanges(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getCreditLimitChanges handle request %v", r)
+	var err error
+	dto := domain.GetCreditLimitChangesDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidUserId.Error()})
+		h.logger.Error(ErrInvalidUserId, " ", err)
+		return
+	}
+
+	changes, err := h.service.GetCreditLimitChanges(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get credit limit changes: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if changes == nil {
+		changes = []domain.CreditLimitChange{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  changes,
+		Length: len(changes),
+	})
+}
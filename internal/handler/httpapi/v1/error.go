@@ -9,6 +9,25 @@ var (
 	ErrUnknownUser   = fmt.Errorf("unknown user")
 	ErrEmptyBody     = fmt.Errorf("empty body")
 	ErrEmptyJSON     = fmt.Errorf("empty body")
+
+	ErrInvalidWebhookId      = fmt.Errorf("invalid webhook_id")
+	ErrInvalidServiceId      = fmt.Errorf("invalid service_id")
+	ErrInvalidLastEventId    = fmt.Errorf("invalid last event id")
+	ErrInvalidCommit         = fmt.Errorf("invalid commit")
+	ErrInvalidComma          = fmt.Errorf("comma must be a single character")
+	ErrInvalidRuleId         = fmt.Errorf("invalid rule_id")
+	ErrInvalidLimit          = fmt.Errorf("invalid limit")
+	ErrInvalidActive         = fmt.Errorf("invalid active")
+	ErrInvalidOrderId        = fmt.Errorf("invalid order_id")
+	ErrInvalidSubscriptionId = fmt.Errorf("invalid subscription_id")
+	ErrInvalidEscrowId       = fmt.Errorf("invalid escrow_id")
+	ErrInvalidAfterId        = fmt.Errorf("invalid after_id")
+	ErrInvalidFrom           = fmt.Errorf("invalid from, RFC 3339 time is expected")
+	ErrInvalidTo             = fmt.Errorf("invalid to, RFC 3339 time is expected")
+	ErrInvalidAt             = fmt.Errorf("invalid at, RFC 3339 time is expected")
+	ErrInvalidFromDay        = fmt.Errorf("invalid from, YYYY-MM-DD is expected")
+	ErrInvalidToDay          = fmt.Errorf("invalid to, YYYY-MM-DD is expected")
+	ErrInvalidReservations   = fmt.Errorf("invalid reservations")
 )
 
 type ErrorResponse struct {
@@ -0,0 +1,181 @@
+package v1
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"github.com/manimadzis/avito-job/internal/service"
+	"net/http"
+	"strconv"
+)
+
+func (h *Handler) createEscrow(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("createEscrow handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.CreateEscrowDTO{}
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	escrow, err := h.service.CreateEscrow(r.Context(), &dto)
+	if err != nil {
+		if err == repository.ErrAccountFrozen || err == repository.ErrAccountClosed ||
+			err == repository.ErrRecipientInactive || errors.Is(err, service.ErrVelocityLimitExceeded) {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrUnknownUser || err == repository.ErrUnknownRecipient ||
+			err == repository.ErrNotEnoughMoney || err == repository.ErrCreditLimitExceeded ||
+			err == repository.ErrUnknownCurrency {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to create escrow: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusCreated, escrow)
+}
+
+func (h *Handler) getEscrow(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getEscrow handle request %v", r)
+	var err error
+	dto := domain.GetEscrowDTO{}
+	dto.EscrowId, err = h.getEscrowId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	escrow, err := h.service.GetEscrow(r.Context(), &dto)
+	if err != nil {
+		if err == repository.ErrUnknownEscrow {
+			h.sendError(w, http.StatusNotFound, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to get escrow: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusOK, escrow)
+}
+
+func (h *Handler) getEscrows(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getEscrows handle request %v", r)
+	var err error
+	dto := domain.GetEscrowsDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidUserId.Error()})
+		h.logger.Error(ErrInvalidUserId, " ", err)
+		return
+	}
+
+	escrows, err := h.service.GetEscrows(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get escrows: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if escrows == nil {
+		escrows = []domain.Escrow{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  escrows,
+		Length: len(escrows),
+	})
+}
+
+func (h *Handler) releaseEscrow(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("releaseEscrow handle request %v", r)
+	var err error
+	dto := domain.GetEscrowDTO{}
+	dto.EscrowId, err = h.getEscrowId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	escrow, err := h.service.ReleaseEscrow(r.Context(), &dto)
+	h.sendEscrowChange(w, escrow, err)
+}
+
+func (h *Handler) refundEscrow(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("refundEscrow handle request %v", r)
+	var err error
+	dto := domain.GetEscrowDTO{}
+	dto.EscrowId, err = h.getEscrowId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	escrow, err := h.service.RefundEscrow(r.Context(), &dto)
+	h.sendEscrowChange(w, escrow, err)
+}
+
+func (h *Handler) disputeEscrow(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("disputeEscrow handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.DisputeEscrowDTO{}
+	dto.EscrowId, err = h.getEscrowId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	escrow, err := h.service.DisputeEscrow(r.Context(), &dto)
+	h.sendEscrowChange(w, escrow, err)
+}
+
+func (h *Handler) sendEscrowChange(w http.ResponseWriter, escrow domain.Escrow, err error) {
+	if err != nil {
+		if err == repository.ErrAccountFrozen || err == repository.ErrAccountClosed ||
+			err == repository.ErrRecipientInactive {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrUnknownEscrow {
+			h.sendError(w, http.StatusNotFound, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrInvalidEscrowTransition {
+			h.sendError(w, http.StatusConflict, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to change escrow: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusOK, escrow)
+}
+
+func (h *Handler) getEscrowId(ps httprouter.Params) (uint, error) {
+	escrowId, err := strconv.Atoi(ps.ByName("escrow_id"))
+	if err != nil || escrowId <= 0 {
+		return 0, ErrInvalidEscrowId
+	}
+	return uint(escrowId), nil
+}
@@ -0,0 +1,126 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	sseEventTransaction = "transaction"
+	sseEventBalance     = "balance"
+
+	sseHeartbeatInterval = 15 * time.Second
+	sseRetry             = 3 * time.Second
+)
+
+func (h *Handler) getEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getEvents handle request %v", r)
+	var err error
+	dto := domain.SubscribeUserEventsDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidUserId.Error()})
+		h.logger.Error(ErrInvalidUserId, " ", err)
+		return
+	}
+
+	// EventSource sends Last-Event-ID on reconnect only, so the first request may pass it as query
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("last_event_id")
+	}
+	if lastEventId != "" {
+		id, err := strconv.ParseUint(lastEventId, 10, 64)
+		if err != nil {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidLastEventId.Error()})
+			h.logger.Error(ErrInvalidLastEventId, " ", err)
+			return
+		}
+		dto.LastEventId = uint(id)
+	}
+
+	if err = dto.Validate(); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.logger.Error("Streaming is not supported")
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	events, err := h.service.SubscribeUserEvents(r.Context(), &dto)
+	if err != nil {
+		if err == repository.ErrUnknownUser {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to subscribe to user events: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	// stream lives longer than server WriteTimeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		h.logger.Warnf("Can't reset write deadline: %v", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := h.writeUserEvent(w, &event); err != nil {
+				h.logger.Debugf("getEvents: %v", err)
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-h.shutdown:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (h *Handler) writeUserEvent(w http.ResponseWriter, event *domain.UserEvent) error {
+	transaction, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	balance, err := json.Marshal(&struct {
+		Balance         string `json:"balance"`
+		ReservedBalance string `json:"reserved_balance"`
+	}{
+		Balance:         event.Balance.String(),
+		ReservedBalance: event.ReservedBalance.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\nid: %d\nevent: %s\ndata: %s\n\n",
+		event.Id, sseEventTransaction, transaction,
+		event.Id, sseEventBalance, balance)
+	return err
+}
@@ -0,0 +1,79 @@
+package v1
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"net/http"
+	"strconv"
+)
+
+func (h *Handler) setFeeSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("setFeeSchedule handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.SetFeeScheduleDTO{}
+	dto.ServiceId, err = h.getServiceId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	err = h.service.SetFeeSchedule(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to set fee schedule: %v", err)
+		if err == repository.ErrUnknownCurrency {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusNoContent, nil)
+}
+
+func (h *Handler) getFeeSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getFeeSchedule handle request %v", r)
+	var err error
+	dto := domain.GetFeeScheduleDTO{}
+	dto.ServiceId, err = h.getServiceId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	rules, err := h.service.GetFeeSchedule(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get fee schedule: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if rules == nil {
+		rules = []domain.FeeRule{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  rules,
+		Length: len(rules),
+	})
+}
+
+func (h *Handler) getServiceId(ps httprouter.Params) (uint, error) {
+	serviceId, err := strconv.Atoi(ps.ByName("service_id"))
+	if err != nil || serviceId <= 0 {
+		return 0, ErrInvalidServiceId
+	}
+	return uint(serviceId), nil
+}
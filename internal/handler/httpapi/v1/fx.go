@@ -0,0 +1,140 @@
+package v1
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"github.com/manimadzis/avito-job/internal/service"
+	"net/http"
+)
+
+func (h *Handler) loadExchangeRates(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("loadExchangeRates handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.LoadExchangeRatesDTO{}
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	err = h.service.LoadExchangeRates(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to load exchange rates: %v", err)
+		if err == repository.ErrUnknownCurrency {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusNoContent, nil)
+}
+
+func (h *Handler) getExchangeRates(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getExchangeRates handle request %v", r)
+	rates, err := h.service.GetExchangeRates(r.Context())
+	if err != nil {
+		h.logger.Errorf("Failed to get exchange rates: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if rates == nil {
+		rates = []domain.ExchangeRate{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  rates,
+		Length: len(rates),
+	})
+}
+
+func (h *Handler) createFXQuote(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("createFXQuote handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.CreateFXQuoteDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	quote, err := h.service.CreateFXQuote(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to create quote: %v", err)
+		if err == repository.ErrAccountFrozen || err == repository.ErrAccountClosed {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		if err == repository.ErrUnknownUser || err == repository.ErrUnknownRate {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusCreated, quote)
+}
+
+func (h *Handler) convertCurrency(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("convertCurrency handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.ConvertCurrencyDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	conversion, err := h.service.ConvertCurrency(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to convert currency: %v", err)
+		if err == repository.ErrAccountFrozen || err == repository.ErrAccountClosed {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrVelocityLimitExceeded) {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		switch err {
+		case repository.ErrUnknownUser, repository.ErrUnknownRate, repository.ErrUnknownQuote,
+			repository.ErrQuoteExpired, repository.ErrNotEnoughMoney, repository.ErrAmountTooSmall:
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusOK, &conversion)
+}
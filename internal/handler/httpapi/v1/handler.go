@@ -7,12 +7,16 @@ import (
 	"github.com/manimadzis/avito-job/pkg/logging"
 
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"io"
+	"net"
 	"net/http"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Handler struct {
@@ -20,6 +24,10 @@ type Handler struct {
 	service service.Service
 	logger  logging.Logger
 	config  *Config
+	// trustedProxies are parsed Config.TrustedProxies
+	trustedProxies []*net.IPNet
+	// shutdown is closed to stop long-lived streams
+	shutdown chan struct{}
 }
 
 type Collection struct {
@@ -27,30 +35,115 @@ type Collection struct {
 	Length int         `json:"length"`
 }
 
+type WalletResponse struct {
+	Currency     domain.Currency `json:"currency"`
+	Balance      string          `json:"balance"`
+	Reserved     string          `json:"reserved"`
+	CreditLimit  string          `json:"credit_limit"`
+	Available    string          `json:"available"`
+	PromoBalance string          `json:"promo_balance"`
+}
+
+type BalanceResponse struct {
+	// Balance is balance in Currency, RUB by default
+	Balance string `json:"balance"`
+	// Reserved is held by pending operations in Currency
+	Reserved string `json:"reserved"`
+	// Total is Balance with Reserved in Currency
+	Total string `json:"total"`
+	// Available is Balance with credit limit in Currency
+	Available    string               `json:"available"`
+	Currency     domain.Currency      `json:"currency"`
+	Wallets      []WalletResponse     `json:"wallets"`
+	Reservations []domain.Reservation `json:"reservations,omitempty"`
+}
+
 func NewHandler(config *Config, router *httprouter.Router, service service.Service, logger logging.Logger) *Handler {
 	h := &Handler{
-		router:  router,
-		service: service,
-		logger:  logger,
-		config:  config,
+		router:   router,
+		service:  service,
+		logger:   logger,
+		config:   config,
+		shutdown: make(chan struct{}),
 	}
+	h.parseTrustedProxies()
 	h.initRouter()
 	return h
 }
 
 func (h *Handler) initRouter() {
+	h.router.POST("/v1/user", h.createUser)
+	h.router.GET("/v1/user/:user_id", h.getUser)
 	h.router.POST("/v1/user/:user_id/reserve", h.reserveBalance)
+	h.router.POST("/v1/user/:user_id/reserve-order", h.reserveOrder)
 	h.router.POST("/v1/user/:user_id/cancel", h.cancelTransaction)
 	h.router.POST("/v1/user/:user_id/recognize", h.recognizeRevenue)
 	h.router.GET("/v1/user/:user_id/balance", h.getBalance)
 	h.router.POST("/v1/user/:user_id/balance", h.replenishBalance)
+	h.router.GET("/v1/user/:user_id/events", h.getEvents)
+	h.router.GET("/v1/user/:user_id/account", h.getAccount)
+	h.router.PUT("/v1/user/:user_id/account/status", h.setAccountStatus)
+	h.router.GET("/v1/user/:user_id/account/changes", h.getAccountStatusChanges)
+	h.router.PUT("/v1/user/:user_id/credit-limit", h.setCreditLimit)
+	h.router.GET("/v1/user/:user_id/credit-limit/changes", h.getCreditLimitChanges)
+	h.router.POST("/v1/user/:user_id/promo", h.grantPromo)
+	h.router.GET("/v1/user/:user_id/promo", h.getPromoGrants)
+	h.router.POST("/v1/user/:user_id/subscriptions", h.createSubscription)
+	h.router.GET("/v1/user/:user_id/subscriptions", h.getSubscriptions)
+	h.router.DELETE("/v1/user/:user_id/subscriptions/:subscription_id", h.cancelSubscription)
+	h.router.POST("/v1/user/:user_id/subscriptions/:subscription_id/resume", h.resumeSubscription)
+	h.router.GET("/v1/user/:user_id/escrows", h.getEscrows)
+	h.router.POST("/v1/user/:user_id/fx/quote", h.createFXQuote)
+	h.router.POST("/v1/user/:user_id/convert", h.convertCurrency)
 	h.router.GET("/v1/user/:user_id/history/:json", h.getHistory)
 	h.router.GET("/v1/report/:year/:month", h.getReport)
+	h.router.POST("/v1/batch", h.executeBatch)
+	h.router.POST("/v1/import/replenishments", h.importReplenishments)
+	h.router.POST("/v1/orders", h.createOrder)
+	h.router.GET("/v1/orders/:order_id", h.getOrder)
+	h.router.POST("/v1/orders/:order_id/recognize", h.recognizeOrder)
+	h.router.POST("/v1/orders/:order_id/cancel", h.cancelOrder)
+	h.router.POST("/v1/orders/:order_id/refund", h.refundOrder)
+	h.router.POST("/v1/orders/:order_id/lines/:service_id/recognize", h.recognizeOrder)
+	h.router.POST("/v1/orders/:order_id/lines/:service_id/cancel", h.cancelOrder)
+	h.router.POST("/v1/orders/:order_id/lines/:service_id/refund", h.refundOrder)
+	h.router.POST("/v1/escrows", h.createEscrow)
+	h.router.GET("/v1/escrows/:escrow_id", h.getEscrow)
+	h.router.POST("/v1/escrows/:escrow_id/release", h.releaseEscrow)
+	h.router.POST("/v1/escrows/:escrow_id/refund", h.refundEscrow)
+	h.router.POST("/v1/escrows/:escrow_id/dispute", h.disputeEscrow)
+	h.router.POST("/v1/services", h.createService)
+	h.router.GET("/v1/services", h.getServices)
+	h.router.GET("/v1/services/:service_id", h.getService)
+	h.router.PUT("/v1/services/:service_id", h.updateService)
+	h.router.DELETE("/v1/services/:service_id", h.deactivateService)
+	h.router.GET("/v1/services/:service_id/names", h.getServiceNames)
+	h.router.PUT("/v1/services/:service_id/fees", h.setFeeSchedule)
+	h.router.GET("/v1/services/:service_id/fees", h.getFeeSchedule)
+	h.router.POST("/v1/fx/rates", h.loadExchangeRates)
+	h.router.GET("/v1/fx/rates", h.getExchangeRates)
+	h.router.POST("/v1/velocity-rules", h.createVelocityRule)
+	h.router.GET("/v1/velocity-rules", h.getVelocityRules)
+	h.router.DELETE("/v1/velocity-rules/:rule_id", h.deleteVelocityRule)
+	h.router.GET("/v1/velocity-violations", h.getVelocityViolations)
+	h.router.POST("/v1/webhooks", h.createWebhook)
+	h.router.GET("/v1/webhooks", h.getWebhooks)
+	h.router.DELETE("/v1/webhooks/:webhook_id", h.deleteWebhook)
+	h.router.GET("/v1/audit", h.getAuditLog)
+	h.router.GET("/v1/audit/export", h.exportAuditLog)
+	h.router.GET("/v1/audit/verify", h.verifyAuditLog)
+	h.router.GET("/v1/transactions/chain/verify", h.verifyTransactionChain)
+	h.router.GET("/v1/transactions/chain/roots", h.getChainRoots)
 	h.router.ServeFiles("/files/*filepath", http.Dir(h.config.Directory))
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.router.ServeHTTP(w, r)
+	h.router.ServeHTTP(w, h.withAuditInfo(w, r))
+}
+
+// Shutdown closes event streams. http.Server.Shutdown doesn't wait for them otherwise
+func (h *Handler) Shutdown() {
+	close(h.shutdown)
 }
 
 func (h *Handler) reserveBalance(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -72,17 +165,37 @@ func (h *Handler) reserveBalance(w http.ResponseWriter, r *http.Request, ps http
 
 	err = h.service.ReserveMoney(r.Context(), &dto)
 	if err != nil {
+		if err == repository.ErrAccountFrozen || err == repository.ErrAccountClosed {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
+		}
 		if err == repository.ErrUnknownUser {
 			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
-			h.logger.Error("reserveBalance: %v", err)
+			h.logger.Errorf("reserveBalance: %v", err)
 			return
-		} else if err == repository.ErrNotEnoughMoney {
+		} else if err == repository.ErrNotEnoughMoney || err == repository.ErrCreditLimitExceeded {
 			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
 			return
 		} else if err == repository.ErrTransactionAlreadyExists {
 			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
 			return
+		} else if err == repository.ErrUnknownService || err == repository.ErrServiceInactive {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrOrderMismatch || err == repository.ErrInvalidOrderTransition {
+			h.sendError(w, http.StatusConflict, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrUnknownCurrency || err == repository.ErrCurrencyMismatch ||
+			errors.Is(err, domain.ErrMoneyOverflow) {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		} else if errors.Is(err, service.ErrVelocityLimitExceeded) {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
 		}
+		h.logger.Errorf("reserveBalance: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
 	}
 
 	h.sendResponse(w, http.StatusNoContent, nil)
@@ -99,13 +212,28 @@ func (h *Handler) getBalance(w http.ResponseWriter, r *http.Request, ps httprout
 		return
 	}
 
+	dto.Currency = domain.Currency(r.URL.Query().Get("currency"))
+
+	if at := r.URL.Query().Get("at"); at != "" {
+		h.getBalanceAt(w, r, dto, at)
+		return
+	}
+
+	if reservations := r.URL.Query().Get("reservations"); reservations != "" {
+		dto.Reservations, err = strconv.ParseBool(reservations)
+		if err != nil {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidReservations.Error()})
+			return
+		}
+	}
+
 	if err = dto.Validate(); err != nil {
-		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidUserId.Error()})
-		h.logger.Error(ErrInvalidUserId, " ", err)
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
 		return
 	}
 
-	money, err := h.service.GetBalance(r.Context(), &dto)
+	balance, err := h.service.GetBalance(r.Context(), &dto)
 	if err != nil {
 		h.logger.Errorf("Failed to get user: %v", err)
 		if err == repository.ErrUnknownUser {
@@ -116,11 +244,65 @@ func (h *Handler) getBalance(w http.ResponseWriter, r *http.Request, ps httprout
 		return
 	}
 
-	h.sendResponse(w, http.StatusOK, struct {
-		Balance string `json:"balance"`
-	}{
-		Balance: money.String(),
-	})
+	wallets, err := h.service.GetWallets(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get wallets: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	response := BalanceResponse{
+		Balance:   balance.Balance.String(),
+		Reserved:  balance.Reserved.String(),
+		Total:     balance.Total.String(),
+		Available: balance.Available.String(),
+		Currency:  balance.Currency,
+		Wallets:   make([]WalletResponse, 0, len(wallets)),
+		// omitted if not requested or there are none
+		Reservations: balance.Reservations,
+	}
+	for _, wallet := range wallets {
+		response.Wallets = append(response.Wallets, WalletResponse{
+			Currency:     wallet.Currency,
+			Balance:      wallet.Balance.String(),
+			Reserved:     wallet.Reserved.String(),
+			CreditLimit:  wallet.CreditLimit.String(),
+			Available:    wallet.Available.String(),
+			PromoBalance: wallet.PromoBalance.String(),
+		})
+	}
+	h.sendResponse(w, http.StatusOK, response)
+}
+
+// getBalanceAt responds with balances as of at instead of the current ones
+func (h *Handler) getBalanceAt(w http.ResponseWriter, r *http.Request, balanceDTO domain.GetBalanceDTO, at string) {
+	dto := domain.GetBalanceAtDTO{
+		UserId:   balanceDTO.UserId,
+		Currency: balanceDTO.Currency,
+	}
+	var err error
+	dto.At, err = time.Parse(time.RFC3339, at)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidAt.Error()})
+		return
+	}
+	if err = dto.Validate(); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	balance, err := h.service.GetBalanceAt(r.Context(), &dto)
+	if err != nil {
+		if err == repository.ErrUnknownUser {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to get balance at %v: %v", dto.At, err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	h.sendResponse(w, http.StatusOK, balance)
 }
 
 func (h *Handler) replenishBalance(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -147,7 +329,12 @@ func (h *Handler) replenishBalance(w http.ResponseWriter, r *http.Request, ps ht
 	err = h.service.ReplenishBalance(r.Context(), &dto)
 	if err != nil {
 		h.logger.Errorf("Failed replenish user balance: %v", err)
-		if err == repository.ErrUnknownUser {
+		if err == repository.ErrAccountFrozen || err == repository.ErrAccountClosed {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		if err == repository.ErrUnknownUser || err == repository.ErrUnknownCurrency ||
+			err == repository.ErrTransactionAlreadyExists {
 			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
 			return
 		}
@@ -262,12 +449,22 @@ func (h *Handler) recognizeRevenue(w http.ResponseWriter, r *http.Request, ps ht
 
 	err = h.service.RecognizeRevenue(r.Context(), &dto)
 	if err != nil {
-		if err == repository.ErrUnknownTransaction {
+		if err == repository.ErrAccountFrozen || err == repository.ErrAccountClosed {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		if err == repository.ErrUnknownTransaction || err == repository.ErrCurrencyMismatch {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		if err == repository.ErrUnknownRecipient || err == repository.ErrRecipientInactive ||
+			err == service.ErrSplitsExceedAmount || errors.Is(err, domain.ErrMoneyOverflow) {
 			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
 			return
 		}
 		h.logger.Error(err)
 		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
 	}
 	h.sendResponse(w, http.StatusNoContent, nil)
 }
@@ -295,7 +492,11 @@ func (h *Handler) cancelTransaction(w http.ResponseWriter, r *http.Request, ps h
 
 	err = h.service.CancelTransaction(r.Context(), &dto)
 	if err != nil {
-		if err == repository.ErrUnknownTransaction {
+		if err == repository.ErrAccountFrozen || err == repository.ErrAccountClosed {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		if err == repository.ErrUnknownTransaction || err == repository.ErrCurrencyMismatch {
 			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
 			return
 		}
@@ -317,7 +518,7 @@ func (h *Handler) sendResponse(w http.ResponseWriter, status int, data interface
 	if data != nil {
 		jsonData, err = json.Marshal(data)
 		if err != nil {
-			h.logger.Error("Failed to Marshal errorDTO: %v: data=%v", err, data)
+			h.logger.Errorf("Failed to Marshal errorDTO: %v: data=%v", err, data)
 		}
 		h.logger.Debugf("json: %s", string(jsonData))
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -355,9 +556,43 @@ func (h *Handler) getUserId(ps httprouter.Params) (uint, error) {
 	return 0, fmt.Errorf("no user_id")
 }
 
+// secretFields are json fields of request bodies which mustn't get into logs
+var secretFields = map[string]bool{"secret": true}
+
+// redactSecrets return body for logging with values of secretFields replaced. Body which isn't json is returned as is
+func redactSecrets(data []byte) string {
+	var body interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return string(data)
+	}
+	redacted, err := json.Marshal(redactValue(body))
+	if err != nil {
+		return string(data)
+	}
+	return string(redacted)
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if secretFields[strings.ToLower(key)] {
+				v[key] = "[REDACTED]"
+			} else {
+				v[key] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return value
+}
+
 func (h *Handler) handleBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
 	data, err := io.ReadAll(r.Body)
-	h.logger.Debugf("Body: %v", string(data))
+	h.logger.Debugf("Body: %v", redactSecrets(data))
 	if err != nil {
 		h.sendResponse(w, http.StatusInternalServerError, nil)
 		h.logger.Errorf("Failed to read body: %v", err)
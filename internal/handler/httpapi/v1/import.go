@@ -0,0 +1,89 @@
+package v1
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/service"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxImportFileSize = 32 << 20
+
+type ImportErrorResponse struct {
+	Msg    string               `json:"msg"`
+	Report *domain.ImportReport `json:"report,omitempty"`
+}
+
+// importReplenishments accepts CSV as request body or as "file" field of multipart form.
+// Query parameters: commit=true to replenish balances, comma to override CSV delimiter,
+// currency of all lines
+func (h *Handler) importReplenishments(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("importReplenishments handle request %v", r)
+	var err error
+	dto := domain.ImportReplenishmentsDTO{}
+
+	query := r.URL.Query()
+	if commit := query.Get("commit"); commit != "" {
+		dto.Commit, err = strconv.ParseBool(commit)
+		if err != nil {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidCommit.Error()})
+			return
+		}
+	}
+	dto.Currency = domain.Currency(query.Get("currency"))
+	if comma := query.Get("comma"); comma != "" {
+		if utf8.RuneCountInString(comma) != 1 {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidComma.Error()})
+			return
+		}
+		dto.Comma, _ = utf8.DecodeRuneInString(comma)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportFileSize)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrEmptyBody.Error()})
+			h.logger.Error(err)
+			return
+		}
+		defer file.Close()
+		dto.Data, err = io.ReadAll(io.LimitReader(file, maxImportFileSize))
+		if err != nil {
+			h.sendResponse(w, http.StatusInternalServerError, nil)
+			h.logger.Errorf("Failed to read file: %v", err)
+			return
+		}
+	} else {
+		dto.Data, err = h.handleBody(w, r)
+		if err != nil {
+			return
+		}
+	}
+
+	if err := dto.Validate(); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		return
+	}
+
+	report, err := h.service.ImportReplenishments(r.Context(), &dto)
+	if err != nil {
+		if errors.Is(err, service.ErrImportHasInvalidLines) {
+			h.sendResponse(w, http.StatusBadRequest, ImportErrorResponse{Msg: err.Error(), Report: &report})
+			return
+		} else if errors.Is(err, service.ErrInvalidImportFile) {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Import failed: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusOK, report)
+}
@@ -0,0 +1,210 @@
+package v1
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"github.com/manimadzis/avito-job/internal/service"
+	"net/http"
+	"strconv"
+)
+
+func (h *Handler) createOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("createOrder handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.CreateOrderDTO{}
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	order, err := h.service.CreateOrder(r.Context(), &dto)
+	if err != nil {
+		if err == repository.ErrAccountFrozen || err == repository.ErrAccountClosed {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrOrderAlreadyExists {
+			h.sendError(w, http.StatusConflict, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrUnknownUser || err == repository.ErrUnknownService ||
+			err == repository.ErrServiceInactive || err == repository.ErrUnknownCurrency {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to create order: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusCreated, order)
+}
+
+func (h *Handler) reserveOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("reserveOrder handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.ReserveOrderDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	order, err := h.service.ReserveOrder(r.Context(), &dto)
+	if err != nil {
+		if err == repository.ErrAccountFrozen || err == repository.ErrAccountClosed ||
+			errors.Is(err, service.ErrVelocityLimitExceeded) {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrOrderMismatch || err == repository.ErrInvalidOrderTransition {
+			h.sendError(w, http.StatusConflict, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrUnknownUser || err == repository.ErrNotEnoughMoney ||
+			err == repository.ErrCreditLimitExceeded || err == repository.ErrTransactionAlreadyExists ||
+			err == repository.ErrUnknownService || err == repository.ErrServiceInactive ||
+			err == repository.ErrUnknownCurrency {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to reserve order: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusOK, order)
+}
+
+func (h *Handler) getOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getOrder handle request %v", r)
+	var err error
+	dto := domain.GetOrderDTO{}
+	dto.OrderId, err = h.getOrderId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	order, err := h.service.GetOrder(r.Context(), &dto)
+	if err != nil {
+		if err == repository.ErrUnknownOrder {
+			h.sendError(w, http.StatusNotFound, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to get order: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusOK, order)
+}
+
+func (h *Handler) recognizeOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("recognizeOrder handle request %v", r)
+	dto, err := h.getChangeOrderDTO(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	order, err := h.service.RecognizeOrder(r.Context(), &dto)
+	h.sendOrderChange(w, order, err)
+}
+
+func (h *Handler) cancelOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("cancelOrder handle request %v", r)
+	dto, err := h.getChangeOrderDTO(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	order, err := h.service.CancelOrder(r.Context(), &dto)
+	h.sendOrderChange(w, order, err)
+}
+
+// refundOrder accepts optional body with description of refund
+func (h *Handler) refundOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("refundOrder handle request %v", r)
+	dto, err := h.getChangeOrderDTO(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	if r.ContentLength != 0 {
+		data, err := h.handleBody(w, r)
+		if err != nil {
+			return
+		}
+		if err := h.parseBytes(data, &dto); err != nil {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			h.logger.Error(err)
+			return
+		}
+	}
+
+	order, err := h.service.RefundOrder(r.Context(), &dto)
+	h.sendOrderChange(w, order, err)
+}
+
+func (h *Handler) sendOrderChange(w http.ResponseWriter, order domain.Order, err error) {
+	if err != nil {
+		if err == repository.ErrAccountFrozen || err == repository.ErrAccountClosed {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrUnknownOrder {
+			h.sendError(w, http.StatusNotFound, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrInvalidOrderTransition {
+			h.sendError(w, http.StatusConflict, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to change order: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusOK, order)
+}
+
+// getChangeOrderDTO refers to the line if path has service_id and to the whole order otherwise
+func (h *Handler) getChangeOrderDTO(ps httprouter.Params) (domain.ChangeOrderDTO, error) {
+	var err error
+	dto := domain.ChangeOrderDTO{}
+	dto.OrderId, err = h.getOrderId(ps)
+	if err != nil {
+		return dto, err
+	}
+	if ps.ByName("service_id") != "" {
+		dto.ServiceId, err = h.getServiceId(ps)
+	}
+	return dto, err
+}
+
+func (h *Handler) getOrderId(ps httprouter.Params) (uint, error) {
+	orderId, err := strconv.Atoi(ps.ByName("order_id"))
+	if err != nil || orderId <= 0 {
+		return 0, ErrInvalidOrderId
+	}
+	return uint(orderId), nil
+}
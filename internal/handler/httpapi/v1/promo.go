@@ -0,0 +1,74 @@
+package v1
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"net/http"
+)
+
+func (h *Handler) grantPromo(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("grantPromo handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.GrantPromoDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	grant, err := h.service.GrantPromo(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to grant promo: %v", err)
+		if err == repository.ErrAccountFrozen || err == repository.ErrAccountClosed {
+			h.sendError(w, http.StatusForbidden, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		if err == repository.ErrUnknownUser || err == repository.ErrUnknownCurrency {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusCreated, grant)
+}
+
+func (h *Handler) getPromoGrants(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getPromoGrants handle request %v", r)
+	var err error
+	dto := domain.GetPromoGrantsDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidUserId.Error()})
+		h.logger.Error(ErrInvalidUserId, " ", err)
+		return
+	}
+
+	grants, err := h.service.GetPromoGrants(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get promo grants: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if grants == nil {
+		grants = []domain.PromoGrant{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  grants,
+		Length: len(grants),
+	})
+}
@@ -0,0 +1,130 @@
+package v1
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"net/http"
+	"strconv"
+)
+
+func (h *Handler) createSubscription(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("createSubscription handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.CreateSubscriptionDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	subscription, err := h.service.CreateSubscription(r.Context(), &dto)
+	if err != nil {
+		if err == repository.ErrUnknownUser || err == repository.ErrUnknownService ||
+			err == repository.ErrUnknownCurrency {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to create subscription: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusCreated, subscription)
+}
+
+func (h *Handler) getSubscriptions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getSubscriptions handle request %v", r)
+	var err error
+	dto := domain.GetSubscriptionsDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidUserId.Error()})
+		h.logger.Error(ErrInvalidUserId, " ", err)
+		return
+	}
+
+	subscriptions, err := h.service.GetSubscriptions(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get subscriptions: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if subscriptions == nil {
+		subscriptions = []domain.Subscription{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  subscriptions,
+		Length: len(subscriptions),
+	})
+}
+
+func (h *Handler) cancelSubscription(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("cancelSubscription handle request %v", r)
+	dto, err := h.getSubscriptionDTO(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	subscription, err := h.service.CancelSubscription(r.Context(), &dto)
+	h.sendSubscriptionChange(w, subscription, err)
+}
+
+func (h *Handler) resumeSubscription(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("resumeSubscription handle request %v", r)
+	dto, err := h.getSubscriptionDTO(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	subscription, err := h.service.ResumeSubscription(r.Context(), &dto)
+	h.sendSubscriptionChange(w, subscription, err)
+}
+
+func (h *Handler) sendSubscriptionChange(w http.ResponseWriter, subscription domain.Subscription, err error) {
+	if err != nil {
+		if err == repository.ErrUnknownSubscription {
+			h.sendError(w, http.StatusNotFound, ErrorResponse{Msg: err.Error()})
+			return
+		} else if err == repository.ErrSubscriptionCanceled {
+			h.sendError(w, http.StatusConflict, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to change subscription: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusOK, subscription)
+}
+
+func (h *Handler) getSubscriptionDTO(ps httprouter.Params) (domain.SubscriptionDTO, error) {
+	var err error
+	dto := domain.SubscriptionDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		return dto, err
+	}
+	subscriptionId, err := strconv.Atoi(ps.ByName("subscription_id"))
+	if err != nil || subscriptionId <= 0 {
+		return dto, ErrInvalidSubscriptionId
+	}
+	dto.SubscriptionId = uint(subscriptionId)
+	return dto, nil
+}
@@ -0,0 +1,61 @@
+package v1
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"net/http"
+)
+
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("createUser handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.CreateUserDTO{}
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	user, err := h.service.CreateUser(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to create user: %v", err)
+		if err == repository.ErrUserAlreadyExists || err == repository.ErrExternalRefAlreadyUsed {
+			h.sendError(w, http.StatusConflict, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusCreated, user)
+}
+
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getUser handle request %v", r)
+	var err error
+	dto := domain.GetUserDTO{}
+	dto.UserId, err = h.getUserId(ps)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidUserId.Error()})
+		h.logger.Error(ErrInvalidUserId, " ", err)
+		return
+	}
+
+	user, err := h.service.GetUser(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get user: %v", err)
+		if err == repository.ErrUnknownUser {
+			h.sendError(w, http.StatusNotFound, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusOK, user)
+}
@@ -0,0 +1,136 @@
+package v1
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"net/http"
+	"strconv"
+)
+
+func (h *Handler) createVelocityRule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("createVelocityRule handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.CreateVelocityRuleDTO{}
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	rule, err := h.service.CreateVelocityRule(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to create velocity rule: %v", err)
+		if err == repository.ErrUnknownCurrency {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusCreated, &rule)
+}
+
+func (h *Handler) getVelocityRules(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getVelocityRules handle request %v", r)
+	dto := domain.GetVelocityRulesDTO{}
+	var err error
+	dto.UserId, err = h.getUserIdQuery(r)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	rules, err := h.service.GetVelocityRules(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get velocity rules: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if rules == nil {
+		rules = []domain.VelocityRule{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  rules,
+		Length: len(rules),
+	})
+}
+
+func (h *Handler) deleteVelocityRule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("deleteVelocityRule handle request %v", r)
+	dto := domain.DeleteVelocityRuleDTO{}
+	ruleId, err := strconv.Atoi(ps.ByName("rule_id"))
+	if err != nil || ruleId <= 0 {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidRuleId.Error()})
+		h.logger.Error(ErrInvalidRuleId, " ", err)
+		return
+	}
+	dto.RuleId = uint(ruleId)
+
+	err = h.service.DeleteVelocityRule(r.Context(), &dto)
+	if err != nil {
+		if err == repository.ErrUnknownVelocityRule {
+			h.sendError(w, http.StatusNotFound, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to delete velocity rule: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusNoContent, nil)
+}
+
+func (h *Handler) getVelocityViolations(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getVelocityViolations handle request %v", r)
+	dto := domain.GetVelocityViolationsDTO{}
+	var err error
+	dto.UserId, err = h.getUserIdQuery(r)
+	if err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		dto.Limit, err = strconv.Atoi(limit)
+		if err != nil || dto.Limit < 0 {
+			h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidLimit.Error()})
+			return
+		}
+	}
+
+	violations, err := h.service.GetVelocityViolations(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to get velocity violations: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if violations == nil {
+		violations = []domain.VelocityViolation{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  violations,
+		Length: len(violations),
+	})
+}
+
+// getUserIdQuery return optional user_id query parameter, 0 if it isn't set
+func (h *Handler) getUserIdQuery(r *http.Request) (uint, error) {
+	userId := r.URL.Query().Get("user_id")
+	if userId == "" {
+		return 0, nil
+	}
+	id, err := strconv.Atoi(userId)
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidUserId
+	}
+	return uint(id), nil
+}
@@ -0,0 +1,76 @@
+package v1
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"net/http"
+	"strconv"
+)
+
+func (h *Handler) createWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("createWebhook handle request %v", r)
+	data, err := h.handleBody(w, r)
+	if err != nil {
+		return
+	}
+
+	dto := domain.CreateWebhookDTO{}
+	if err := h.parseBytes(data, &dto); err != nil {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: err.Error()})
+		h.logger.Error(err)
+		return
+	}
+
+	webhook, err := h.service.CreateWebhook(r.Context(), &dto)
+	if err != nil {
+		h.logger.Errorf("Failed to create webhook: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusCreated, webhook)
+}
+
+func (h *Handler) getWebhooks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("getWebhooks handle request %v", r)
+	webhooks, err := h.service.GetWebhooks(r.Context())
+	if err != nil {
+		h.logger.Errorf("Failed to get webhooks: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if webhooks == nil {
+		webhooks = []domain.Webhook{}
+	}
+
+	h.sendResponse(w, http.StatusOK, Collection{
+		Items:  webhooks,
+		Length: len(webhooks),
+	})
+}
+
+func (h *Handler) deleteWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	h.logger.Tracef("deleteWebhook handle request %v", r)
+	dto := domain.DeleteWebhookDTO{}
+	webhookId, err := strconv.Atoi(ps.ByName("webhook_id"))
+	if err != nil || webhookId <= 0 {
+		h.sendError(w, http.StatusBadRequest, ErrorResponse{Msg: ErrInvalidWebhookId.Error()})
+		h.logger.Error(ErrInvalidWebhookId, " ", err)
+		return
+	}
+	dto.WebhookId = uint(webhookId)
+
+	err = h.service.DeleteWebhook(r.Context(), &dto)
+	if err != nil {
+		if err == repository.ErrUnknownWebhook {
+			h.sendError(w, http.StatusNotFound, ErrorResponse{Msg: err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to delete webhook: %v", err)
+		h.sendResponse(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	h.sendResponse(w, http.StatusNoContent, nil)
+}
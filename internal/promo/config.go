@@ -0,0 +1,7 @@
+package promo
+
+import "time"
+
+type Config struct {
+	SweepInterval time.Duration
+}
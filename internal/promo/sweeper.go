@@ -0,0 +1,50 @@
+package promo
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"github.com/manimadzis/avito-job/pkg/logging"
+	"time"
+)
+
+// Sweeper expires promo credits after their expiry date
+type Sweeper interface {
+	// Run blocks until ctx is done
+	Run(ctx context.Context)
+}
+
+type sweeper struct {
+	config *Config
+	repo   repository.Repository
+	logger logging.Logger
+}
+
+func NewSweeper(config *Config, repo repository.Repository, logger logging.Logger) Sweeper {
+	return &sweeper{
+		config: config,
+		repo:   repo,
+		logger: logger,
+	}
+}
+
+func (s *sweeper) Run(ctx context.Context) {
+	s.logger.Info("Starting promo sweeper...")
+	ticker := time.NewTicker(s.config.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Promo sweeper stopped")
+			return
+		case <-ticker.C:
+			swept, err := s.repo.ExpirePromoGrants(ctx)
+			if err != nil {
+				s.logger.Errorf("Can't expire promo grants: %v", err)
+				continue
+			}
+			if swept > 0 {
+				s.logger.Infof("Expired %d promo grants", swept)
+			}
+		}
+	}
+}
@@ -4,7 +4,37 @@ import "fmt"
 
 var (
 	ErrUnknownUser              = fmt.Errorf("unknown user")
+	ErrUserAlreadyExists        = fmt.Errorf("user already exists")
+	ErrExternalRefAlreadyUsed   = fmt.Errorf("external_ref is already used")
 	ErrNotEnoughMoney           = fmt.Errorf("not enough money")
+	ErrCreditLimitExceeded      = fmt.Errorf("credit limit exceeded")
 	ErrUnknownTransaction       = fmt.Errorf("unknown transaction")
 	ErrTransactionAlreadyExists = fmt.Errorf("transaction already exists")
+	ErrUnknownWebhook           = fmt.Errorf("unknown webhook")
+	ErrUnknownCurrency          = fmt.Errorf("unknown currency")
+	ErrCurrencyMismatch         = fmt.Errorf("currency doesn't match reservation")
+	ErrUnknownRate              = fmt.Errorf("unknown exchange rate")
+	ErrUnknownQuote             = fmt.Errorf("unknown quote")
+	ErrQuoteExpired             = fmt.Errorf("quote expired")
+	ErrAmountTooSmall           = fmt.Errorf("amount is too small to convert")
+	ErrUnknownVelocityRule      = fmt.Errorf("unknown velocity rule")
+	ErrUnknownService           = fmt.Errorf("unknown service")
+	ErrServiceAlreadyExists     = fmt.Errorf("service already exists")
+	ErrServiceInactive          = fmt.Errorf("service is inactive")
+	ErrAccountFrozen            = fmt.Errorf("account is frozen")
+	ErrAccountClosed            = fmt.Errorf("account is closed")
+	ErrAccountNotEmpty          = fmt.Errorf("account has balance or reservations")
+	ErrInvalidStatusTransition  = fmt.Errorf("closed account can't be reopened")
+	ErrUnknownOrder             = fmt.Errorf("unknown order")
+	ErrOrderAlreadyExists       = fmt.Errorf("order already exists")
+	ErrOrderMismatch            = fmt.Errorf("order has another user, service, amount or currency")
+	ErrInvalidOrderTransition   = fmt.Errorf("order status doesn't allow the operation")
+	ErrUnknownSubscription      = fmt.Errorf("unknown subscription")
+	ErrSubscriptionCanceled     = fmt.Errorf("subscription is canceled")
+	ErrSubscriptionNotDue       = fmt.Errorf("subscription isn't due")
+	ErrUnknownRecipient         = fmt.Errorf("unknown recipient")
+	ErrRecipientInactive        = fmt.Errorf("account of recipient is frozen or closed")
+	ErrUnknownEscrow            = fmt.Errorf("unknown escrow")
+	ErrInvalidEscrowTransition  = fmt.Errorf("escrow status doesn't allow the operation")
+	ErrEscrowNotExpired         = fmt.Errorf("escrow isn't held or isn't expired")
 )
@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+)
+
+// accountStatusError maps errors raised by check_account, nil if pqerr is another error
+func accountStatusError(pqerr *pq.Error) error {
+	switch pqerr.Message {
+	case "ACCOUNT_FROZEN":
+		return repository.ErrAccountFrozen
+	case "ACCOUNT_CLOSED":
+		return repository.ErrAccountClosed
+	}
+	return nil
+}
+
+func (r repo) SetAccountStatus(ctx context.Context, dto *domain.SetAccountStatusDTO) error {
+	r.logger.Tracef("SetAccountStatus(%v, %#v)", ctx, *dto)
+	// set_account_status writes audit entry, so it runs in transaction with audit info
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		_, err := r.conn(ctx).ExecContext(ctx, "CALL set_account_status($1, $2, $3, $4)",
+			dto.UserId,
+			dto.Status,
+			dto.Operator,
+			dto.Reason)
+		return err
+	})
+	if err != nil {
+		if pqerr, ok := err.(*pq.Error); ok {
+			if pqerr.Code.Name() == "no_data_found" {
+				return repository.ErrUnknownUser
+			} else if pqerr.Message == "INVALID_STATUS_TRANSITION" {
+				return repository.ErrInvalidStatusTransition
+			} else if pqerr.Message == "ACCOUNT_NOT_EMPTY" {
+				return repository.ErrAccountNotEmpty
+			}
+		}
+		r.logger.Errorf("SetAccountStatus error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r repo) GetAccount(ctx context.Context, dto *domain.GetAccountDTO) (domain.Account, error) {
+	r.logger.Tracef("GetAccount(%v, %#v)", ctx, *dto)
+	var account domain.Account
+	row := r.conn(ctx).QueryRowxContext(ctx, `SELECT id, status FROM "user" WHERE id = $1`, dto.UserId)
+	if err := row.StructScan(&account); err != nil {
+		if err == sql.ErrNoRows {
+			return domain.Account{}, repository.ErrUnknownUser
+		}
+		r.logger.Errorf("GetAccount error: %v", err)
+		return domain.Account{}, err
+	}
+	return account, nil
+}
+
+func (r repo) GetAccountStatusChanges(ctx context.Context, dto *domain.GetAccountDTO) ([]domain.AccountStatusChange, error) {
+	r.logger.Tracef("GetAccountStatusChanges(%v, %#v)", ctx, *dto)
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT id, user_id, old_status, new_status, COALESCE("operator", '') "operator", reason, created_at
+		FROM account_status_change WHERE user_id = $1 ORDER BY id`,
+		dto.UserId)
+	if err != nil {
+		r.logger.Errorf("GetAccountStatusChanges error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []domain.AccountStatusChange
+	for rows.Next() {
+		var change domain.AccountStatusChange
+		if err := rows.StructScan(&change); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,26 @@
+package postgres
+
+import (
+	"context"
+	"time"
+)
+
+func (r repo) ArchiveTransactions(ctx context.Context, before time.Time, limit int) (int, error) {
+	r.logger.Tracef("ArchiveTransactions(%v, %v, %d)", ctx, before, limit)
+	var archived int
+	err := r.conn(ctx).QueryRowxContext(ctx, "SELECT archive_transactions($1, $2)", before.UTC(), limit).Scan(&archived)
+	if err != nil {
+		r.logger.Errorf("ArchiveTransactions error: %v", err)
+	}
+	return archived, err
+}
+
+func (r repo) PruneUserEvents(ctx context.Context, before time.Time, limit int) (int, error) {
+	r.logger.Tracef("PruneUserEvents(%v, %v, %d)", ctx, before, limit)
+	var pruned int
+	err := r.conn(ctx).QueryRowxContext(ctx, "SELECT prune_user_events($1, $2)", before.UTC(), limit).Scan(&pruned)
+	if err != nil {
+		r.logger.Errorf("PruneUserEvents error: %v", err)
+	}
+	return pruned, err
+}
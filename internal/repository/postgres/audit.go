@@ -0,0 +1,105 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+// setAuditInfo passes audit info of ctx to audit_wallet trigger. Settings are local to tx
+func (r repo) setAuditInfo(ctx context.Context, tx sqlx.ExecerContext) error {
+	info, ok := domain.AuditInfoFrom(ctx)
+	if !ok {
+		return nil
+	}
+	var payloadHash string
+	if info.PayloadHash != nil {
+		payloadHash = info.PayloadHash()
+	}
+	_, err := tx.ExecContext(ctx,
+		`SELECT set_config('audit.actor', $1, TRUE), set_config('audit.client', $2, TRUE),
+		set_config('audit.request_id', $3, TRUE), set_config('audit.source_ip', $4, TRUE),
+		set_config('audit.operation', $5, TRUE), set_config('audit.payload_hash', $6, TRUE)`,
+		info.Actor,
+		info.Client,
+		info.RequestId,
+		info.SourceIP,
+		info.Operation,
+		payloadHash)
+	return err
+}
+
+// deletedEntity is details of audit entry for removed entity
+type deletedEntity struct {
+	Deleted bool `json:"deleted"`
+}
+
+// auditChange records new state of entity in audit log. It must be called in transaction of the change
+func (r repo) auditChange(ctx context.Context, entity string, entityId interface{}, userId uint, currency domain.Currency, details interface{}) error {
+	payload, err := json.Marshal(details)
+	if err != nil {
+		r.logger.Errorf("auditChange marshal error: %v", err)
+		return err
+	}
+	_, err = r.conn(ctx).ExecContext(ctx, "CALL audit_change($1, $2, $3, $4, $5)",
+		entity,
+		fmt.Sprint(entityId),
+		userId,
+		currency,
+		string(payload))
+	if err != nil {
+		r.logger.Errorf("auditChange error: %v", err)
+	}
+	return err
+}
+
+func (r repo) GetAuditLog(ctx context.Context, dto *domain.GetAuditLogDTO) ([]domain.AuditEntry, error) {
+	r.logger.Tracef("GetAuditLog(%v, %#v)", ctx, *dto)
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT id, created_at, actor, client, request_id, source_ip, operation, entity, entity_id, user_id, currency,
+		balance_before, balance_after, reserved_before, reserved_after, details, payload_hash, prev_hash, hash
+		FROM audit_log
+		WHERE ($1 = 0 OR user_id = $1)
+			AND ($2 = '' OR request_id = $2)
+			AND ($3::timestamp IS NULL OR created_at >= $3)
+			AND ($4::timestamp IS NULL OR created_at < $4)
+			AND id > $5
+		ORDER BY id
+		LIMIT NULLIF($6, 0)`,
+		dto.UserId,
+		dto.RequestId,
+		nullTime(dto.From),
+		nullTime(dto.To),
+		dto.AfterId,
+		dto.Limit)
+	if err != nil {
+		r.logger.Errorf("GetAuditLog error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []domain.AuditEntry
+	for rows.Next() {
+		var entry domain.AuditEntry
+		if err := rows.StructScan(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (r repo) VerifyAuditLog(ctx context.Context) (domain.AuditVerification, error) {
+	r.logger.Tracef("VerifyAuditLog(%v)", ctx)
+	var verification domain.AuditVerification
+	err := r.conn(ctx).QueryRowxContext(ctx,
+		`SELECT checked, COALESCE(broken_id, 0) broken_id, COALESCE(last_id, 0) last_id, last_hash
+		FROM verify_audit_log()`).StructScan(&verification)
+	if err != nil {
+		r.logger.Errorf("VerifyAuditLog error: %v", err)
+		return domain.AuditVerification{}, err
+	}
+	return verification, nil
+}
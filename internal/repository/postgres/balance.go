@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"context"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"time"
+)
+
+func (r repo) GetBalanceAt(ctx context.Context, dto *domain.GetBalanceAtDTO) (domain.BalanceAt, error) {
+	r.logger.Tracef("GetBalanceAt(%v, %#v)", ctx, *dto)
+	var balance domain.BalanceAt
+	err := r.conn(ctx).QueryRowxContext(ctx,
+		"SELECT balance, reserved_balance, credit_limit FROM get_balance_at($1, $2, $3)",
+		dto.UserId,
+		dto.Currency,
+		dto.At.UTC()).StructScan(&balance)
+	if err != nil {
+		if pqerr, ok := err.(*pq.Error); ok && pqerr.Code.Name() == "no_data_found" {
+			return domain.BalanceAt{}, repository.ErrUnknownUser
+		}
+		r.logger.Errorf("GetBalanceAt error: %v", err)
+		return domain.BalanceAt{}, err
+	}
+	return balance, nil
+}
+
+func (r repo) TakeBalanceSnapshots(ctx context.Context, at time.Time) (int, error) {
+	r.logger.Tracef("TakeBalanceSnapshots(%v, %v)", ctx, at)
+	var taken int
+	err := r.conn(ctx).QueryRowxContext(ctx, "SELECT take_balance_snapshots($1)", at.UTC()).Scan(&taken)
+	if err != nil {
+		r.logger.Errorf("TakeBalanceSnapshots error: %v", err)
+	}
+	return taken, err
+}
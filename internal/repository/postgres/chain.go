@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"time"
+)
+
+func (r repo) VerifyTransactionChain(ctx context.Context) (domain.TransactionChainVerification, error) {
+	r.logger.Tracef("VerifyTransactionChain(%v)", ctx)
+	var verification domain.TransactionChainVerification
+	err := r.conn(ctx).QueryRowxContext(ctx,
+		`SELECT checked, COALESCE(broken_seq, 0) broken_seq, COALESCE(broken_id, 0) broken_id, unchained,
+		last_seq, last_hash
+		FROM verify_transaction_chain()`).StructScan(&verification)
+	if err != nil {
+		r.logger.Errorf("VerifyTransactionChain error: %v", err)
+		return domain.TransactionChainVerification{}, err
+	}
+	return verification, nil
+}
+
+func (r repo) GetChainRoots(ctx context.Context, dto *domain.GetChainRootsDTO) ([]domain.ChainRoot, error) {
+	r.logger.Tracef("GetChainRoots(%v, %#v)", ctx, *dto)
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT "day", "count", last_seq, root_hash FROM get_transaction_chain_roots($1::date, $2::date)`,
+		nullDate(dto.From),
+		nullDate(dto.To))
+	if err != nil {
+		r.logger.Errorf("GetChainRoots error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roots []domain.ChainRoot
+	for rows.Next() {
+		var root domain.ChainRoot
+		if err := rows.StructScan(&root); err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	return roots, rows.Err()
+}
+
+// nullDate passes day of t as is, so it isn't shifted by time zone of the session
+func nullDate(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+)
+
+func (r repo) SetCreditLimit(ctx context.Context, dto *domain.SetCreditLimitDTO) error {
+	r.logger.Tracef("SetCreditLimit(%v, %#v)", ctx, *dto)
+	// set_credit_limit writes audit entry, so it runs in transaction with audit info
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		_, err := r.conn(ctx).ExecContext(ctx, "CALL set_credit_limit($1, $2, $3, $4, $5)",
+			dto.UserId,
+			dto.Currency,
+			dto.Limit,
+			dto.Operator,
+			dto.Reason)
+		return err
+	})
+	if err != nil {
+		if pqerr, ok := err.(*pq.Error); ok {
+			if pqerr.Code.Name() == "no_data_found" {
+				return repository.ErrUnknownUser
+			} else if pqerr.Code.Name() == "foreign_key_violation" {
+				return repository.ErrUnknownCurrency
+			}
+		}
+		r.logger.Errorf("SetCreditLimit error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r repo) GetCreditLimitChanges(ctx context.Context, dto *domain.GetCreditLimitChangesDTO) ([]domain.CreditLimitChange, error) {
+	r.logger.Tracef("GetCreditLimitChanges(%v, %#v)", ctx, *dto)
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT id, user_id, currency, old_limit, new_limit, COALESCE("operator", '') "operator", reason, created_at
+		FROM credit_limit_change WHERE user_id = $1 ORDER BY id`,
+		dto.UserId)
+	if err != nil {
+		r.logger.Errorf("GetCreditLimitChanges error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []domain.CreditLimitChange
+	for rows.Next() {
+		var change domain.CreditLimitChange
+		if err := rows.StructScan(&change); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,198 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+)
+
+const escrowColumns = `id, buyer_id, seller_id, amount, currency, "description", status, timeout_policy, expires_at,
+	COALESCE(dispute_reason, '') dispute_reason, created_at, updated_at`
+
+// escrowError maps exceptions of escrow procedures
+func escrowError(err error) error {
+	pqerr, ok := err.(*pq.Error)
+	if !ok {
+		return nil
+	}
+	if err := accountStatusError(pqerr); err != nil {
+		return err
+	}
+	switch pqerr.Message {
+	case "UNKNOWN_USER":
+		return repository.ErrUnknownUser
+	case "UNKNOWN_RECIPIENT":
+		return repository.ErrUnknownRecipient
+	case "RECIPIENT_INACTIVE":
+		return repository.ErrRecipientInactive
+	case "NOT_ENOUGH_MONEY":
+		return repository.ErrNotEnoughMoney
+	case "CREDIT_LIMIT_EXCEEDED":
+		return repository.ErrCreditLimitExceeded
+	case "UNKNOWN_ESCROW":
+		return repository.ErrUnknownEscrow
+	case "INVALID_ESCROW_TRANSITION":
+		return repository.ErrInvalidEscrowTransition
+	case "ESCROW_NOT_EXPIRED":
+		return repository.ErrEscrowNotExpired
+	}
+	if pqerr.Code.Name() == "foreign_key_violation" {
+		return repository.ErrUnknownCurrency
+	}
+	return nil
+}
+
+func (r repo) CreateEscrow(ctx context.Context, dto *domain.CreateEscrowDTO) (domain.Escrow, error) {
+	r.logger.Tracef("CreateEscrow(%v, %#v)", ctx, *dto)
+	var escrow domain.Escrow
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		var escrowId uint
+		err := tx.QueryRowxContext(ctx, "SELECT create_escrow($1, $2, $3, $4, $5, $6, $7)",
+			dto.BuyerId,
+			dto.SellerId,
+			dto.Amount,
+			dto.Currency,
+			dto.Description,
+			dto.TimeoutPolicy,
+			dto.ExpiresAt.UTC()).Scan(&escrowId)
+		if err != nil {
+			if err := escrowError(err); err != nil {
+				return err
+			}
+			r.logger.Errorf("CreateEscrow error: %v", err)
+			return err
+		}
+		if escrow, err = r.getEscrow(ctx, escrowId); err != nil {
+			return err
+		}
+
+		return r.enqueueEvent(ctx, tx, &domain.Event{
+			Type:     domain.EventEscrowed,
+			UserId:   escrow.BuyerId,
+			Amount:   escrow.Amount,
+			Currency: escrow.Currency,
+			EscrowId: escrow.Id,
+		})
+	})
+	return escrow, err
+}
+
+func (r repo) GetEscrow(ctx context.Context, dto *domain.GetEscrowDTO) (domain.Escrow, error) {
+	r.logger.Tracef("GetEscrow(%v, %#v)", ctx, *dto)
+	return r.getEscrow(ctx, dto.EscrowId)
+}
+
+func (r repo) getEscrow(ctx context.Context, escrowId uint) (domain.Escrow, error) {
+	var escrow domain.Escrow
+	err := r.conn(ctx).QueryRowxContext(ctx, `SELECT `+escrowColumns+` FROM escrow WHERE id = $1`,
+		escrowId).StructScan(&escrow)
+	if err == sql.ErrNoRows {
+		return domain.Escrow{}, repository.ErrUnknownEscrow
+	} else if err != nil {
+		r.logger.Errorf("getEscrow error: %v", err)
+		return domain.Escrow{}, err
+	}
+	return escrow, nil
+}
+
+func (r repo) GetEscrows(ctx context.Context, dto *domain.GetEscrowsDTO) ([]domain.Escrow, error) {
+	r.logger.Tracef("GetEscrows(%v, %#v)", ctx, *dto)
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT `+escrowColumns+` FROM escrow WHERE buyer_id = $1 OR seller_id = $1 ORDER BY id`,
+		dto.UserId)
+	if err != nil {
+		r.logger.Errorf("GetEscrows error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var escrows []domain.Escrow
+	for rows.Next() {
+		var escrow domain.Escrow
+		if err := rows.StructScan(&escrow); err != nil {
+			return nil, err
+		}
+		escrows = append(escrows, escrow)
+	}
+	return escrows, rows.Err()
+}
+
+func (r repo) ReleaseEscrow(ctx context.Context, dto *domain.GetEscrowDTO) (domain.Escrow, error) {
+	r.logger.Tracef("ReleaseEscrow(%v, %#v)", ctx, *dto)
+	return r.settleEscrow(ctx, dto.EscrowId, "CALL release_escrow($1)")
+}
+
+func (r repo) RefundEscrow(ctx context.Context, dto *domain.GetEscrowDTO) (domain.Escrow, error) {
+	r.logger.Tracef("RefundEscrow(%v, %#v)", ctx, *dto)
+	return r.settleEscrow(ctx, dto.EscrowId, "CALL refund_escrow($1)")
+}
+
+func (r repo) ExpireEscrow(ctx context.Context, escrowId uint) (domain.Escrow, error) {
+	r.logger.Tracef("ExpireEscrow(%v, %d)", ctx, escrowId)
+	return r.settleEscrow(ctx, escrowId, "CALL expire_escrow($1)")
+}
+
+// settleEscrow calls procedure which releases or refunds escrow and sends event to the party who gets money
+func (r repo) settleEscrow(ctx context.Context, escrowId uint, query string) (domain.Escrow, error) {
+	var escrow domain.Escrow
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		if _, err := tx.ExecContext(ctx, query, escrowId); err != nil {
+			if err := escrowError(err); err != nil {
+				return err
+			}
+			r.logger.Errorf("settleEscrow error: %v", err)
+			return err
+		}
+		var err error
+		if escrow, err = r.getEscrow(ctx, escrowId); err != nil {
+			return err
+		}
+
+		event := domain.Event{
+			Type:     domain.EventReleased,
+			UserId:   escrow.SellerId,
+			Amount:   escrow.Amount,
+			Currency: escrow.Currency,
+			EscrowId: escrow.Id,
+		}
+		if escrow.Status == domain.EscrowStatusRefunded {
+			event.Type = domain.EventRefunded
+			event.UserId = escrow.BuyerId
+		}
+		return r.enqueueEvent(ctx, tx, &event)
+	})
+	return escrow, err
+}
+
+func (r repo) DisputeEscrow(ctx context.Context, dto *domain.DisputeEscrowDTO) (domain.Escrow, error) {
+	r.logger.Tracef("DisputeEscrow(%v, %#v)", ctx, *dto)
+	var escrow domain.Escrow
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		_, err := r.conn(ctx).ExecContext(ctx, "CALL dispute_escrow($1, $2)", dto.EscrowId, dto.Reason)
+		if err != nil {
+			if err := escrowError(err); err != nil {
+				return err
+			}
+			r.logger.Errorf("DisputeEscrow error: %v", err)
+			return err
+		}
+		escrow, err = r.getEscrow(ctx, dto.EscrowId)
+		return err
+	})
+	return escrow, err
+}
+
+func (r repo) GetExpiredEscrows(ctx context.Context, limit int) ([]uint, error) {
+	r.logger.Tracef("GetExpiredEscrows(%v, %d)", ctx, limit)
+	var ids []uint
+	err := r.db.SelectContext(ctx, &ids,
+		`SELECT id FROM escrow WHERE status = 'HELD' AND expires_at <= CURRENT_TIMESTAMP ORDER BY expires_at LIMIT $1`,
+		limit)
+	if err != nil {
+		r.logger.Errorf("GetExpiredEscrows error: %v", err)
+	}
+	return ids, err
+}
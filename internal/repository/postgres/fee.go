@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"context"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+)
+
+func (r repo) SetFeeSchedule(ctx context.Context, dto *domain.SetFeeScheduleDTO) error {
+	r.logger.Tracef("SetFeeSchedule(%v, %d, %d rules)", ctx, dto.ServiceId, len(dto.Rules))
+	return r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		_, err := tx.ExecContext(ctx, "DELETE FROM fee_rule WHERE service_id = $1", dto.ServiceId)
+		if err != nil {
+			r.logger.Errorf("SetFeeSchedule error: %v", err)
+			return err
+		}
+		for _, rule := range dto.Rules {
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO fee_rule (service_id, currency, min_amount, fixed, basis_points, min_fee, max_fee)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				dto.ServiceId,
+				rule.Currency,
+				rule.MinAmount,
+				rule.Fixed,
+				rule.BasisPoints,
+				rule.MinFee,
+				rule.MaxFee)
+			if err != nil {
+				if pqerr, ok := err.(*pq.Error); ok {
+					if pqerr.Code.Name() == "foreign_key_violation" {
+						return repository.ErrUnknownCurrency
+					}
+				}
+				r.logger.Errorf("SetFeeSchedule error: %v", err)
+				return err
+			}
+		}
+		return r.auditChange(ctx, "fee_schedule", dto.ServiceId, 0, "", dto)
+	})
+}
+
+func (r repo) GetFeeSchedule(ctx context.Context, dto *domain.GetFeeScheduleDTO) ([]domain.FeeRule, error) {
+	r.logger.Tracef("GetFeeSchedule(%v, %#v)", ctx, *dto)
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT id, service_id, currency, min_amount, fixed, basis_points, min_fee, max_fee
+		FROM fee_rule WHERE service_id = $1 ORDER BY currency, min_amount`,
+		dto.ServiceId)
+	if err != nil {
+		r.logger.Errorf("GetFeeSchedule error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []domain.FeeRule
+	for rows.Next() {
+		var rule domain.FeeRule
+		if err := rows.StructScan(&rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
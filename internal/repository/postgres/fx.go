@@ -0,0 +1,151 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"time"
+)
+
+func (r repo) LoadExchangeRates(ctx context.Context, rates []domain.ExchangeRate) error {
+	r.logger.Tracef("LoadExchangeRates(%v, %d rates)", ctx, len(rates))
+	return r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		for _, rate := range rates {
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO exchange_rate (base, quote, rate, valid_from, valid_to) VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (base, quote, valid_from) DO UPDATE SET rate = EXCLUDED.rate, valid_to = EXCLUDED.valid_to`,
+				rate.Base,
+				rate.Quote,
+				rate.Rate,
+				rate.ValidFrom.UTC(),
+				nullTime(rate.ValidTo))
+			if err != nil {
+				if pqerr, ok := err.(*pq.Error); ok {
+					if pqerr.Code.Name() == "foreign_key_violation" {
+						return repository.ErrUnknownCurrency
+					}
+				}
+				r.logger.Errorf("LoadExchangeRates error: %v", err)
+				return err
+			}
+			err = r.auditChange(ctx, "exchange_rate", fmt.Sprintf("%s/%s", rate.Base, rate.Quote), 0, rate.Base, rate)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r repo) GetExchangeRates(ctx context.Context, at time.Time) ([]domain.ExchangeRate, error) {
+	r.logger.Tracef("GetExchangeRates(%v, %v)", ctx, at)
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT DISTINCT ON (base, quote) id, base, quote, rate, valid_from, valid_to
+		FROM exchange_rate
+		WHERE valid_from <= $1 AND (valid_to IS NULL OR valid_to > $1)
+		ORDER BY base, quote, valid_from DESC, id DESC`,
+		at.UTC())
+	if err != nil {
+		r.logger.Errorf("GetExchangeRates error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []domain.ExchangeRate
+	for rows.Next() {
+		var rate domain.ExchangeRate
+		if err := rows.StructScan(&rate); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
+
+func (r repo) CreateFXQuote(ctx context.Context, dto *domain.CreateFXQuoteDTO, ttl time.Duration) (domain.FXQuote, error) {
+	r.logger.Tracef("CreateFXQuote(%v, %#v, %v)", ctx, *dto, ttl)
+	quote := domain.FXQuote{
+		UserId: dto.UserId,
+		From:   dto.From,
+		To:     dto.To,
+	}
+	row := r.conn(ctx).QueryRowxContext(ctx, "SELECT * FROM create_fx_quote($1, $2, $3, $4)",
+		dto.UserId,
+		dto.From,
+		dto.To,
+		int(ttl.Seconds()))
+	if err := row.StructScan(&quote); err != nil {
+		if pqerr, ok := err.(*pq.Error); ok {
+			if err := accountStatusError(pqerr); err != nil {
+				return domain.FXQuote{}, err
+			}
+			if pqerr.Code.Name() == "no_data_found" {
+				return domain.FXQuote{}, repository.ErrUnknownUser
+			} else if pqerr.Message == "UNKNOWN_RATE" {
+				return domain.FXQuote{}, repository.ErrUnknownRate
+			}
+		}
+		r.logger.Errorf("CreateFXQuote error: %v", err)
+		return domain.FXQuote{}, err
+	}
+	return quote, nil
+}
+
+func (r repo) ConvertCurrency(ctx context.Context, dto *domain.ConvertCurrencyDTO) (domain.Conversion, error) {
+	r.logger.Tracef("ConvertCurrency(%v, %#v)", ctx, *dto)
+	conversion := domain.Conversion{
+		From:       dto.From,
+		To:         dto.To,
+		FromAmount: dto.Amount,
+	}
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		row := tx.QueryRowxContext(ctx, "SELECT * FROM convert_currency($1, $2, $3, $4, $5)",
+			dto.UserId,
+			dto.Amount.String(),
+			dto.From,
+			dto.To,
+			sql.NullInt64{Int64: int64(dto.QuoteId), Valid: dto.QuoteId != 0})
+		if err := row.StructScan(&conversion); err != nil {
+			if pqerr, ok := err.(*pq.Error); ok {
+				if err := accountStatusError(pqerr); err != nil {
+					return err
+				}
+				if pqerr.Code.Name() == "no_data_found" {
+					return repository.ErrUnknownUser
+				}
+				switch pqerr.Message {
+				case "UNKNOWN_RATE":
+					return repository.ErrUnknownRate
+				case "UNKNOWN_QUOTE":
+					return repository.ErrUnknownQuote
+				case "QUOTE_EXPIRED":
+					return repository.ErrQuoteExpired
+				case "NOT_ENOUGH_MONEY":
+					return repository.ErrNotEnoughMoney
+				case "AMOUNT_TOO_SMALL":
+					return repository.ErrAmountTooSmall
+				}
+			}
+			r.logger.Errorf("ConvertCurrency error: %v", err)
+			return err
+		}
+
+		return r.enqueueEvent(ctx, tx, &domain.Event{
+			Type:       domain.EventConverted,
+			UserId:     dto.UserId,
+			Amount:     dto.Amount,
+			Currency:   dto.From,
+			ToAmount:   conversion.ToAmount,
+			ToCurrency: dto.To,
+		})
+	})
+	if err != nil {
+		return domain.Conversion{}, err
+	}
+	return conversion, nil
+}
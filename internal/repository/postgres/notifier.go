@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"github.com/manimadzis/avito-job/pkg/logging"
+	"sync"
+	"time"
+)
+
+const (
+	UserEventsChannel = "user_events"
+
+	subscriberBufferSize = 64
+
+	listenMinBackoff = time.Second
+	listenMaxBackoff = time.Minute
+)
+
+type notifier struct {
+	listener    *pq.Listener
+	logger      logging.Logger
+	mu          sync.Mutex
+	subscribers map[uint]map[chan domain.UserEvent]struct{}
+}
+
+func (n *notifier) Run(ctx context.Context) error {
+	defer n.listener.Close()
+	if err := n.listen(ctx); err != nil {
+		n.closeAll()
+		return nil
+	}
+
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			n.closeAll()
+			return nil
+		case notification := <-n.listener.Notify:
+			// nil notification is sent after reconnect, notifications could be lost meanwhile
+			if notification == nil {
+				n.logger.Warn("Notifier reconnected, dropping subscribers")
+				n.closeAll()
+				continue
+			}
+			n.publish(notification)
+		case <-ping.C:
+			go n.listener.Ping()
+		}
+	}
+}
+
+// listen retries with backoff until channel is listened or ctx is done
+func (n *notifier) listen(ctx context.Context) error {
+	backoff := listenMinBackoff
+	for {
+		err := n.listener.Listen(UserEventsChannel)
+		if err == nil || err == pq.ErrChannelAlreadyOpen {
+			return nil
+		}
+		n.logger.Errorf("Can't listen %s, retry in %v: %v", UserEventsChannel, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > listenMaxBackoff {
+			backoff = listenMaxBackoff
+		}
+	}
+}
+
+func (n *notifier) publish(notification *pq.Notification) {
+	var event domain.UserEvent
+	if err := json.Unmarshal([]byte(notification.Extra), &event); err != nil {
+		n.logger.Errorf("Can't parse notification %s: %v", notification.Extra, err)
+		return
+	}
+	n.logger.Tracef("Notification: %#v", event)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for ch := range n.subscribers[event.UserId] {
+		select {
+		case ch <- event:
+		default:
+			n.logger.Warnf("Subscriber of user %d falls behind, dropping it", event.UserId)
+			n.remove(event.UserId, ch)
+		}
+	}
+}
+
+func (n *notifier) Subscribe(userId uint) (<-chan domain.UserEvent, func()) {
+	ch := make(chan domain.UserEvent, subscriberBufferSize)
+	n.mu.Lock()
+	if n.subscribers[userId] == nil {
+		n.subscribers[userId] = make(map[chan domain.UserEvent]struct{})
+	}
+	n.subscribers[userId][ch] = struct{}{}
+	n.mu.Unlock()
+
+	return ch, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.remove(userId, ch)
+	}
+}
+
+// remove must be called with mu held
+func (n *notifier) remove(userId uint, ch chan domain.UserEvent) {
+	if _, ok := n.subscribers[userId][ch]; !ok {
+		return
+	}
+	delete(n.subscribers[userId], ch)
+	if len(n.subscribers[userId]) == 0 {
+		delete(n.subscribers, userId)
+	}
+	close(ch)
+}
+
+func (n *notifier) closeAll() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for userId, chs := range n.subscribers {
+		for ch := range chs {
+			n.remove(userId, ch)
+		}
+	}
+}
+
+func NewNotifier(listener *pq.Listener, logger logging.Logger) repository.Notifier {
+	return &notifier{
+		listener:    listener,
+		logger:      logger,
+		subscribers: make(map[uint]map[chan domain.UserEvent]struct{}),
+	}
+}
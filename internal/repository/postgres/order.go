@@ -0,0 +1,239 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+)
+
+const orderColumns = `id, user_id, amount, currency, status, created_at, updated_at`
+
+// orderError maps errors raised by order procedures, nil if pqerr is another error
+func orderError(pqerr *pq.Error) error {
+	switch pqerr.Message {
+	case "UNKNOWN_ORDER":
+		return repository.ErrUnknownOrder
+	case "ORDER_MISMATCH":
+		return repository.ErrOrderMismatch
+	case "INVALID_ORDER_TRANSITION":
+		return repository.ErrInvalidOrderTransition
+	}
+	return nil
+}
+
+func (r repo) CreateOrder(ctx context.Context, dto *domain.CreateOrderDTO) (domain.Order, error) {
+	r.logger.Tracef("CreateOrder(%v, %#v)", ctx, *dto)
+	var order domain.Order
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		serviceIds := make([]int64, 0, len(dto.Lines))
+		amounts := make([]string, 0, len(dto.Lines))
+		for _, line := range dto.Lines {
+			serviceIds = append(serviceIds, int64(line.ServiceId))
+			amounts = append(amounts, line.Amount.String())
+		}
+		_, err := r.conn(ctx).ExecContext(ctx, "CALL create_order($1, $2, $3, $4, $5)",
+			dto.OrderId,
+			dto.UserId,
+			dto.Currency,
+			pq.Array(serviceIds),
+			pq.Array(amounts))
+		if err != nil {
+			if pqerr, ok := err.(*pq.Error); ok {
+				if err := accountStatusError(pqerr); err != nil {
+					return err
+				}
+				if pqerr.Code.Name() == "no_data_found" {
+					return repository.ErrUnknownUser
+				} else if pqerr.Message == "UNKNOWN_SERVICE" {
+					return repository.ErrUnknownService
+				} else if pqerr.Message == "SERVICE_INACTIVE" {
+					return repository.ErrServiceInactive
+				} else if pqerr.Code.Name() == "unique_violation" {
+					return repository.ErrOrderAlreadyExists
+				} else if pqerr.Code.Name() == "foreign_key_violation" {
+					return repository.ErrUnknownCurrency
+				}
+			}
+			r.logger.Errorf("CreateOrder error: %v", err)
+			return err
+		}
+		order, err = r.GetOrder(ctx, &domain.GetOrderDTO{OrderId: dto.OrderId})
+		return err
+	})
+	if err != nil {
+		return domain.Order{}, err
+	}
+	return order, nil
+}
+
+func (r repo) ReserveOrder(ctx context.Context, lines []domain.ReserveMoneyDTO) (domain.Order, error) {
+	r.logger.Tracef("ReserveOrder(%v, %d lines)", ctx, len(lines))
+	var order domain.Order
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		first := lines[0]
+		_, err := r.GetOrder(ctx, &domain.GetOrderDTO{OrderId: first.OrderId})
+		if err == repository.ErrUnknownOrder {
+			// services must be in catalog before the order refers to them
+			for _, line := range lines {
+				if err := r.checkService(ctx, &line); err != nil {
+					return err
+				}
+			}
+			createDTO := domain.CreateOrderDTO{
+				OrderId:  first.OrderId,
+				UserId:   first.UserId,
+				Currency: first.Currency,
+			}
+			for _, line := range lines {
+				createDTO.Lines = append(createDTO.Lines, domain.OrderLineDTO{ServiceId: line.ServiceId, Amount: line.Amount})
+			}
+			if _, err := r.CreateOrder(ctx, &createDTO); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+
+		for i := range lines {
+			if err := r.ReserveMoney(ctx, &lines[i]); err != nil {
+				return err
+			}
+		}
+		order, err = r.GetOrder(ctx, &domain.GetOrderDTO{OrderId: first.OrderId})
+		if err != nil {
+			return err
+		}
+		// existing order has lines which weren't reserved
+		if order.Status != domain.OrderStatusReserved {
+			return repository.ErrOrderMismatch
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.Order{}, err
+	}
+	return order, nil
+}
+
+func (r repo) GetOrder(ctx context.Context, dto *domain.GetOrderDTO) (domain.Order, error) {
+	r.logger.Tracef("GetOrder(%v, %#v)", ctx, *dto)
+	var order domain.Order
+	row := r.conn(ctx).QueryRowxContext(ctx, `SELECT `+orderColumns+` FROM "order" WHERE id = $1`, dto.OrderId)
+	if err := row.StructScan(&order); err != nil {
+		if err == sql.ErrNoRows {
+			return domain.Order{}, repository.ErrUnknownOrder
+		}
+		r.logger.Errorf("GetOrder error: %v", err)
+		return domain.Order{}, err
+	}
+
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT service_id, amount, status, updated_at FROM order_line WHERE order_id = $1 ORDER BY service_id`,
+		dto.OrderId)
+	if err != nil {
+		r.logger.Errorf("GetOrder error: %v", err)
+		return domain.Order{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line domain.OrderLine
+		if err := rows.StructScan(&line); err != nil {
+			return domain.Order{}, err
+		}
+		order.Lines = append(order.Lines, line)
+	}
+	return order, rows.Err()
+}
+
+func (r repo) RecognizeOrder(ctx context.Context, dto *domain.ChangeOrderDTO) (domain.Order, error) {
+	r.logger.Tracef("RecognizeOrder(%v, %#v)", ctx, *dto)
+	if dto.ServiceId != 0 {
+		return r.changeOrder(ctx, dto.OrderId, domain.EventRecognized, "CALL recognize_order_line($1, $2)",
+			dto.OrderId,
+			dto.ServiceId)
+	}
+	return r.changeOrder(ctx, dto.OrderId, domain.EventRecognized, "CALL recognize_order($1)", dto.OrderId)
+}
+
+func (r repo) CancelOrder(ctx context.Context, dto *domain.ChangeOrderDTO) (domain.Order, error) {
+	r.logger.Tracef("CancelOrder(%v, %#v)", ctx, *dto)
+	if dto.ServiceId != 0 {
+		return r.changeOrder(ctx, dto.OrderId, domain.EventCanceled, "CALL cancel_order_line($1, $2)",
+			dto.OrderId,
+			dto.ServiceId)
+	}
+	return r.changeOrder(ctx, dto.OrderId, domain.EventCanceled, "CALL cancel_order($1)", dto.OrderId)
+}
+
+func (r repo) RefundOrder(ctx context.Context, dto *domain.ChangeOrderDTO) (domain.Order, error) {
+	r.logger.Tracef("RefundOrder(%v, %#v)", ctx, *dto)
+	description := sql.NullString{String: dto.Description, Valid: dto.Description != ""}
+	if dto.ServiceId != 0 {
+		return r.changeOrder(ctx, dto.OrderId, domain.EventRefunded, "CALL refund_order_line($1, $2, $3)",
+			dto.OrderId,
+			dto.ServiceId,
+			description)
+	}
+	return r.changeOrder(ctx, dto.OrderId, domain.EventRefunded, "CALL refund_order($1, $2)",
+		dto.OrderId,
+		description)
+}
+
+// changeOrder calls order procedure and enqueues event for every changed line. Canceling line
+// which wasn't reserved doesn't change money, so no event is sent for it
+func (r repo) changeOrder(ctx context.Context, orderId uint, eventType string, query string, args ...interface{}) (domain.Order, error) {
+	var order domain.Order
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		before, err := r.GetOrder(ctx, &domain.GetOrderDTO{OrderId: orderId})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			if pqerr, ok := err.(*pq.Error); ok {
+				if err := accountStatusError(pqerr); err != nil {
+					return err
+				}
+				if err := orderError(pqerr); err != nil {
+					return err
+				}
+			}
+			r.logger.Errorf("%s error: %v", query, err)
+			return err
+		}
+		order, err = r.GetOrder(ctx, &domain.GetOrderDTO{OrderId: orderId})
+		if err != nil {
+			return err
+		}
+
+		oldStatuses := make(map[uint]string, len(before.Lines))
+		for _, line := range before.Lines {
+			oldStatuses[line.ServiceId] = line.Status
+		}
+		for _, line := range order.Lines {
+			oldStatus := oldStatuses[line.ServiceId]
+			if oldStatus == line.Status || oldStatus == domain.OrderStatusCreated {
+				continue
+			}
+			err := r.enqueueEvent(ctx, tx, &domain.Event{
+				Type:      eventType,
+				UserId:    order.UserId,
+				Amount:    line.Amount,
+				Currency:  order.Currency,
+				ServiceId: line.ServiceId,
+				OrderId:   order.Id,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.Order{}, err
+	}
+	return order, nil
+}
@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"context"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+)
+
+func (r repo) GrantPromo(ctx context.Context, dto *domain.GrantPromoDTO) (domain.PromoGrant, error) {
+	r.logger.Tracef("GrantPromo(%v, %#v)", ctx, *dto)
+	grant := domain.PromoGrant{
+		UserId:      dto.UserId,
+		Currency:    dto.Currency,
+		Amount:      dto.Amount,
+		Remaining:   dto.Amount,
+		ExpiresAt:   dto.ExpiresAt,
+		Description: dto.Description,
+	}
+	// grant_promo writes audit entry, so it runs in transaction with audit info
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		row := r.conn(ctx).QueryRowxContext(ctx, "SELECT * FROM grant_promo($1, $2, $3, $4, $5)",
+			dto.UserId,
+			dto.Amount,
+			dto.Currency,
+			dto.ExpiresAt.UTC(),
+			dto.Description)
+		return row.Scan(&grant.Id, &grant.CreatedAt)
+	})
+	if err != nil {
+		if pqerr, ok := err.(*pq.Error); ok {
+			if err := accountStatusError(pqerr); err != nil {
+				return domain.PromoGrant{}, err
+			}
+			if pqerr.Code.Name() == "no_data_found" {
+				return domain.PromoGrant{}, repository.ErrUnknownUser
+			} else if pqerr.Code.Name() == "foreign_key_violation" {
+				return domain.PromoGrant{}, repository.ErrUnknownCurrency
+			}
+		}
+		r.logger.Errorf("GrantPromo error: %v", err)
+		return domain.PromoGrant{}, err
+	}
+	return grant, nil
+}
+
+func (r repo) GetPromoGrants(ctx context.Context, dto *domain.GetPromoGrantsDTO) ([]domain.PromoGrant, error) {
+	r.logger.Tracef("GetPromoGrants(%v, %#v)", ctx, *dto)
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT id, user_id, currency, amount, remaining, expired, expires_at, COALESCE(description, '') description, created_at
+		FROM promo_grant WHERE user_id = $1 ORDER BY expires_at, id`,
+		dto.UserId)
+	if err != nil {
+		r.logger.Errorf("GetPromoGrants error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []domain.PromoGrant
+	for rows.Next() {
+		var grant domain.PromoGrant
+		if err := rows.StructScan(&grant); err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
+
+func (r repo) ExpirePromoGrants(ctx context.Context) (int, error) {
+	r.logger.Tracef("ExpirePromoGrants(%v)", ctx)
+	var swept int
+	err := r.conn(ctx).QueryRowxContext(ctx, "SELECT expire_promo_grants()").Scan(&swept)
+	if err != nil {
+		r.logger.Errorf("ExpirePromoGrants error: %v", err)
+	}
+	return swept, err
+}
@@ -2,11 +2,13 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	"github.com/manimadzis/avito-job/internal/domain"
 	"github.com/manimadzis/avito-job/internal/repository"
 	"github.com/manimadzis/avito-job/pkg/logging"
+	"time"
 )
 
 type repo struct {
@@ -14,121 +16,333 @@ type repo struct {
 	logger logging.Logger
 }
 
-func (r repo) GetBalance(ctx context.Context, dto *domain.GetBalanceDTO) (domain.Money, error) {
+func (r repo) GetBalance(ctx context.Context, dto *domain.GetBalanceDTO) (domain.Balance, error) {
 	r.logger.Tracef("GetBalance(%v, %#v)", ctx, *dto)
-	var amount domain.Money
-	row := r.db.QueryRowContext(ctx, "select get_balance($1)", dto.UserId)
+	var balance domain.Balance
+	row := r.conn(ctx).QueryRowxContext(ctx,
+		"SELECT balance, reserved_balance, total, credit_limit, available FROM get_balance($1, $2)",
+		dto.UserId, dto.Currency)
 	err := row.Err()
 	if err != nil {
 		if pqerr, ok := err.(*pq.Error); ok {
 			if pqerr.Code.Name() == "no_data_found" {
 				r.logger.Debugf("GetBalance error: %v", repository.ErrUnknownUser)
-				return domain.Money(0), repository.ErrUnknownUser
+				return domain.Balance{}, repository.ErrUnknownUser
 			}
 		}
-		r.logger.Debugf("GetBalance error: %v", repository.ErrUnknownUser)
-		return domain.Money(0), err
+		r.logger.Errorf("GetBalance error: %v", err)
+		return domain.Balance{}, err
 	}
-	err = row.Scan(&amount)
+	err = row.StructScan(&balance)
 	if err != nil {
 		r.logger.Errorf("GetBalance error: %v", err)
 	}
-	return amount, err
+	return balance, err
 }
 
-func (r repo) ReplenishBalance(ctx context.Context, dto *domain.ReplenishBalanceDTO) error {
-	r.logger.Tracef("ReplenishBalance(%v, %#v)", ctx, *dto)
-	_, err := r.db.ExecContext(ctx, "CALL replenish_balance($1, $2, $3)", dto.UserId, dto.Amount.String(), dto.Description)
+func (r repo) GetReservations(ctx context.Context, dto *domain.GetBalanceDTO) ([]domain.Reservation, error) {
+	r.logger.Tracef("GetReservations(%v, %#v)", ctx, *dto)
+	var reservations []domain.Reservation
+	err := sqlx.SelectContext(ctx, r.conn(ctx), &reservations,
+		"SELECT * FROM get_reservations($1, $2)", dto.UserId, dto.Currency)
 	if err != nil {
-		r.logger.Errorf("RecognizeRevenue error: %v", err)
+		if pqerr, ok := err.(*pq.Error); ok {
+			if pqerr.Code.Name() == "no_data_found" {
+				return nil, repository.ErrUnknownUser
+			}
+		}
+		r.logger.Errorf("GetReservations error: %v", err)
+		return nil, err
 	}
-	return err
+	return reservations, nil
 }
 
-func (r repo) ReserveMoney(ctx context.Context, dto *domain.ReserveMoneyDTO) error {
-	r.logger.Tracef("ReserveMoney(%v, %#v)", ctx, *dto)
-	tx, err := r.db.BeginTx(ctx, nil)
+func (r repo) GetWallets(ctx context.Context, dto *domain.GetBalanceDTO) ([]domain.Wallet, error) {
+	r.logger.Tracef("GetWallets(%v, %#v)", ctx, *dto)
+	rows, err := r.conn(ctx).QueryxContext(ctx, "SELECT * FROM get_wallets($1)", dto.UserId)
 	if err != nil {
-		r.logger.Errorf("ReserveMoney error: %v", err)
-		return err
-	}
-	defer tx.Rollback()
-
-	_, err = tx.ExecContext(ctx, "CALL reserve_money($1, $2, $3, $4, $5)",
-		dto.UserId,
-		dto.Amount.String(),
-		dto.ServiceId,
-		dto.OrderId,
-		dto.Description)
-	if err != nil {
-		r.logger.Errorf("Reserve money error: %v", err)
 		if pqerr, ok := err.(*pq.Error); ok {
 			if pqerr.Code.Name() == "no_data_found" {
-				return repository.ErrUnknownUser
-			} else if pqerr.Message == "NOT_ENOUGH_MONEY" {
-				return repository.ErrNotEnoughMoney
-			} else if pqerr.Code.Name() == "unique_violation" {
-				return repository.ErrTransactionAlreadyExists
+				return nil, repository.ErrUnknownUser
 			}
 		}
+		r.logger.Errorf("GetWallets error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var wallets []domain.Wallet
+	for rows.Next() {
+		var wallet domain.Wallet
+		if err := rows.StructScan(&wallet); err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, wallet)
 	}
+	return wallets, rows.Err()
+}
 
-	if dto.ServiceName != "" {
-		_, err := tx.ExecContext(ctx, "CALL add_service($1, $2)",
+func (r repo) ReplenishBalance(ctx context.Context, dto *domain.ReplenishBalanceDTO) error {
+	r.logger.Tracef("ReplenishBalance(%v, %#v)", ctx, *dto)
+	return r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		_, err := tx.ExecContext(ctx, "CALL replenish_balance($1, $2, $3, $4, $5, $6)",
+			dto.UserId,
+			dto.Amount.String(),
+			dto.Currency,
+			dto.Description,
+			sql.NullString{String: dto.IdempotencyKey, Valid: dto.IdempotencyKey != ""},
+			dto.CreateUser)
+		if err != nil {
+			if pqerr, ok := err.(*pq.Error); ok {
+				if err := accountStatusError(pqerr); err != nil {
+					return err
+				}
+				if pqerr.Code.Name() == "unique_violation" {
+					return repository.ErrTransactionAlreadyExists
+				} else if pqerr.Code.Name() == "foreign_key_violation" {
+					return repository.ErrUnknownCurrency
+				} else if pqerr.Code.Name() == "no_data_found" {
+					return repository.ErrUnknownUser
+				}
+			}
+			r.logger.Errorf("ReplenishBalance error: %v", err)
+			return err
+		}
+
+		return r.enqueueEvent(ctx, tx, &domain.Event{
+			Type:     domain.EventReplenished,
+			UserId:   dto.UserId,
+			Amount:   dto.Amount,
+			Currency: dto.Currency,
+		})
+	})
+}
+
+func (r repo) ReserveMoney(ctx context.Context, dto *domain.ReserveMoneyDTO) error {
+	r.logger.Tracef("ReserveMoney(%v, %#v)", ctx, *dto)
+	return r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		if err := r.checkService(ctx, dto); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, "CALL reserve_money($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+			dto.UserId,
+			dto.Amount.String(),
+			dto.Currency,
 			dto.ServiceId,
-			dto.ServiceName)
+			dto.OrderId,
+			dto.Description,
+			dto.Fee.String(),
+			dto.FeeDescription,
+			dto.PromoLimit.String())
 		if err != nil {
+			r.logger.Errorf("Reserve money error: %v", err)
+			if pqerr, ok := err.(*pq.Error); ok {
+				if err := accountStatusError(pqerr); err != nil {
+					return err
+				}
+				if err := orderError(pqerr); err != nil {
+					return err
+				}
+				if pqerr.Code.Name() == "no_data_found" {
+					return repository.ErrUnknownUser
+				} else if pqerr.Message == "NOT_ENOUGH_MONEY" {
+					return repository.ErrNotEnoughMoney
+				} else if pqerr.Message == "CREDIT_LIMIT_EXCEEDED" {
+					return repository.ErrCreditLimitExceeded
+				} else if pqerr.Code.Name() == "unique_violation" {
+					return repository.ErrTransactionAlreadyExists
+				} else if pqerr.Code.Name() == "foreign_key_violation" {
+					return repository.ErrUnknownCurrency
+				}
+			}
 			return err
 		}
-	}
 
-	if err := tx.Commit(); err != nil {
-		r.logger.Errorf("ReserveMoney commit failed: %v", err)
+		return r.enqueueEvent(ctx, tx, &domain.Event{
+			Type:      domain.EventReserved,
+			UserId:    dto.UserId,
+			Amount:    dto.Amount,
+			Currency:  dto.Currency,
+			ServiceId: dto.ServiceId,
+			OrderId:   dto.OrderId,
+			Fee:       dto.Fee,
+		})
+	})
+}
+
+// checkService adds unknown service of reservation to catalog if dto.CreateService is set
+func (r repo) checkService(ctx context.Context, dto *domain.ReserveMoneyDTO) error {
+	_, err := r.conn(ctx).ExecContext(ctx, "CALL check_service($1, $2, $3)",
+		dto.ServiceId,
+		dto.ServiceName,
+		dto.CreateService)
+	if err != nil {
+		if pqerr, ok := err.(*pq.Error); ok {
+			if pqerr.Message == "UNKNOWN_SERVICE" {
+				return repository.ErrUnknownService
+			} else if pqerr.Message == "SERVICE_INACTIVE" {
+				return repository.ErrServiceInactive
+			}
+		}
+		r.logger.Errorf("Reserve money error: %v", err)
 		return err
 	}
-
 	return nil
 }
 
 func (r repo) RecognizeRevenue(ctx context.Context, dto *domain.RecognizeRevenueDTO) error {
 	r.logger.Tracef("RecognizeRevenue(%v, %#v)", ctx, *dto)
-	_, err := r.db.ExecContext(ctx, "CALL recognize_revenue($1, $2, $3, $4)",
-		dto.UserId,
-		dto.Amount.String(),
-		dto.ServiceId,
-		dto.OrderId)
-	if err != nil {
-		if pqerr, ok := err.(*pq.Error); ok {
-			if pqerr.Message == "UNKNOWN_TRANSACTION" {
-				return repository.ErrUnknownTransaction
+	return r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		var recipients []int64
+		var payouts []string
+		for _, payout := range dto.Payouts {
+			recipients = append(recipients, int64(payout.UserId))
+			payouts = append(payouts, payout.Amount.String())
+		}
+		_, err := tx.ExecContext(ctx, "CALL recognize_revenue($1, $2, $3, $4, $5, $6, $7, $8)",
+			dto.UserId,
+			dto.Amount.String(),
+			dto.Currency,
+			dto.ServiceId,
+			dto.OrderId,
+			pq.Array(recipients),
+			pq.Array(payouts),
+			dto.PayoutDescription)
+		if err != nil {
+			if pqerr, ok := err.(*pq.Error); ok {
+				if err := accountStatusError(pqerr); err != nil {
+					return err
+				}
+				// user who doesn't exist has no transactions
+				if pqerr.Message == "UNKNOWN_TRANSACTION" || pqerr.Code.Name() == "no_data_found" {
+					return repository.ErrUnknownTransaction
+				} else if pqerr.Message == "CURRENCY_MISMATCH" {
+					return repository.ErrCurrencyMismatch
+				} else if pqerr.Message == "UNKNOWN_RECIPIENT" {
+					return repository.ErrUnknownRecipient
+				} else if pqerr.Message == "RECIPIENT_INACTIVE" {
+					return repository.ErrRecipientInactive
+				}
 			}
+			r.logger.Errorf("RecognizeRevenue error: %v", err)
+			return err
 		}
-		r.logger.Errorf("RecognizeRevenue error: %v", err)
-	}
-	return err
+
+		err = r.enqueueEvent(ctx, tx, &domain.Event{
+			Type:      domain.EventRecognized,
+			UserId:    dto.UserId,
+			Amount:    dto.Amount,
+			Currency:  dto.Currency,
+			ServiceId: dto.ServiceId,
+			OrderId:   dto.OrderId,
+		})
+		if err != nil {
+			return err
+		}
+		for _, payout := range dto.Payouts {
+			if payout.Amount == 0 {
+				continue
+			}
+			err := r.enqueueEvent(ctx, tx, &domain.Event{
+				Type:      domain.EventPaidOut,
+				UserId:    payout.UserId,
+				Amount:    payout.Amount,
+				Currency:  dto.Currency,
+				ServiceId: dto.ServiceId,
+				OrderId:   dto.OrderId,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 func (r repo) CancelTransaction(ctx context.Context, dto *domain.CancelTransactionDTO) error {
 	r.logger.Tracef("CancelTransaction(%v, %#v)", ctx, *dto)
-	_, err := r.db.ExecContext(ctx, "CALL cancel_transaction($1, $2, $3, $4)",
-		dto.UserId,
-		dto.Amount.String(),
-		dto.ServiceId,
-		dto.OrderId)
-	if err != nil {
-		if pqerr, ok := err.(*pq.Error); ok {
-			if pqerr.Message == "UNKNOWN_TRANSACTION" {
-				return repository.ErrUnknownTransaction
+	return r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		_, err := tx.ExecContext(ctx, "CALL cancel_transaction($1, $2, $3, $4, $5)",
+			dto.UserId,
+			dto.Amount.String(),
+			dto.Currency,
+			dto.ServiceId,
+			dto.OrderId)
+		if err != nil {
+			if pqerr, ok := err.(*pq.Error); ok {
+				if err := accountStatusError(pqerr); err != nil {
+					return err
+				}
+				// user who doesn't exist has no transactions
+				if pqerr.Message == "UNKNOWN_TRANSACTION" || pqerr.Code.Name() == "no_data_found" {
+					return repository.ErrUnknownTransaction
+				} else if pqerr.Message == "CURRENCY_MISMATCH" {
+					return repository.ErrCurrencyMismatch
+				}
 			}
+			r.logger.Errorf("CancelTransaction error: %v", err)
+			return err
 		}
-		r.logger.Errorf("CancelTransaction error: %v", err)
-	}
-	return err
+
+		return r.enqueueEvent(ctx, tx, &domain.Event{
+			Type:      domain.EventCanceled,
+			UserId:    dto.UserId,
+			Amount:    dto.Amount,
+			Currency:  dto.Currency,
+			ServiceId: dto.ServiceId,
+			OrderId:   dto.OrderId,
+		})
+	})
+}
+
+func (r repo) AdjustBalance(ctx context.Context, dto *domain.AdjustBalanceDTO, description string) error {
+	r.logger.Tracef("AdjustBalance(%v, %#v)", ctx, *dto)
+	return r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		_, err := tx.ExecContext(ctx, "CALL adjust_balance($1, $2, $3, $4, $5)",
+			dto.UserId,
+			dto.Amount.String(),
+			dto.Currency,
+			description,
+			dto.Refund)
+		if err != nil {
+			if pqerr, ok := err.(*pq.Error); ok {
+				if err := accountStatusError(pqerr); err != nil {
+					return err
+				}
+				if pqerr.Code.Name() == "no_data_found" {
+					return repository.ErrUnknownUser
+				} else if pqerr.Message == "NOT_ENOUGH_MONEY" {
+					return repository.ErrNotEnoughMoney
+				} else if pqerr.Code.Name() == "foreign_key_violation" {
+					return repository.ErrUnknownCurrency
+				}
+			}
+			r.logger.Errorf("AdjustBalance error: %v", err)
+			return err
+		}
+
+		eventType := domain.EventAdjusted
+		if dto.Refund {
+			eventType = domain.EventRefunded
+		}
+		return r.enqueueEvent(ctx, tx, &domain.Event{
+			Type:     eventType,
+			UserId:   dto.UserId,
+			Amount:   dto.Amount,
+			Currency: dto.Currency,
+		})
+	})
 }
 
 func (r repo) GetMonthlyReport(ctx context.Context, dto *domain.GetMonthlyReportDTO) (domain.MonthlyReport, error) {
 	r.logger.Tracef("GetMonthlyReportPath(%v, %#v)", ctx, *dto)
-	rows, err := r.db.QueryxContext(ctx, "SELECT * FROM get_month_report($1, $2)",
+	rows, err := r.conn(ctx).QueryxContext(ctx, "SELECT * FROM get_month_report($1, $2)",
 		dto.Month,
 		dto.Year)
 	if err != nil {
@@ -152,17 +366,21 @@ func (r repo) GetHistory(ctx context.Context, dto *domain.GetHistoryDTO) (domain
 	var rows *sqlx.Rows
 	var err error
 	if dto.SortBy == "" || dto.SortBy == domain.GetHistoryDTOSortByTimestamp {
-		rows, err = r.db.QueryxContext(ctx, "SELECT * FROM get_history_sorted_by_timestamp($1, $2, $3, $4)",
+		rows, err = r.conn(ctx).QueryxContext(ctx, "SELECT * FROM get_history_sorted_by_timestamp($1, $2, $3, $4, $5, $6)",
 			dto.UserId,
 			dto.Offset,
 			dto.Limit,
-			dto.Reverse)
+			dto.Reverse,
+			nullTime(dto.From),
+			nullTime(dto.To))
 	} else if dto.SortBy == domain.GetHistoryDTOSortByAmount {
-		rows, err = r.db.QueryxContext(ctx, "SELECT * FROM get_history_sorted_by_amount($1, $2, $3, $4)",
+		rows, err = r.conn(ctx).QueryxContext(ctx, "SELECT * FROM get_history_sorted_by_amount($1, $2, $3, $4, $5, $6)",
 			dto.UserId,
 			dto.Offset,
 			dto.Limit,
-			dto.Reverse)
+			dto.Reverse,
+			nullTime(dto.From),
+			nullTime(dto.To))
 	}
 	if err != nil {
 		if pqerr, ok := err.(*pq.Error); ok {
@@ -184,6 +402,64 @@ func (r repo) GetHistory(ctx context.Context, dto *domain.GetHistoryDTO) (domain
 	return history, nil
 }
 
+func (r repo) GetUserEventsAfter(ctx context.Context, userId uint, afterId uint, limit int) ([]domain.UserEvent, error) {
+	r.logger.Tracef("GetUserEventsAfter(%v, %d, %d, %d)", ctx, userId, afterId, limit)
+	rows, err := r.conn(ctx).QueryxContext(ctx, "SELECT * FROM get_user_events_after($1, $2, $3)",
+		userId,
+		afterId,
+		limit)
+	if err != nil {
+		if pqerr, ok := err.(*pq.Error); ok {
+			if pqerr.Code.Name() == "no_data_found" && pqerr.Message == "UNKNOWN_USER" {
+				return nil, repository.ErrUnknownUser
+			}
+		}
+		r.logger.Errorf("GetUserEventsAfter error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []domain.UserEvent
+	for rows.Next() {
+		var event domain.UserEvent
+		if err := rows.StructScan(&event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (r repo) GetUsedIdempotencyKeys(ctx context.Context, keys []string) (map[string]bool, error) {
+	r.logger.Tracef("GetUsedIdempotencyKeys(%v, %d keys)", ctx, len(keys))
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		"SELECT idempotency_key FROM transactions_since(NULL) WHERE idempotency_key = ANY ($1)",
+		pq.Array(keys))
+	if err != nil {
+		r.logger.Errorf("GetUsedIdempotencyKeys error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	used := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		used[key] = true
+	}
+	return used, rows.Err()
+}
+
+// nullTime converts t to UTC because timestamps are stored without time zone
+func nullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
 func NewRepository(db *sqlx.DB, logger logging.Logger) repository.Repository {
 	return &repo{
 		db:     db,
@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+)
+
+const serviceColumns = `id, COALESCE("name", '') "name", "description", category, active, default_price, currency, created_at`
+
+func (r repo) CreateService(ctx context.Context, dto *domain.CreateServiceDTO) (domain.Service, error) {
+	r.logger.Tracef("CreateService(%v, %#v)", ctx, *dto)
+	var service domain.Service
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		row := tx.QueryRowxContext(ctx,
+			`INSERT INTO "service" (id, "name", "description", category, active, default_price, currency)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING `+serviceColumns,
+			dto.ServiceId,
+			dto.Name,
+			dto.Description,
+			dto.Category,
+			dto.Active == nil || *dto.Active,
+			dto.DefaultPrice,
+			dto.Currency)
+		if err := row.StructScan(&service); err != nil {
+			if pqerr, ok := err.(*pq.Error); ok {
+				if pqerr.Code.Name() == "unique_violation" {
+					return repository.ErrServiceAlreadyExists
+				} else if pqerr.Code.Name() == "foreign_key_violation" {
+					return repository.ErrUnknownCurrency
+				}
+			}
+			r.logger.Errorf("CreateService error: %v", err)
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "CALL record_service_name($1, $2)", dto.ServiceId, dto.Name); err != nil {
+			return err
+		}
+		return r.auditChange(ctx, "service", service.Id, 0, service.Currency, service)
+	})
+	if err != nil {
+		return domain.Service{}, err
+	}
+	return service, nil
+}
+
+func (r repo) UpdateService(ctx context.Context, dto *domain.UpdateServiceDTO) (domain.Service, error) {
+	r.logger.Tracef("UpdateService(%v, %#v)", ctx, *dto)
+	var service domain.Service
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		row := tx.QueryRowxContext(ctx,
+			`UPDATE "service" SET "name" = $2, "description" = $3, category = $4, active = $5, default_price = $6, currency = $7
+			WHERE id = $1 RETURNING `+serviceColumns,
+			dto.ServiceId,
+			dto.Name,
+			dto.Description,
+			dto.Category,
+			dto.Active == nil || *dto.Active,
+			dto.DefaultPrice,
+			dto.Currency)
+		if err := row.StructScan(&service); err != nil {
+			if err == sql.ErrNoRows {
+				return repository.ErrUnknownService
+			}
+			if pqerr, ok := err.(*pq.Error); ok {
+				if pqerr.Code.Name() == "foreign_key_violation" {
+					return repository.ErrUnknownCurrency
+				}
+			}
+			r.logger.Errorf("UpdateService error: %v", err)
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "CALL record_service_name($1, $2)", dto.ServiceId, dto.Name); err != nil {
+			return err
+		}
+		return r.auditChange(ctx, "service", service.Id, 0, service.Currency, service)
+	})
+	if err != nil {
+		return domain.Service{}, err
+	}
+	return service, nil
+}
+
+func (r repo) DeactivateService(ctx context.Context, dto *domain.GetServiceDTO) error {
+	r.logger.Tracef("DeactivateService(%v, %#v)", ctx, *dto)
+	return r.InTransaction(ctx, func(ctx context.Context) error {
+		var service domain.Service
+		row := r.conn(ctx).QueryRowxContext(ctx,
+			`UPDATE "service" SET active = FALSE WHERE id = $1 RETURNING `+serviceColumns, dto.ServiceId)
+		if err := row.StructScan(&service); err != nil {
+			if err == sql.ErrNoRows {
+				return repository.ErrUnknownService
+			}
+			r.logger.Errorf("DeactivateService error: %v", err)
+			return err
+		}
+		return r.auditChange(ctx, "service", service.Id, 0, service.Currency, service)
+	})
+}
+
+func (r repo) GetService(ctx context.Context, dto *domain.GetServiceDTO) (domain.Service, error) {
+	r.logger.Tracef("GetService(%v, %#v)", ctx, *dto)
+	var service domain.Service
+	row := r.conn(ctx).QueryRowxContext(ctx, `SELECT `+serviceColumns+` FROM "service" WHERE id = $1`, dto.ServiceId)
+	if err := row.StructScan(&service); err != nil {
+		if err == sql.ErrNoRows {
+			return domain.Service{}, repository.ErrUnknownService
+		}
+		r.logger.Errorf("GetService error: %v", err)
+		return domain.Service{}, err
+	}
+	return service, nil
+}
+
+func (r repo) GetServices(ctx context.Context, dto *domain.GetServicesDTO) ([]domain.Service, error) {
+	r.logger.Tracef("GetServices(%v, %#v)", ctx, *dto)
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT `+serviceColumns+` FROM "service"
+		WHERE ($1 = '' OR category = $1) AND (NOT $2 OR active)
+		ORDER BY id`,
+		dto.Category,
+		dto.ActiveOnly)
+	if err != nil {
+		r.logger.Errorf("GetServices error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var services []domain.Service
+	for rows.Next() {
+		var service domain.Service
+		if err := rows.StructScan(&service); err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+	}
+	return services, rows.Err()
+}
+
+func (r repo) GetServiceNames(ctx context.Context, dto *domain.GetServiceDTO) ([]domain.ServiceName, error) {
+	r.logger.Tracef("GetServiceNames(%v, %#v)", ctx, *dto)
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT "name", valid_from FROM service_name_history WHERE service_id = $1 ORDER BY valid_from, id`,
+		dto.ServiceId)
+	if err != nil {
+		r.logger.Errorf("GetServiceNames error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []domain.ServiceName
+	for rows.Next() {
+		var name domain.ServiceName
+		if err := rows.StructScan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
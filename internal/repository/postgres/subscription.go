@@ -0,0 +1,186 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"time"
+)
+
+const subscriptionColumns = `id, user_id, service_id, amount, currency, period, "description", status, next_run_at,
+	failures, COALESCE(last_error, '') last_error, retry_at, created_at, updated_at`
+
+func (r repo) CreateSubscription(ctx context.Context, dto *domain.CreateSubscriptionDTO) (domain.Subscription, error) {
+	r.logger.Tracef("CreateSubscription(%v, %#v)", ctx, *dto)
+	var subscription domain.Subscription
+	row := r.conn(ctx).QueryRowxContext(ctx,
+		`INSERT INTO subscription (user_id, service_id, amount, currency, period, "description", next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, CURRENT_TIMESTAMP)) RETURNING `+subscriptionColumns,
+		dto.UserId,
+		dto.ServiceId,
+		dto.Amount,
+		dto.Currency,
+		dto.Period,
+		dto.Description,
+		nullTime(dto.NextRunAt))
+	if err := row.StructScan(&subscription); err != nil {
+		if pqerr, ok := err.(*pq.Error); ok && pqerr.Code.Name() == "foreign_key_violation" {
+			switch pqerr.Constraint {
+			case "subscription_user_id_fkey":
+				return domain.Subscription{}, repository.ErrUnknownUser
+			case "subscription_service_id_fkey":
+				return domain.Subscription{}, repository.ErrUnknownService
+			}
+			return domain.Subscription{}, repository.ErrUnknownCurrency
+		}
+		r.logger.Errorf("CreateSubscription error: %v", err)
+		return domain.Subscription{}, err
+	}
+	return subscription, nil
+}
+
+func (r repo) GetSubscriptions(ctx context.Context, dto *domain.GetSubscriptionsDTO) ([]domain.Subscription, error) {
+	r.logger.Tracef("GetSubscriptions(%v, %#v)", ctx, *dto)
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT `+subscriptionColumns+` FROM subscription WHERE user_id = $1 ORDER BY id`,
+		dto.UserId)
+	if err != nil {
+		r.logger.Errorf("GetSubscriptions error: %v", err)
+		return nil, err
+	}
+	return scanSubscriptions(rows)
+}
+
+func (r repo) CancelSubscription(ctx context.Context, dto *domain.SubscriptionDTO) (domain.Subscription, error) {
+	r.logger.Tracef("CancelSubscription(%v, %#v)", ctx, *dto)
+	return r.updateSubscription(ctx, dto,
+		`UPDATE subscription SET status = 'CANCELED', retry_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 RETURNING `+subscriptionColumns)
+}
+
+func (r repo) ResumeSubscription(ctx context.Context, dto *domain.SubscriptionDTO) (domain.Subscription, error) {
+	r.logger.Tracef("ResumeSubscription(%v, %#v)", ctx, *dto)
+	return r.updateSubscription(ctx, dto,
+		`UPDATE subscription SET status = 'ACTIVE', failures = 0, retry_at = NULL,
+		next_run_at = GREATEST(next_run_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND status <> 'CANCELED' RETURNING `+subscriptionColumns)
+}
+
+// updateSubscription runs query which updates subscription of the user unless it is canceled
+func (r repo) updateSubscription(ctx context.Context, dto *domain.SubscriptionDTO, query string) (domain.Subscription, error) {
+	var subscription domain.Subscription
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		var status string
+		err := tx.QueryRowxContext(ctx, "SELECT status FROM subscription WHERE id = $1 AND user_id = $2 FOR UPDATE",
+			dto.SubscriptionId,
+			dto.UserId).Scan(&status)
+		if err == sql.ErrNoRows {
+			return repository.ErrUnknownSubscription
+		} else if err != nil {
+			return err
+		}
+		if status == domain.SubscriptionStatusCanceled {
+			return repository.ErrSubscriptionCanceled
+		}
+		return tx.QueryRowxContext(ctx, query, dto.SubscriptionId, dto.UserId).StructScan(&subscription)
+	})
+	if err != nil {
+		if err != repository.ErrUnknownSubscription && err != repository.ErrSubscriptionCanceled {
+			r.logger.Errorf("updateSubscription error: %v", err)
+		}
+		return domain.Subscription{}, err
+	}
+	return subscription, nil
+}
+
+func (r repo) ClaimDueSubscriptions(ctx context.Context, limit int, lease time.Duration) ([]domain.Subscription, error) {
+	r.logger.Tracef("ClaimDueSubscriptions(%v, %d, %v)", ctx, limit, lease)
+	rows, err := r.db.QueryxContext(ctx,
+		`SELECT `+subscriptionColumns+` FROM claim_due_subscriptions($1, $2)`,
+		limit,
+		int(lease.Seconds()))
+	if err != nil {
+		r.logger.Errorf("ClaimDueSubscriptions error: %v", err)
+		return nil, err
+	}
+	return scanSubscriptions(rows)
+}
+
+func (r repo) ChargeSubscription(ctx context.Context, subscriptionId uint, dto *domain.ReserveMoneyDTO) error {
+	r.logger.Tracef("ChargeSubscription(%v, %d, %#v)", ctx, subscriptionId, *dto)
+	return r.InTransaction(ctx, func(ctx context.Context) error {
+		tx := r.conn(ctx)
+		_, err := tx.ExecContext(ctx, "SELECT charge_subscription($1, $2, $3, $4, $5)",
+			subscriptionId,
+			dto.Fee.String(),
+			dto.FeeDescription,
+			dto.PromoLimit.String(),
+			dto.Description)
+		if err != nil {
+			if pqerr, ok := err.(*pq.Error); ok {
+				if err := accountStatusError(pqerr); err != nil {
+					return err
+				}
+				switch pqerr.Message {
+				case "SUBSCRIPTION_NOT_DUE":
+					return repository.ErrSubscriptionNotDue
+				case "NOT_ENOUGH_MONEY":
+					return repository.ErrNotEnoughMoney
+				case "CREDIT_LIMIT_EXCEEDED":
+					return repository.ErrCreditLimitExceeded
+				case "UNKNOWN_SERVICE":
+					return repository.ErrUnknownService
+				case "SERVICE_INACTIVE":
+					return repository.ErrServiceInactive
+				}
+			}
+			r.logger.Errorf("ChargeSubscription error: %v", err)
+			return err
+		}
+
+		return r.enqueueEvent(ctx, tx, &domain.Event{
+			Type:      domain.EventCharged,
+			UserId:    dto.UserId,
+			Amount:    dto.Amount,
+			Currency:  dto.Currency,
+			ServiceId: dto.ServiceId,
+			Fee:       dto.Fee,
+		})
+	})
+}
+
+func (r repo) MarkSubscriptionFailed(ctx context.Context, id uint, reason string, retryAt time.Time, pause bool) error {
+	r.logger.Tracef("MarkSubscriptionFailed(%v, %d, %s, %v, %v)", ctx, id, reason, retryAt, pause)
+	status := domain.SubscriptionStatusActive
+	if pause {
+		status = domain.SubscriptionStatusPaused
+	}
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE subscription SET failures = failures + 1, last_error = $2, retry_at = $3, status = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'ACTIVE'`,
+		id,
+		reason,
+		retryAt.UTC(),
+		status)
+	if err != nil {
+		r.logger.Errorf("MarkSubscriptionFailed error: %v", err)
+	}
+	return err
+}
+
+func scanSubscriptions(rows *sqlx.Rows) ([]domain.Subscription, error) {
+	defer rows.Close()
+	var subscriptions []domain.Subscription
+	for rows.Next() {
+		var subscription domain.Subscription
+		if err := rows.StructScan(&subscription); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+)
+
+type txKey struct{}
+
+// conn return transaction started by InTransaction or db
+func (r repo) conn(ctx context.Context) sqlx.ExtContext {
+	if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return tx
+	}
+	return r.db
+}
+
+func (r repo) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		r.logger.Errorf("InTransaction begin failed: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := r.setAuditInfo(ctx, tx); err != nil {
+		r.logger.Errorf("InTransaction audit info failed: %v", err)
+		return err
+	}
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		r.logger.Errorf("InTransaction commit failed: %v", err)
+		return err
+	}
+	return nil
+}
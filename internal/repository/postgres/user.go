@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+)
+
+func (r repo) CreateUser(ctx context.Context, dto *domain.CreateUserDTO) (domain.User, error) {
+	r.logger.Tracef("CreateUser(%v, %#v)", ctx, *dto)
+	metadata := "{}"
+	if len(dto.Metadata) != 0 && string(dto.Metadata) != "null" {
+		metadata = string(dto.Metadata)
+	}
+	row := r.conn(ctx).QueryRowxContext(ctx,
+		`INSERT INTO "user" (id, external_ref, metadata) VALUES ($1, NULLIF($2, ''), $3)
+		RETURNING id, status, COALESCE(external_ref, ''), metadata::text, created_at`,
+		dto.UserId,
+		dto.ExternalRef,
+		metadata)
+	user, err := scanUser(row)
+	if err != nil {
+		if pqerr, ok := err.(*pq.Error); ok {
+			if pqerr.Code.Name() == "unique_violation" {
+				if pqerr.Constraint == "user_external_ref_key" {
+					return domain.User{}, repository.ErrExternalRefAlreadyUsed
+				}
+				return domain.User{}, repository.ErrUserAlreadyExists
+			}
+		}
+		r.logger.Errorf("CreateUser error: %v", err)
+		return domain.User{}, err
+	}
+	return user, nil
+}
+
+func (r repo) GetUser(ctx context.Context, dto *domain.GetUserDTO) (domain.User, error) {
+	r.logger.Tracef("GetUser(%v, %#v)", ctx, *dto)
+	row := r.conn(ctx).QueryRowxContext(ctx,
+		`SELECT id, status, COALESCE(external_ref, ''), metadata::text, created_at FROM "user" WHERE id = $1`,
+		dto.UserId)
+	user, err := scanUser(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return domain.User{}, repository.ErrUnknownUser
+		}
+		r.logger.Errorf("GetUser error: %v", err)
+		return domain.User{}, err
+	}
+	return user, nil
+}
+
+func scanUser(row interface{ Scan(...interface{}) error }) (domain.User, error) {
+	var user domain.User
+	var metadata string
+	if err := row.Scan(&user.Id, &user.Status, &user.ExternalRef, &metadata, &user.CreatedAt); err != nil {
+		return domain.User{}, err
+	}
+	user.Metadata = json.RawMessage(metadata)
+	return user, nil
+}
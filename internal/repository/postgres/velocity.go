@@ -0,0 +1,175 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"time"
+)
+
+func (r repo) CreateVelocityRule(ctx context.Context, dto *domain.CreateVelocityRuleDTO) (domain.VelocityRule, error) {
+	r.logger.Tracef("CreateVelocityRule(%v, %#v)", ctx, *dto)
+	rule := domain.VelocityRule{
+		UserId:        dto.UserId,
+		Currency:      dto.Currency,
+		Metric:        dto.Metric,
+		WindowSeconds: dto.WindowSeconds,
+		MaxAmount:     dto.MaxAmount,
+		MaxCount:      dto.MaxCount,
+	}
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		row := r.conn(ctx).QueryRowxContext(ctx,
+			`INSERT INTO velocity_rule (user_id, currency, metric, window_seconds, max_amount, max_count)
+			VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6) RETURNING id, created_at`,
+			dto.UserId,
+			dto.Currency,
+			dto.Metric,
+			dto.WindowSeconds,
+			dto.MaxAmount,
+			dto.MaxCount)
+		if err := row.Scan(&rule.Id, &rule.CreatedAt); err != nil {
+			if pqerr, ok := err.(*pq.Error); ok {
+				if pqerr.Code.Name() == "foreign_key_violation" {
+					return repository.ErrUnknownCurrency
+				}
+			}
+			r.logger.Errorf("CreateVelocityRule error: %v", err)
+			return err
+		}
+		return r.auditChange(ctx, "velocity_rule", rule.Id, rule.UserId, rule.Currency, rule)
+	})
+	if err != nil {
+		return domain.VelocityRule{}, err
+	}
+	return rule, nil
+}
+
+func (r repo) GetVelocityRules(ctx context.Context, dto *domain.GetVelocityRulesDTO) ([]domain.VelocityRule, error) {
+	r.logger.Tracef("GetVelocityRules(%v, %#v)", ctx, *dto)
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT id, COALESCE(user_id, 0) user_id, currency, metric, window_seconds, max_amount, max_count, created_at
+		FROM velocity_rule
+		WHERE $1 = 0 OR user_id IS NULL OR user_id = $1
+		ORDER BY id`,
+		dto.UserId)
+	if err != nil {
+		r.logger.Errorf("GetVelocityRules error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []domain.VelocityRule
+	for rows.Next() {
+		var rule domain.VelocityRule
+		if err := rows.StructScan(&rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (r repo) DeleteVelocityRule(ctx context.Context, dto *domain.DeleteVelocityRuleDTO) error {
+	r.logger.Tracef("DeleteVelocityRule(%v, %#v)", ctx, *dto)
+	return r.InTransaction(ctx, func(ctx context.Context) error {
+		var rule domain.VelocityRule
+		row := r.conn(ctx).QueryRowxContext(ctx,
+			`DELETE FROM velocity_rule WHERE id = $1
+			RETURNING id, COALESCE(user_id, 0) user_id, currency, metric, window_seconds, max_amount, max_count, created_at`,
+			dto.RuleId)
+		if err := row.StructScan(&rule); err != nil {
+			if err == sql.ErrNoRows {
+				return repository.ErrUnknownVelocityRule
+			}
+			r.logger.Errorf("DeleteVelocityRule error: %v", err)
+			return err
+		}
+		return r.auditChange(ctx, "velocity_rule", rule.Id, rule.UserId, rule.Currency, deletedEntity{Deleted: true})
+	})
+}
+
+func (r repo) LockUserSpending(ctx context.Context, userId uint) error {
+	r.logger.Tracef("LockUserSpending(%v, %d)", ctx, userId)
+	_, err := r.conn(ctx).ExecContext(ctx, "CALL lock_spending($1)", userId)
+	if err != nil {
+		r.logger.Errorf("LockUserSpending error: %v", err)
+	}
+	return err
+}
+
+func (r repo) GetSpending(ctx context.Context, userId uint, currency domain.Currency, window time.Duration) (domain.Spending, error) {
+	r.logger.Tracef("GetSpending(%v, %d, %s, %v)", ctx, userId, currency, window)
+	var spending domain.Spending
+	row := r.conn(ctx).QueryRowxContext(ctx,
+		`SELECT COALESCE(SUM(amount), 0) amount, COALESCE(SUM(operations), 0) count
+		FROM spending
+		WHERE user_id = $1 AND currency = $2 AND created_at > CURRENT_TIMESTAMP - make_interval(secs => $3)`,
+		userId,
+		currency,
+		window.Seconds())
+	if err := row.StructScan(&spending); err != nil {
+		r.logger.Errorf("GetSpending error: %v", err)
+		return domain.Spending{}, err
+	}
+	return spending, nil
+}
+
+func (r repo) RecordSpending(ctx context.Context, userId uint, currency domain.Currency, amount domain.Money, operation string) error {
+	r.logger.Tracef("RecordSpending(%v, %d, %s, %s, %s)", ctx, userId, currency, amount, operation)
+	_, err := r.conn(ctx).ExecContext(ctx, "CALL record_spending($1, $2, $3, $4)",
+		userId,
+		currency,
+		amount,
+		operation)
+	if err != nil {
+		r.logger.Errorf("RecordSpending error: %v", err)
+	}
+	return err
+}
+
+func (r repo) RecordVelocityViolation(ctx context.Context, violation *domain.VelocityViolation) error {
+	r.logger.Tracef("RecordVelocityViolation(%v, %#v)", ctx, *violation)
+	// violation is written outside transaction of ctx, it is rolled back because of the violation
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO velocity_violation (user_id, currency, rule_id, operation, amount, used)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		violation.UserId,
+		violation.Currency,
+		violation.RuleId,
+		violation.Operation,
+		violation.Amount,
+		violation.Used)
+	if err != nil {
+		r.logger.Errorf("RecordVelocityViolation error: %v", err)
+	}
+	return err
+}
+
+func (r repo) GetVelocityViolations(ctx context.Context, dto *domain.GetVelocityViolationsDTO) ([]domain.VelocityViolation, error) {
+	r.logger.Tracef("GetVelocityViolations(%v, %#v)", ctx, *dto)
+	rows, err := r.conn(ctx).QueryxContext(ctx,
+		`SELECT id, user_id, currency, COALESCE(rule_id, 0) rule_id, operation, amount, used::text used, created_at
+		FROM velocity_violation
+		WHERE $1 = 0 OR user_id = $1
+		ORDER BY id DESC
+		LIMIT NULLIF($2, 0)`,
+		dto.UserId,
+		dto.Limit)
+	if err != nil {
+		r.logger.Errorf("GetVelocityViolations error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var violations []domain.VelocityViolation
+	for rows.Next() {
+		var violation domain.VelocityViolation
+		if err := rows.StructScan(&violation); err != nil {
+			return nil, err
+		}
+		violations = append(violations, violation)
+	}
+	return violations, rows.Err()
+}
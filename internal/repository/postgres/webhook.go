@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"time"
+)
+
+// enqueueEvent must be called inside the transaction which changes money
+func (r repo) enqueueEvent(ctx context.Context, tx sqlx.ExecerContext, event *domain.Event) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		r.logger.Errorf("enqueueEvent marshal error: %v", err)
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "CALL enqueue_event($1, $2)", event.Type, string(payload))
+	if err != nil {
+		r.logger.Errorf("enqueueEvent error: %v", err)
+	}
+	return err
+}
+
+func (r repo) CreateWebhook(ctx context.Context, dto *domain.CreateWebhookDTO) (domain.Webhook, error) {
+	// dto holds the signing secret, so only url is logged
+	r.logger.Tracef("CreateWebhook(%v, %#v)", ctx, dto.URL)
+	webhook := domain.Webhook{
+		URL:    dto.URL,
+		Events: dto.Events,
+		Secret: dto.Secret,
+	}
+	err := r.InTransaction(ctx, func(ctx context.Context) error {
+		row := r.conn(ctx).QueryRowxContext(ctx,
+			"INSERT INTO webhook_subscription (url, events, secret) VALUES ($1, $2, $3) RETURNING id, created_at",
+			dto.URL,
+			pq.Array(dto.Events),
+			dto.Secret)
+		if err := row.Scan(&webhook.Id, &webhook.CreatedAt); err != nil {
+			r.logger.Errorf("CreateWebhook error: %v", err)
+			return err
+		}
+		// secret isn't marshaled, so it doesn't get into audit log
+		return r.auditChange(ctx, "webhook", webhook.Id, 0, "", webhook)
+	})
+	if err != nil {
+		return domain.Webhook{}, err
+	}
+	r.logger.Tracef("CreateWebhook created webhook %d for %#v", webhook.Id, webhook.URL)
+	return webhook, nil
+}
+
+func (r repo) GetWebhooks(ctx context.Context) ([]domain.Webhook, error) {
+	r.logger.Tracef("GetWebhooks(%v)", ctx)
+	rows, err := r.db.QueryContext(ctx, "SELECT id, url, events, secret, created_at FROM webhook_subscription ORDER BY id")
+	if err != nil {
+		r.logger.Errorf("GetWebhooks error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []domain.Webhook
+	for rows.Next() {
+		var webhook domain.Webhook
+		err := rows.Scan(&webhook.Id, &webhook.URL, pq.Array(&webhook.Events), &webhook.Secret, &webhook.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (r repo) DeleteWebhook(ctx context.Context, dto *domain.DeleteWebhookDTO) error {
+	r.logger.Tracef("DeleteWebhook(%v, %#v)", ctx, *dto)
+	return r.InTransaction(ctx, func(ctx context.Context) error {
+		res, err := r.conn(ctx).ExecContext(ctx, "DELETE FROM webhook_subscription WHERE id = $1", dto.WebhookId)
+		if err != nil {
+			r.logger.Errorf("DeleteWebhook error: %v", err)
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return repository.ErrUnknownWebhook
+		}
+		return r.auditChange(ctx, "webhook", dto.WebhookId, 0, "", deletedEntity{Deleted: true})
+	})
+}
+
+func (r repo) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domain.WebhookDelivery, error) {
+	r.logger.Tracef("ClaimWebhookDeliveries(%v, %d, %v)", ctx, limit, lease)
+	rows, err := r.db.QueryxContext(ctx, "SELECT * FROM claim_webhook_deliveries($1, $2)",
+		limit,
+		int(lease.Seconds()))
+	if err != nil {
+		r.logger.Errorf("ClaimWebhookDeliveries error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []domain.WebhookDelivery
+	for rows.Next() {
+		var delivery domain.WebhookDelivery
+		if err := rows.StructScan(&delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (r repo) MarkWebhookDelivered(ctx context.Context, id uint) error {
+	r.logger.Tracef("MarkWebhookDelivered(%v, %d)", ctx, id)
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE webhook_outbox SET status = 'DONE', attempts = attempts + 1, last_error = NULL WHERE id = $1",
+		id)
+	if err != nil {
+		r.logger.Errorf("MarkWebhookDelivered error: %v", err)
+	}
+	return err
+}
+
+func (r repo) MarkWebhookFailed(ctx context.Context, id uint, reason string, retryIn time.Duration, dead bool) error {
+	r.logger.Tracef("MarkWebhookFailed(%v, %d, %s, %v, %v)", ctx, id, reason, retryIn, dead)
+	status := domain.WebhookDeliveryStatusPending
+	if dead {
+		status = domain.WebhookDeliveryStatusDead
+	}
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE webhook_outbox SET status = $2, attempts = attempts + 1, last_error = $3, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $4) WHERE id = $1",
+		id,
+		status,
+		reason,
+		retryIn.Seconds())
+	if err != nil {
+		r.logger.Errorf("MarkWebhookFailed error: %v", err)
+	}
+	return err
+}
@@ -3,20 +3,242 @@ package repository
 import (
 	"context"
 	"github.com/manimadzis/avito-job/internal/domain"
+	"time"
 )
 
+// Money operations return ErrAccountFrozen or ErrAccountClosed if account status doesn't allow them
 type Repository interface {
+	// InTransaction runs fn in a transaction. Repository calls made with ctx passed to fn are
+	// committed if fn returns nil and rolled back otherwise. Nested calls join the outer transaction.
+	// Wallet changes made in the transaction are written to audit log with domain.AuditInfo of ctx
+	InTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+
+	// CreateUser return ErrUserAlreadyExists if user with dto.UserId exists
+	// return ErrExternalRefAlreadyUsed if other user has dto.ExternalRef
+	CreateUser(ctx context.Context, dto *domain.CreateUserDTO) (domain.User, error)
+	// GetUser return ErrUnknownUser if user doesn't exist
+	GetUser(ctx context.Context, dto *domain.GetUserDTO) (domain.User, error)
+
 	// GetBalance return ErrUnknownUser if user doesn't exist
-	GetBalance(ctx context.Context, dto *domain.GetBalanceDTO) (domain.Money, error)
+	GetBalance(ctx context.Context, dto *domain.GetBalanceDTO) (domain.Balance, error)
+	// GetReservations return pending debits of the user in dto.Currency, oldest first
+	// return ErrUnknownUser if user doesn't exist
+	GetReservations(ctx context.Context, dto *domain.GetBalanceDTO) ([]domain.Reservation, error)
+	// GetWallets return ErrUnknownUser if user doesn't exist
+	GetWallets(ctx context.Context, dto *domain.GetBalanceDTO) ([]domain.Wallet, error)
+	// GetBalanceAt return balances as of dto.At computed from the latest snapshot and transactions since
+	// return ErrUnknownUser if user doesn't exist
+	GetBalanceAt(ctx context.Context, dto *domain.GetBalanceAtDTO) (domain.BalanceAt, error)
+	// TakeBalanceSnapshots snapshots balances as of at of wallets changed since their last snapshot
+	TakeBalanceSnapshots(ctx context.Context, at time.Time) (int, error)
+	// ArchiveTransactions moves at most limit transactions settled before to archive and return their number.
+	// Balances are snapshotted as of before first
+	ArchiveTransactions(ctx context.Context, before time.Time, limit int) (int, error)
+	// PruneUserEvents removes at most limit user events created before and return their number
+	PruneUserEvents(ctx context.Context, before time.Time, limit int) (int, error)
+	// ReplenishBalance return ErrTransactionAlreadyExists if dto.IdempotencyKey is already used
+	// return ErrUnknownUser if user doesn't exist and dto.CreateUser isn't set
 	ReplenishBalance(ctx context.Context, dto *domain.ReplenishBalanceDTO) error
+	// GetUsedIdempotencyKeys return subset of keys which are already used, archived transactions included
+	GetUsedIdempotencyKeys(ctx context.Context, keys []string) (map[string]bool, error)
 	// ReserveMoney return ErrUnknownUser if user doesn't exist
-	// return ErrNotEnoughMoney if user balance lower than Amount
+	// return ErrUnknownService if service doesn't exist and dto.CreateService isn't set
+	// return ErrServiceInactive if service is inactive
+	// return ErrNotEnoughMoney if user balance lower than Amount with Fee
+	// return ErrCreditLimitExceeded if user has credit limit and it isn't enough
+	// return ErrOrderMismatch if order has other user or currency or has no line with the service and amount
+	// return ErrInvalidOrderTransition if line of the order isn't in CREATED status
+	// return ErrUnknownCurrency if currency is unknown
 	ReserveMoney(ctx context.Context, dto *domain.ReserveMoneyDTO) error
 	//RecognizeRevenue return ErrUnknownTransaction if transaction with given fields doesn't exist
+	// return ErrCurrencyMismatch if reservation is in another currency
+	// return ErrUnknownRecipient if recipient of Payouts doesn't exist
+	// return ErrRecipientInactive if account of recipient is frozen or closed
 	RecognizeRevenue(ctx context.Context, dto *domain.RecognizeRevenueDTO) error
 	GetMonthlyReport(ctx context.Context, dto *domain.GetMonthlyReportDTO) (domain.MonthlyReport, error)
 	// GetHistory return ErrUnknownUser if user doesn't exist
 	GetHistory(ctx context.Context, dto *domain.GetHistoryDTO) (domain.History, error)
 	//CancelTransaction return ErrUnknownTransaction if transaction with given fields doesn't exist
+	// return ErrCurrencyMismatch if reservation is in another currency
 	CancelTransaction(ctx context.Context, dto *domain.CancelTransactionDTO) error
+	// AdjustBalance return ErrUnknownUser if user doesn't exist
+	// return ErrNotEnoughMoney if balance would become negative
+	AdjustBalance(ctx context.Context, dto *domain.AdjustBalanceDTO, description string) error
+
+	// SetAccountStatus return ErrUnknownUser if user doesn't exist
+	// return ErrInvalidStatusTransition if account is closed
+	// return ErrAccountNotEmpty if closing account has balance or reservations
+	SetAccountStatus(ctx context.Context, dto *domain.SetAccountStatusDTO) error
+	// GetAccount return ErrUnknownUser if user doesn't exist
+	GetAccount(ctx context.Context, dto *domain.GetAccountDTO) (domain.Account, error)
+	GetAccountStatusChanges(ctx context.Context, dto *domain.GetAccountDTO) ([]domain.AccountStatusChange, error)
+
+	// SetCreditLimit return ErrUnknownUser if user doesn't exist
+	// return ErrUnknownCurrency if currency is unknown
+	SetCreditLimit(ctx context.Context, dto *domain.SetCreditLimitDTO) error
+	GetCreditLimitChanges(ctx context.Context, dto *domain.GetCreditLimitChangesDTO) ([]domain.CreditLimitChange, error)
+
+	// GrantPromo return ErrUnknownUser if user doesn't exist
+	// return ErrUnknownCurrency if currency is unknown
+	GrantPromo(ctx context.Context, dto *domain.GrantPromoDTO) (domain.PromoGrant, error)
+	GetPromoGrants(ctx context.Context, dto *domain.GetPromoGrantsDTO) ([]domain.PromoGrant, error)
+	// ExpirePromoGrants moves remaining of expired grants to expired and return number of swept grants
+	ExpirePromoGrants(ctx context.Context) (int, error)
+
+	// CreateVelocityRule return ErrUnknownCurrency if currency is unknown
+	CreateVelocityRule(ctx context.Context, dto *domain.CreateVelocityRuleDTO) (domain.VelocityRule, error)
+	// GetVelocityRules return rules of dto.UserId with global rules, all rules if UserId isn't set
+	GetVelocityRules(ctx context.Context, dto *domain.GetVelocityRulesDTO) ([]domain.VelocityRule, error)
+	// DeleteVelocityRule return ErrUnknownVelocityRule if rule doesn't exist
+	DeleteVelocityRule(ctx context.Context, dto *domain.DeleteVelocityRuleDTO) error
+	// LockUserSpending serializes velocity checks of the user until the end of transaction of ctx
+	LockUserSpending(ctx context.Context, userId uint) error
+	// GetSpending return spending of the user during the last window without released canceled and refunded money
+	GetSpending(ctx context.Context, userId uint, currency domain.Currency, window time.Duration) (domain.Spending, error)
+	// RecordSpending records spending of operation. Payments and escrows of the user written next
+	// in the same transaction are linked to it, so their cancel and refund release it
+	RecordSpending(ctx context.Context, userId uint, currency domain.Currency, amount domain.Money, operation string) error
+	// RecordVelocityViolation records violation outside transaction of ctx, so it is kept when that rolls back
+	RecordVelocityViolation(ctx context.Context, violation *domain.VelocityViolation) error
+	GetVelocityViolations(ctx context.Context, dto *domain.GetVelocityViolationsDTO) ([]domain.VelocityViolation, error)
+
+	// CreateOrder return ErrOrderAlreadyExists if order exists
+	// return ErrUnknownUser if user doesn't exist
+	// return ErrUnknownService if service of any line doesn't exist
+	// return ErrServiceInactive if service of any line is inactive
+	CreateOrder(ctx context.Context, dto *domain.CreateOrderDTO) (domain.Order, error)
+	// ReserveOrder reserves lines of one order in one transaction. Unknown order is created with the lines.
+	// Return errors of ReserveMoney
+	// return ErrOrderMismatch if existing order has other lines
+	ReserveOrder(ctx context.Context, lines []domain.ReserveMoneyDTO) (domain.Order, error)
+	// GetOrder return ErrUnknownOrder if order doesn't exist
+	GetOrder(ctx context.Context, dto *domain.GetOrderDTO) (domain.Order, error)
+	// RecognizeOrder recognizes reserved line of dto.ServiceId or all reserved lines
+	// return ErrUnknownOrder if order or line doesn't exist
+	// return ErrInvalidOrderTransition if line or order isn't reserved
+	RecognizeOrder(ctx context.Context, dto *domain.ChangeOrderDTO) (domain.Order, error)
+	// CancelOrder cancels line of dto.ServiceId or all created and reserved lines
+	// return ErrUnknownOrder if order or line doesn't exist
+	// return ErrInvalidOrderTransition if line or order is fulfilled, canceled or refunded
+	CancelOrder(ctx context.Context, dto *domain.ChangeOrderDTO) (domain.Order, error)
+	// RefundOrder returns payment of fulfilled line of dto.ServiceId or all fulfilled lines to balance,
+	// fees aren't refunded
+	// return ErrUnknownOrder if order or line doesn't exist
+	// return ErrInvalidOrderTransition if line or order isn't fulfilled
+	RefundOrder(ctx context.Context, dto *domain.ChangeOrderDTO) (domain.Order, error)
+
+	// CreateSubscription return ErrUnknownUser if user doesn't exist
+	// return ErrUnknownService if service doesn't exist
+	// return ErrUnknownCurrency if currency is unknown
+	CreateSubscription(ctx context.Context, dto *domain.CreateSubscriptionDTO) (domain.Subscription, error)
+	GetSubscriptions(ctx context.Context, dto *domain.GetSubscriptionsDTO) ([]domain.Subscription, error)
+	// CancelSubscription return ErrUnknownSubscription if user has no such subscription
+	// return ErrSubscriptionCanceled if it is already canceled
+	CancelSubscription(ctx context.Context, dto *domain.SubscriptionDTO) (domain.Subscription, error)
+	// ResumeSubscription resets failures, overdue subscription is charged at once
+	// return ErrUnknownSubscription if user has no such subscription
+	// return ErrSubscriptionCanceled if it is canceled
+	ResumeSubscription(ctx context.Context, dto *domain.SubscriptionDTO) (domain.Subscription, error)
+	// ClaimDueSubscriptions return up to limit due active subscriptions and hide them from other callers for lease
+	ClaimDueSubscriptions(ctx context.Context, limit int, lease time.Duration) ([]domain.Subscription, error)
+	// ChargeSubscription pays for the current period with dto.Fee, dto.PromoLimit and dto.Description
+	// return ErrSubscriptionNotDue if subscription isn't active or isn't due
+	// return ErrUnknownService or ErrServiceInactive if service doesn't allow reservations
+	// return ErrNotEnoughMoney or ErrCreditLimitExceeded if balance isn't enough
+	ChargeSubscription(ctx context.Context, subscriptionId uint, dto *domain.ReserveMoneyDTO) error
+	// MarkSubscriptionFailed schedules next attempt at retryAt or pauses subscription if pause is true
+	MarkSubscriptionFailed(ctx context.Context, id uint, reason string, retryAt time.Time, pause bool) error
+
+	// CreateEscrow holds dto.Amount of buyer until escrow is settled
+	// return ErrUnknownUser if buyer doesn't exist
+	// return ErrUnknownRecipient or ErrRecipientInactive if seller can't get money
+	// return ErrNotEnoughMoney or ErrCreditLimitExceeded if balance of buyer isn't enough
+	// return ErrUnknownCurrency if currency is unknown
+	CreateEscrow(ctx context.Context, dto *domain.CreateEscrowDTO) (domain.Escrow, error)
+	// GetEscrow return ErrUnknownEscrow if escrow doesn't exist
+	GetEscrow(ctx context.Context, dto *domain.GetEscrowDTO) (domain.Escrow, error)
+	// GetEscrows return escrows where user is buyer or seller
+	GetEscrows(ctx context.Context, dto *domain.GetEscrowsDTO) ([]domain.Escrow, error)
+	// ReleaseEscrow credits held or disputed escrow to seller
+	// return ErrUnknownEscrow if escrow doesn't exist
+	// return ErrInvalidEscrowTransition if escrow is already settled
+	// return ErrRecipientInactive if account of seller is frozen or closed
+	ReleaseEscrow(ctx context.Context, dto *domain.GetEscrowDTO) (domain.Escrow, error)
+	// RefundEscrow returns held or disputed escrow to buyer
+	// return ErrUnknownEscrow if escrow doesn't exist
+	// return ErrInvalidEscrowTransition if escrow is already settled
+	RefundEscrow(ctx context.Context, dto *domain.GetEscrowDTO) (domain.Escrow, error)
+	// DisputeEscrow return ErrUnknownEscrow if escrow doesn't exist
+	// return ErrInvalidEscrowTransition if escrow isn't held
+	DisputeEscrow(ctx context.Context, dto *domain.DisputeEscrowDTO) (domain.Escrow, error)
+	// GetExpiredEscrows return ids of up to limit held escrows after their expiry
+	GetExpiredEscrows(ctx context.Context, limit int) ([]uint, error)
+	// ExpireEscrow settles expired held escrow by its timeout policy
+	// return ErrEscrowNotExpired if escrow isn't held or isn't expired
+	ExpireEscrow(ctx context.Context, escrowId uint) (domain.Escrow, error)
+
+	// GetAuditLog return entries in order of id
+	GetAuditLog(ctx context.Context, dto *domain.GetAuditLogDTO) ([]domain.AuditEntry, error)
+	// VerifyAuditLog walks the hash chain of audit log
+	VerifyAuditLog(ctx context.Context) (domain.AuditVerification, error)
+	// VerifyTransactionChain walks the hash chain of transactions
+	VerifyTransactionChain(ctx context.Context) (domain.TransactionChainVerification, error)
+	// GetChainRoots return daily roots of the transaction chain in order of day
+	GetChainRoots(ctx context.Context, dto *domain.GetChainRootsDTO) ([]domain.ChainRoot, error)
+
+	// CreateService return ErrServiceAlreadyExists if service exists
+	// return ErrUnknownCurrency if currency is unknown
+	CreateService(ctx context.Context, dto *domain.CreateServiceDTO) (domain.Service, error)
+	// UpdateService records new name in name history
+	// return ErrUnknownService if service doesn't exist
+	// return ErrUnknownCurrency if currency is unknown
+	UpdateService(ctx context.Context, dto *domain.UpdateServiceDTO) (domain.Service, error)
+	// DeactivateService return ErrUnknownService if service doesn't exist
+	DeactivateService(ctx context.Context, dto *domain.GetServiceDTO) error
+	// GetService return ErrUnknownService if service doesn't exist
+	GetService(ctx context.Context, dto *domain.GetServiceDTO) (domain.Service, error)
+	GetServices(ctx context.Context, dto *domain.GetServicesDTO) ([]domain.Service, error)
+	GetServiceNames(ctx context.Context, dto *domain.GetServiceDTO) ([]domain.ServiceName, error)
+
+	// SetFeeSchedule replaces all fee rules of the service
+	SetFeeSchedule(ctx context.Context, dto *domain.SetFeeScheduleDTO) error
+	GetFeeSchedule(ctx context.Context, dto *domain.GetFeeScheduleDTO) ([]domain.FeeRule, error)
+
+	// LoadExchangeRates replaces rates with the same pair and ValidFrom
+	// return ErrUnknownCurrency if rate refers to unknown currency
+	LoadExchangeRates(ctx context.Context, rates []domain.ExchangeRate) error
+	// GetExchangeRates return rates valid at given time
+	GetExchangeRates(ctx context.Context, at time.Time) ([]domain.ExchangeRate, error)
+	// CreateFXQuote return ErrUnknownUser if user doesn't exist
+	// return ErrUnknownRate if there is no rate for currencies
+	CreateFXQuote(ctx context.Context, dto *domain.CreateFXQuoteDTO, ttl time.Duration) (domain.FXQuote, error)
+	// ConvertCurrency return ErrUnknownUser if user doesn't exist
+	// return ErrUnknownRate if there is no rate for currencies
+	// return ErrUnknownQuote if quote doesn't exist, is used or is issued for other user or currencies
+	// return ErrQuoteExpired if quote is expired
+	// return ErrNotEnoughMoney if balance lower than Amount
+	// return ErrAmountTooSmall if converted amount is rounded to zero
+	ConvertCurrency(ctx context.Context, dto *domain.ConvertCurrencyDTO) (domain.Conversion, error)
+
+	CreateWebhook(ctx context.Context, dto *domain.CreateWebhookDTO) (domain.Webhook, error)
+	GetWebhooks(ctx context.Context) ([]domain.Webhook, error)
+	// DeleteWebhook return ErrUnknownWebhook if webhook doesn't exist
+	DeleteWebhook(ctx context.Context, dto *domain.DeleteWebhookDTO) error
+	// ClaimWebhookDeliveries return up to limit due deliveries and hide them from other callers for lease
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domain.WebhookDelivery, error)
+	MarkWebhookDelivered(ctx context.Context, id uint) error
+	// MarkWebhookFailed schedule next attempt after retryIn or move delivery to dead-letter state if dead is true
+	MarkWebhookFailed(ctx context.Context, id uint, reason string, retryIn time.Duration, dead bool) error
+
+	// GetUserEventsAfter return ErrUnknownUser if user doesn't exist
+	GetUserEventsAfter(ctx context.Context, userId uint, afterId uint, limit int) ([]domain.UserEvent, error)
+}
+
+// Notifier delivers committed changes of user transactions
+type Notifier interface {
+	// Run listens for changes until ctx is done
+	Run(ctx context.Context) error
+	// Subscribe return channel of user events. Channel is closed if subscriber falls behind
+	// or connection to the database was lost, so events may be missed and must be reloaded
+	Subscribe(userId uint) (<-chan domain.UserEvent, func())
 }
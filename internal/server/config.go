@@ -4,4 +4,6 @@ type Config struct {
 	Host                string
 	Port                string
 	FileServerDirectory string
+	APITokens           map[string]string
+	TrustedProxies      []string
 }
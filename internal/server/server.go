@@ -24,20 +24,25 @@ type server struct {
 }
 
 func NewServer(config *Config, service service.Service, logger logging.Logger) Server {
-	return &server{
+	handler := v1.NewHandler(&v1.Config{
+		Directory:      config.FileServerDirectory,
+		ServerURI:      fmt.Sprintf("%s:%s", config.Host, config.Port),
+		APITokens:      config.APITokens,
+		TrustedProxies: config.TrustedProxies,
+	}, httprouter.New(), service, logger)
+	s := &server{
 		logger:  logger,
 		service: service,
 		config:  config,
 		httpServer: &http.Server{
-			Addr: fmt.Sprintf("%s:%s", config.Host, config.Port),
-			Handler: v1.NewHandler(&v1.Config{
-				Directory: config.FileServerDirectory,
-				ServerURI: fmt.Sprintf("%s:%s", config.Host, config.Port),
-			}, httprouter.New(), service, logger),
+			Addr:         fmt.Sprintf("%s:%s", config.Host, config.Port),
+			Handler:      handler,
 			ReadTimeout:  15 * time.Second,
 			WriteTimeout: 15 * time.Second,
 		},
 	}
+	s.httpServer.RegisterOnShutdown(handler.Shutdown)
+	return s
 }
 
 func (s *server) ListenAndServe() error {
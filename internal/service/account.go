@@ -0,0 +1,25 @@
+package service
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+func (s *service) SetAccountStatus(ctx context.Context, dto *domain.SetAccountStatusDTO) error {
+	s.logger.Tracef("service.SetAccountStatus(%v, %#v)", ctx, *dto)
+	if err := s.repo.SetAccountStatus(ctx, dto); err != nil {
+		return err
+	}
+	s.logger.Infof("account %d is %s by %q: %s", dto.UserId, dto.Status, dto.Operator, dto.Reason)
+	return nil
+}
+
+func (s *service) GetAccount(ctx context.Context, dto *domain.GetAccountDTO) (domain.Account, error) {
+	s.logger.Tracef("service.GetAccount(%v, %#v)", ctx, *dto)
+	return s.repo.GetAccount(ctx, dto)
+}
+
+func (s *service) GetAccountStatusChanges(ctx context.Context, dto *domain.GetAccountDTO) ([]domain.AccountStatusChange, error) {
+	s.logger.Tracef("service.GetAccountStatusChanges(%v, %#v)", ctx, *dto)
+	return s.repo.GetAccountStatusChanges(ctx, dto)
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"io"
+	"strconv"
+	"time"
+)
+
+var auditLogHeader = []string{"id", "created_at", "actor", "client", "request_id", "source_ip", "operation", "entity",
+	"entity_id", "user_id", "currency", "balance_before", "balance_after", "reserved_before", "reserved_after", "details",
+	"payload_hash", "prev_hash", "hash"}
+
+func (s *service) GetAuditLog(ctx context.Context, dto *domain.GetAuditLogDTO) ([]domain.AuditEntry, error) {
+	s.logger.Tracef("service.GetAuditLog(%v, %#v)", ctx, *dto)
+	if dto.Limit == 0 {
+		dto.Limit = MaxHistoryRowPerRequest
+	}
+	return s.repo.GetAuditLog(ctx, dto)
+}
+
+func (s *service) ExportAuditLog(ctx context.Context, dto *domain.GetAuditLogDTO, w io.Writer) error {
+	s.logger.Tracef("service.ExportAuditLog(%v, %#v)", ctx, *dto)
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Comma = ';'
+	if err := csvWriter.Write(auditLogHeader); err != nil {
+		return err
+	}
+
+	// entries are read by pages, so export doesn't hold the whole log in memory
+	page := *dto
+	page.Limit = MaxHistoryRowPerRequest
+	for {
+		entries, err := s.repo.GetAuditLog(ctx, &page)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			err := csvWriter.Write([]string{
+				strconv.FormatUint(uint64(entry.Id), 10),
+				entry.CreatedAt.Format(time.RFC3339Nano),
+				entry.Actor,
+				entry.Client,
+				entry.RequestId,
+				entry.SourceIP,
+				entry.Operation,
+				entry.Entity,
+				entry.EntityId,
+				strconv.FormatUint(uint64(entry.UserId), 10),
+				string(entry.Currency),
+				entry.BalanceBefore.String(),
+				entry.BalanceAfter.String(),
+				entry.ReservedBefore.String(),
+				entry.ReservedAfter.String(),
+				string(entry.Details),
+				entry.PayloadHash,
+				entry.PrevHash,
+				entry.Hash,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return err
+		}
+		if len(entries) < page.Limit {
+			return nil
+		}
+		page.AfterId = entries[len(entries)-1].Id
+	}
+}
+
+func (s *service) VerifyAuditLog(ctx context.Context) (domain.AuditVerification, error) {
+	s.logger.Tracef("service.VerifyAuditLog(%v)", ctx)
+	verification, err := s.repo.VerifyAuditLog(ctx)
+	if err != nil {
+		return domain.AuditVerification{}, err
+	}
+	if verification.BrokenId != 0 {
+		s.logger.Errorf("audit log is broken at entry %d", verification.BrokenId)
+	}
+	return verification, nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+func (s *service) ExecuteBatch(ctx context.Context, dto *domain.BatchDTO, onResult func(domain.BatchResult) error) error {
+	s.logger.Tracef("service.ExecuteBatch(%v, %s, %d operations)", ctx, dto.Mode, len(dto.Operations))
+	if dto.Mode == domain.BatchModeAtomic {
+		return s.executeAtomicBatch(ctx, dto, onResult)
+	}
+
+	for i, op := range dto.Operations {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		result := domain.BatchResult{Index: i, Ok: true}
+		if err := s.executeOperation(ctx, &op); err != nil {
+			result.Ok = false
+			result.Err = err
+		}
+		if err := onResult(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *service) executeAtomicBatch(ctx context.Context, dto *domain.BatchDTO, onResult func(domain.BatchResult) error) error {
+	for i, op := range dto.Operations {
+		if err := op.DTO.Validate(); err != nil {
+			return &BatchError{Index: i, Err: fmt.Errorf("%w: %v", ErrInvalidOperation, err)}
+		}
+	}
+
+	err := s.repo.InTransaction(ctx, func(ctx context.Context) error {
+		for i, op := range dto.Operations {
+			if err := s.executeOperation(ctx, &op); err != nil {
+				return &BatchError{Index: i, Err: err}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range dto.Operations {
+		if err := onResult(domain.BatchResult{Index: i, Ok: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *service) executeOperation(ctx context.Context, op *domain.BatchOperation) error {
+	if err := op.DTO.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+	}
+
+	switch dto := op.DTO.(type) {
+	case *domain.ReplenishBalanceDTO:
+		return s.ReplenishBalance(ctx, dto)
+	case *domain.ReserveMoneyDTO:
+		return s.ReserveMoney(ctx, dto)
+	case *domain.RecognizeRevenueDTO:
+		return s.RecognizeRevenue(ctx, dto)
+	case *domain.CancelTransactionDTO:
+		return s.CancelTransaction(ctx, dto)
+	}
+	return fmt.Errorf("%w: unknown operation type %q", ErrInvalidOperation, op.Type)
+}
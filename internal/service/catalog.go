@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+func (s *service) CreateService(ctx context.Context, dto *domain.CreateServiceDTO) (domain.Service, error) {
+	s.logger.Tracef("service.CreateService(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
+	return s.repo.CreateService(ctx, dto)
+}
+
+func (s *service) UpdateService(ctx context.Context, dto *domain.UpdateServiceDTO) (domain.Service, error) {
+	s.logger.Tracef("service.UpdateService(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
+	return s.repo.UpdateService(ctx, dto)
+}
+
+func (s *service) DeactivateService(ctx context.Context, dto *domain.GetServiceDTO) error {
+	s.logger.Tracef("service.DeactivateService(%v, %#v)", ctx, *dto)
+	return s.repo.DeactivateService(ctx, dto)
+}
+
+func (s *service) GetService(ctx context.Context, dto *domain.GetServiceDTO) (domain.Service, error) {
+	s.logger.Tracef("service.GetService(%v, %#v)", ctx, *dto)
+	return s.repo.GetService(ctx, dto)
+}
+
+func (s *service) GetServices(ctx context.Context, dto *domain.GetServicesDTO) ([]domain.Service, error) {
+	s.logger.Tracef("service.GetServices(%v, %#v)", ctx, *dto)
+	return s.repo.GetServices(ctx, dto)
+}
+
+func (s *service) GetServiceNames(ctx context.Context, dto *domain.GetServiceDTO) ([]domain.ServiceName, error) {
+	s.logger.Tracef("service.GetServiceNames(%v, %#v)", ctx, *dto)
+	return s.repo.GetServiceNames(ctx, dto)
+}
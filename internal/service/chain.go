@@ -0,0 +1,26 @@
+package service
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+func (s *service) VerifyTransactionChain(ctx context.Context) (domain.TransactionChainVerification, error) {
+	s.logger.Tracef("service.VerifyTransactionChain(%v)", ctx)
+	verification, err := s.repo.VerifyTransactionChain(ctx)
+	if err != nil {
+		return domain.TransactionChainVerification{}, err
+	}
+	if verification.BrokenSeq != 0 {
+		s.logger.Errorf("transaction chain is broken at link %d, transaction %d", verification.BrokenSeq, verification.BrokenId)
+	}
+	if verification.Unchained != 0 {
+		s.logger.Errorf("%d transactions are out of chain", verification.Unchained)
+	}
+	return verification, nil
+}
+
+func (s *service) GetChainRoots(ctx context.Context, dto *domain.GetChainRootsDTO) ([]domain.ChainRoot, error) {
+	s.logger.Tracef("service.GetChainRoots(%v, %#v)", ctx, *dto)
+	return s.repo.GetChainRoots(ctx, dto)
+}
@@ -1,5 +1,17 @@
 package service
 
+import "time"
+
 type Config struct {
 	FileServerDirectory string
+	// FXQuoteTTL is how long quoted rate is locked, DefaultFXQuoteTTL if it isn't set
+	FXQuoteTTL time.Duration
+	// PromoMaxBasisPoints is max share of reserved amount paid with promo credits, 10000 is 100%
+	PromoMaxBasisPoints int64
+	// PromoExcludedServices can't be paid with promo credits
+	PromoExcludedServices []uint
+	// ImplicitUserCreation creates unknown users on replenishment instead of rejecting it
+	ImplicitUserCreation bool
+	// ImplicitServiceCreation adds unknown services to catalog on reservation instead of rejecting it
+	ImplicitServiceCreation bool
 }
@@ -1,5 +1,10 @@
 package service
 
+import "time"
+
 const (
-	MaxHistoryRowPerRequest = 100
+	MaxHistoryRowPerRequest   = 100
+	MaxReplayedEventsPerQuery = 500
+	MaxImportLines            = 100000
+	DefaultFXQuoteTTL         = 30 * time.Second
 )
@@ -0,0 +1,17 @@
+package service
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+func (s *service) SetCreditLimit(ctx context.Context, dto *domain.SetCreditLimitDTO) error {
+	s.logger.Tracef("service.SetCreditLimit(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
+	return s.repo.SetCreditLimit(ctx, dto)
+}
+
+func (s *service) GetCreditLimitChanges(ctx context.Context, dto *domain.GetCreditLimitChangesDTO) ([]domain.CreditLimitChange, error) {
+	s.logger.Tracef("service.GetCreditLimitChanges(%v, %#v)", ctx, *dto)
+	return s.repo.GetCreditLimitChanges(ctx, dto)
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"fmt"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+var (
+	ErrInvalidOperation      = fmt.Errorf("invalid operation")
+	ErrInvalidImportFile     = fmt.Errorf("invalid import file")
+	ErrImportHasInvalidLines = fmt.Errorf("import has invalid lines")
+	ErrInvalidRatesFile      = fmt.Errorf("invalid rates file")
+	ErrVelocityLimitExceeded = fmt.Errorf("velocity limit exceeded")
+	ErrSplitsExceedAmount    = fmt.Errorf("splits exceed amount")
+)
+
+// BatchError is returned by atomic batch. Index points to the failed operation
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// VelocityError is returned when operation violates velocity rule. Used is spending
+// in window of the rule before the operation
+type VelocityError struct {
+	Rule domain.VelocityRule
+	Used string
+}
+
+func (e *VelocityError) Error() string {
+	if e.Rule.Metric == domain.VelocityMetricCount {
+		return fmt.Sprintf("%v: no more than %d operations in %d seconds",
+			ErrVelocityLimitExceeded, e.Rule.MaxCount, e.Rule.WindowSeconds)
+	}
+	return fmt.Sprintf("%v: no more than %s %s in %d seconds",
+		ErrVelocityLimitExceeded, e.Rule.MaxAmount, e.Rule.Currency, e.Rule.WindowSeconds)
+}
+
+func (e *VelocityError) Unwrap() error {
+	return ErrVelocityLimitExceeded
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+func (s *service) CreateEscrow(ctx context.Context, dto *domain.CreateEscrowDTO) (domain.Escrow, error) {
+	s.logger.Tracef("service.CreateEscrow(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
+	var escrow domain.Escrow
+	err := s.withVelocityCheck(ctx, dto.BuyerId, dto.Currency, dto.Amount, domain.SpendingOperationEscrow,
+		func(ctx context.Context) error {
+			var err error
+			escrow, err = s.repo.CreateEscrow(ctx, dto)
+			return err
+		})
+	return escrow, err
+}
+
+func (s *service) GetEscrow(ctx context.Context, dto *domain.GetEscrowDTO) (domain.Escrow, error) {
+	s.logger.Tracef("service.GetEscrow(%v, %#v)", ctx, *dto)
+	return s.repo.GetEscrow(ctx, dto)
+}
+
+func (s *service) GetEscrows(ctx context.Context, dto *domain.GetEscrowsDTO) ([]domain.Escrow, error) {
+	s.logger.Tracef("service.GetEscrows(%v, %#v)", ctx, *dto)
+	return s.repo.GetEscrows(ctx, dto)
+}
+
+func (s *service) ReleaseEscrow(ctx context.Context, dto *domain.GetEscrowDTO) (domain.Escrow, error) {
+	s.logger.Tracef("service.ReleaseEscrow(%v, %#v)", ctx, *dto)
+	return s.repo.ReleaseEscrow(ctx, dto)
+}
+
+func (s *service) RefundEscrow(ctx context.Context, dto *domain.GetEscrowDTO) (domain.Escrow, error) {
+	s.logger.Tracef("service.RefundEscrow(%v, %#v)", ctx, *dto)
+	return s.repo.RefundEscrow(ctx, dto)
+}
+
+func (s *service) DisputeEscrow(ctx context.Context, dto *domain.DisputeEscrowDTO) (domain.Escrow, error) {
+	s.logger.Tracef("service.DisputeEscrow(%v, %#v)", ctx, *dto)
+	return s.repo.DisputeEscrow(ctx, dto)
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+func (s *service) SubscribeUserEvents(ctx context.Context, dto *domain.SubscribeUserEventsDTO) (<-chan domain.UserEvent, error) {
+	s.logger.Tracef("service.SubscribeUserEvents(%v, %#v)", ctx, *dto)
+	// subscribe before loading missed events so nothing is lost in between
+	live, unsubscribe := s.notifier.Subscribe(dto.UserId)
+
+	var missed []domain.UserEvent
+	if dto.LastEventId == 0 {
+		// only check that user exists
+		if _, err := s.repo.GetUserEventsAfter(ctx, dto.UserId, 0, 0); err != nil {
+			unsubscribe()
+			return nil, err
+		}
+	}
+	for afterId := dto.LastEventId; afterId > 0; {
+		events, err := s.repo.GetUserEventsAfter(ctx, dto.UserId, afterId, MaxReplayedEventsPerQuery)
+		if err != nil {
+			unsubscribe()
+			return nil, err
+		}
+		missed = append(missed, events...)
+		if len(events) < MaxReplayedEventsPerQuery {
+			break
+		}
+		afterId = events[len(events)-1].Id
+	}
+
+	out := make(chan domain.UserEvent)
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+
+		lastId := dto.LastEventId
+		for _, event := range missed {
+			lastId = event.Id
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		for {
+			select {
+			case event, ok := <-live:
+				if !ok {
+					return
+				}
+				// change could be committed while missed events were loading
+				if event.Id <= lastId {
+					continue
+				}
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+func (s *service) SetFeeSchedule(ctx context.Context, dto *domain.SetFeeScheduleDTO) error {
+	s.logger.Tracef("service.SetFeeSchedule(%v, %d, %d rules)", ctx, dto.ServiceId, len(dto.Rules))
+	for i := range dto.Rules {
+		dto.Rules[i].ServiceId = dto.ServiceId
+		setDefaultCurrency(&dto.Rules[i].Currency)
+	}
+	return s.repo.SetFeeSchedule(ctx, dto)
+}
+
+func (s *service) GetFeeSchedule(ctx context.Context, dto *domain.GetFeeScheduleDTO) ([]domain.FeeRule, error) {
+	s.logger.Tracef("service.GetFeeSchedule(%v, %#v)", ctx, *dto)
+	return s.repo.GetFeeSchedule(ctx, dto)
+}
+
+// computeFee applies tier of rules with the greatest MinAmount not exceeding amount.
+// Rules in other currencies are ignored, amount without tier has no fee
+func computeFee(rules []domain.FeeRule, currency domain.Currency, amount domain.Money) (domain.Money, error) {
+	var tier *domain.FeeRule
+	for i, rule := range rules {
+		if rule.Currency != currency || rule.MinAmount > amount {
+			continue
+		}
+		if tier == nil || rule.MinAmount > tier.MinAmount {
+			tier = &rules[i]
+		}
+	}
+	if tier == nil {
+		return 0, nil
+	}
+
+	fee, err := amount.MulRatio(tier.BasisPoints, domain.MaxBasisPoints)
+	if err != nil {
+		return 0, err
+	}
+	fee, err = fee.Add(tier.Fixed)
+	if err != nil {
+		return 0, err
+	}
+	if fee < tier.MinFee {
+		fee = tier.MinFee
+	}
+	if tier.MaxFee != 0 && fee > tier.MaxFee {
+		fee = tier.MaxFee
+	}
+	return fee, nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"io"
+	"strings"
+	"time"
+)
+
+func (s *service) LoadExchangeRates(ctx context.Context, dto *domain.LoadExchangeRatesDTO) error {
+	s.logger.Tracef("service.LoadExchangeRates(%v, %d rates)", ctx, len(dto.Rates))
+	return s.repo.LoadExchangeRates(ctx, dto.Rates)
+}
+
+func (s *service) GetExchangeRates(ctx context.Context) ([]domain.ExchangeRate, error) {
+	s.logger.Tracef("service.GetExchangeRates(%v)", ctx)
+	return s.repo.GetExchangeRates(ctx, time.Now())
+}
+
+func (s *service) CreateFXQuote(ctx context.Context, dto *domain.CreateFXQuoteDTO) (domain.FXQuote, error) {
+	s.logger.Tracef("service.CreateFXQuote(%v, %#v)", ctx, *dto)
+	ttl := s.config.FXQuoteTTL
+	if ttl <= 0 {
+		ttl = DefaultFXQuoteTTL
+	}
+	return s.repo.CreateFXQuote(ctx, dto, ttl)
+}
+
+func (s *service) ConvertCurrency(ctx context.Context, dto *domain.ConvertCurrencyDTO) (domain.Conversion, error) {
+	s.logger.Tracef("service.ConvertCurrency(%v, %#v)", ctx, *dto)
+	var conversion domain.Conversion
+	err := s.withVelocityCheck(ctx, dto.UserId, dto.From, dto.Amount, domain.SpendingOperationConvert,
+		func(ctx context.Context) error {
+			var err error
+			conversion, err = s.repo.ConvertCurrency(ctx, dto)
+			return err
+		})
+	return conversion, err
+}
+
+// ParseExchangeRates parses CSV with columns base, quote, rate, valid_from and optional valid_to.
+// Times are in RFC 3339 format. Header line is skipped if present
+func ParseExchangeRates(data []byte) ([]domain.ExchangeRate, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var rates []domain.ExchangeRate
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRatesFile, err)
+		}
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "base") {
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		rate, err := parseExchangeRate(record)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidRatesFile, line, err)
+		}
+		rates = append(rates, rate)
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("%w: no rates", ErrInvalidRatesFile)
+	}
+	return rates, nil
+}
+
+func parseExchangeRate(record []string) (domain.ExchangeRate, error) {
+	if len(record) < 4 || len(record) > 5 {
+		return domain.ExchangeRate{}, fmt.Errorf("expected 4-5 fields, got %d", len(record))
+	}
+	for i := range record {
+		record[i] = strings.TrimSpace(record[i])
+	}
+
+	rate := domain.ExchangeRate{
+		Base:  domain.Currency(strings.ToUpper(record[0])),
+		Quote: domain.Currency(strings.ToUpper(record[1])),
+		Rate:  record[2],
+	}
+	var err error
+	rate.ValidFrom, err = time.Parse(time.RFC3339, record[3])
+	if err != nil {
+		return domain.ExchangeRate{}, fmt.Errorf("invalid valid_from: %v", err)
+	}
+	if len(record) == 5 && record[4] != "" {
+		validTo, err := time.Parse(time.RFC3339, record[4])
+		if err != nil {
+			return domain.ExchangeRate{}, fmt.Errorf("invalid valid_to: %v", err)
+		}
+		rate.ValidTo = &validTo
+	}
+	if err := rate.Validate(); err != nil {
+		return domain.ExchangeRate{}, err
+	}
+	return rate, nil
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"io"
+	"strconv"
+	"strings"
+)
+
+func (s *service) ImportReplenishments(ctx context.Context, dto *domain.ImportReplenishmentsDTO) (domain.ImportReport, error) {
+	s.logger.Tracef("service.ImportReplenishments(%v, %d bytes, commit=%v)", ctx, len(dto.Data), dto.Commit)
+	setDefaultCurrency(&dto.Currency)
+	lines, err := parseReplenishments(dto)
+	if err != nil {
+		return domain.ImportReport{}, err
+	}
+	report := domain.ImportReport{Lines: lines}
+
+	var keys []string
+	for _, line := range lines {
+		if line.Status == domain.ImportLineValid {
+			keys = append(keys, line.IdempotencyKey)
+		}
+	}
+	used, err := s.repo.GetUsedIdempotencyKeys(ctx, keys)
+	if err != nil {
+		return domain.ImportReport{}, err
+	}
+	for i, line := range lines {
+		if line.Status == domain.ImportLineValid && used[line.IdempotencyKey] {
+			lines[i].Status = domain.ImportLineDuplicate
+		}
+	}
+	countImportLines(&report)
+
+	if !dto.Commit {
+		return report, nil
+	}
+	if report.Invalid > 0 {
+		return report, ErrImportHasInvalidLines
+	}
+
+	for i, line := range lines {
+		if line.Status != domain.ImportLineValid {
+			continue
+		}
+		err := s.ReplenishBalance(ctx, &domain.ReplenishBalanceDTO{
+			UserId:         line.UserId,
+			Amount:         line.Amount,
+			Currency:       dto.Currency,
+			Description:    line.Description,
+			IdempotencyKey: line.IdempotencyKey,
+		})
+		if errors.Is(err, repository.ErrTransactionAlreadyExists) {
+			lines[i].Status = domain.ImportLineDuplicate
+		} else if err != nil {
+			s.logger.Errorf("Import of line %d failed: %v", line.Line, err)
+			lines[i].Status = domain.ImportLineFailed
+			lines[i].Error = err.Error()
+		} else {
+			lines[i].Status = domain.ImportLineImported
+		}
+	}
+	report.Committed = true
+	countImportLines(&report)
+	return report, nil
+}
+
+// parseReplenishments parses CSV with columns user_id, amount, description and optional key.
+// Header line is skipped if present. Lines without key get key derived from their content
+// and number of equal lines above, so re-exported or appended file produces the same keys
+// for lines imported before
+func parseReplenishments(dto *domain.ImportReplenishmentsDTO) ([]domain.ImportLine, error) {
+	reader := csv.NewReader(bytes.NewReader(dto.Data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if dto.Comma != 0 {
+		reader.Comma = dto.Comma
+	}
+
+	var lines []domain.ImportLine
+	occurrences := make(map[string]int)
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+		}
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "user_id") {
+			continue
+		}
+		if len(lines) == MaxImportLines {
+			return nil, fmt.Errorf("%w: more than %d lines", ErrInvalidImportFile, MaxImportLines)
+		}
+		lineNumber, _ := reader.FieldPos(0)
+		line := parseReplenishment(record, dto.Currency)
+		line.Line = lineNumber
+
+		if line.Status == domain.ImportLineValid && line.IdempotencyKey == "" {
+			content := fmt.Sprintf("%d;%s;%s", line.UserId, line.Amount.String(), line.Description)
+			if dto.Currency != domain.DefaultCurrency {
+				// keys of files imported before currencies were introduced stay the same
+				content += ";" + string(dto.Currency)
+			}
+			occurrences[content]++
+			sum := sha256.Sum256([]byte(fmt.Sprintf("%s;%d", content, occurrences[content])))
+			line.IdempotencyKey = "csv:" + hex.EncodeToString(sum[:])
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+func parseReplenishment(record []string, currency domain.Currency) domain.ImportLine {
+	line := domain.ImportLine{Status: domain.ImportLineInvalid}
+	if len(record) < 2 || len(record) > 4 {
+		line.Error = fmt.Sprintf("expected 2-4 fields, got %d", len(record))
+		return line
+	}
+
+	userId, err := strconv.ParseUint(strings.TrimSpace(record[0]), 10, 64)
+	if err != nil {
+		line.Error = "invalid user_id"
+		return line
+	}
+	line.UserId = uint(userId)
+
+	line.Amount, err = domain.StringToMoney(strings.TrimSpace(record[1]))
+	if err != nil {
+		line.Error = fmt.Sprintf("invalid amount: %v", err)
+		return line
+	}
+	if len(record) > 2 {
+		line.Description = strings.TrimSpace(record[2])
+	}
+	if len(record) > 3 && strings.TrimSpace(record[3]) != "" {
+		line.IdempotencyKey = "csv:" + strings.TrimSpace(record[3])
+	}
+
+	err = domain.ReplenishBalanceDTO{
+		UserId:         line.UserId,
+		Amount:         line.Amount,
+		Currency:       currency,
+		Description:    line.Description,
+		IdempotencyKey: line.IdempotencyKey,
+	}.Validate()
+	if err != nil {
+		line.Error = err.Error()
+		return line
+	}
+	line.Status = domain.ImportLineValid
+	return line
+}
+
+func countImportLines(report *domain.ImportReport) {
+	report.Total = len(report.Lines)
+	report.Valid, report.Invalid, report.Duplicates, report.Imported, report.Failed = 0, 0, 0, 0, 0
+	for _, line := range report.Lines {
+		switch line.Status {
+		case domain.ImportLineValid:
+			report.Valid++
+		case domain.ImportLineInvalid:
+			report.Invalid++
+		case domain.ImportLineDuplicate:
+			report.Duplicates++
+		case domain.ImportLineImported:
+			report.Imported++
+		case domain.ImportLineFailed:
+			report.Failed++
+		}
+	}
+}
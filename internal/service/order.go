@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+func (s *service) CreateOrder(ctx context.Context, dto *domain.CreateOrderDTO) (domain.Order, error) {
+	s.logger.Tracef("service.CreateOrder(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
+	return s.repo.CreateOrder(ctx, dto)
+}
+
+func (s *service) ReserveOrder(ctx context.Context, dto *domain.ReserveOrderDTO) (domain.Order, error) {
+	s.logger.Tracef("service.ReserveOrder(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
+	lines := make([]domain.ReserveMoneyDTO, 0, len(dto.Lines))
+	var total domain.Money
+	for _, line := range dto.Lines {
+		lineDTO := domain.ReserveMoneyDTO{
+			UserId:      dto.UserId,
+			Amount:      line.Amount,
+			Currency:    dto.Currency,
+			ServiceId:   line.ServiceId,
+			OrderId:     dto.OrderId,
+			Description: dto.Description,
+			ServiceName: line.ServiceName,
+		}
+		if err := s.prepareReservation(ctx, &lineDTO); err != nil {
+			return domain.Order{}, err
+		}
+		lines = append(lines, lineDTO)
+		total += line.Amount
+	}
+
+	var order domain.Order
+	err := s.withVelocityCheck(ctx, dto.UserId, dto.Currency, total, domain.SpendingOperationReserve,
+		func(ctx context.Context) error {
+			var err error
+			order, err = s.repo.ReserveOrder(ctx, lines)
+			return err
+		})
+	return order, err
+}
+
+func (s *service) GetOrder(ctx context.Context, dto *domain.GetOrderDTO) (domain.Order, error) {
+	s.logger.Tracef("service.GetOrder(%v, %#v)", ctx, *dto)
+	return s.repo.GetOrder(ctx, dto)
+}
+
+func (s *service) RecognizeOrder(ctx context.Context, dto *domain.ChangeOrderDTO) (domain.Order, error) {
+	s.logger.Tracef("service.RecognizeOrder(%v, %#v)", ctx, *dto)
+	return s.repo.RecognizeOrder(ctx, dto)
+}
+
+func (s *service) CancelOrder(ctx context.Context, dto *domain.ChangeOrderDTO) (domain.Order, error) {
+	s.logger.Tracef("service.CancelOrder(%v, %#v)", ctx, *dto)
+	return s.repo.CancelOrder(ctx, dto)
+}
+
+func (s *service) RefundOrder(ctx context.Context, dto *domain.ChangeOrderDTO) (domain.Order, error) {
+	s.logger.Tracef("service.RefundOrder(%v, %#v)", ctx, *dto)
+	if dto.Description == "" {
+		dto.Description = fmt.Sprintf("Возврат средств по заказу %d", dto.OrderId)
+	}
+	return s.repo.RefundOrder(ctx, dto)
+}
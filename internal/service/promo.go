@@ -0,0 +1,30 @@
+package service
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+func (s *service) GrantPromo(ctx context.Context, dto *domain.GrantPromoDTO) (domain.PromoGrant, error) {
+	s.logger.Tracef("service.GrantPromo(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
+	if dto.Description == "" {
+		dto.Description = "Промо-начисление"
+	}
+	return s.repo.GrantPromo(ctx, dto)
+}
+
+func (s *service) GetPromoGrants(ctx context.Context, dto *domain.GetPromoGrantsDTO) ([]domain.PromoGrant, error) {
+	s.logger.Tracef("service.GetPromoGrants(%v, %#v)", ctx, *dto)
+	return s.repo.GetPromoGrants(ctx, dto)
+}
+
+// promoLimit return max part of amount which may be paid with promo credits for the service
+func (s *service) promoLimit(serviceId uint, amount domain.Money) (domain.Money, error) {
+	for _, excluded := range s.config.PromoExcludedServices {
+		if excluded == serviceId {
+			return 0, nil
+		}
+	}
+	return amount.MulRatio(s.config.PromoMaxBasisPoints, domain.MaxBasisPoints)
+}
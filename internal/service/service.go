@@ -7,44 +7,182 @@ import (
 	"github.com/manimadzis/avito-job/internal/domain"
 	"github.com/manimadzis/avito-job/internal/repository"
 	"github.com/manimadzis/avito-job/pkg/logging"
+	"io"
 	"os"
 	"path/filepath"
 )
 
 type Service interface {
-	GetBalance(ctx context.Context, dto *domain.GetBalanceDTO) (domain.Money, error)
+	// CreateUser creates user explicitly. Replenishment creates unknown user only with ImplicitUserCreation
+	CreateUser(ctx context.Context, dto *domain.CreateUserDTO) (domain.User, error)
+	GetUser(ctx context.Context, dto *domain.GetUserDTO) (domain.User, error)
+	// GetBalance return balance in dto.Currency, DefaultCurrency if it isn't set.
+	// Open reservations are included if dto.Reservations is set
+	GetBalance(ctx context.Context, dto *domain.GetBalanceDTO) (domain.Balance, error)
+	GetWallets(ctx context.Context, dto *domain.GetBalanceDTO) ([]domain.Wallet, error)
+	// GetBalanceAt return balances in dto.Currency as of dto.At, DefaultCurrency if it isn't set
+	GetBalanceAt(ctx context.Context, dto *domain.GetBalanceAtDTO) (domain.BalanceAt, error)
 	GetMonthlyReportPath(ctx context.Context, dto *domain.GetMonthlyReportDTO) (string, error)
+	GetMonthlyReport(ctx context.Context, dto *domain.GetMonthlyReportDTO) (domain.MonthlyReport, error)
 	ReplenishBalance(ctx context.Context, dto *domain.ReplenishBalanceDTO) error
 	GetHistory(ctx context.Context, dto *domain.GetHistoryDTO) (domain.History, error)
+	// ReserveMoney reserves Amount and fee computed by fee schedule of the service.
+	// Unknown service is added to catalog only with ImplicitServiceCreation.
+	// Promo credits are spent before real money within PromoMaxBasisPoints of Amount.
+	// Return *VelocityError if reservation violates velocity rule
 	ReserveMoney(ctx context.Context, dto *domain.ReserveMoneyDTO) error
+	// RecognizeRevenue credits Splits to recipients together with recognition, the rest of Amount
+	// is revenue of the service. Return ErrSplitsExceedAmount if splits take more than Amount
 	RecognizeRevenue(ctx context.Context, dto *domain.RecognizeRevenueDTO) error
 	CancelTransaction(ctx context.Context, dto *domain.CancelTransactionDTO) error
+	// AdjustBalance changes balance by signed dto.Amount. Used by support staff.
+	// Refunds are allowed for frozen accounts
+	AdjustBalance(ctx context.Context, dto *domain.AdjustBalanceDTO) error
+	// SetAccountStatus freezes, unfreezes or closes account and records the change in audit trail.
+	// Account can be closed only without balance and reservations
+	SetAccountStatus(ctx context.Context, dto *domain.SetAccountStatusDTO) error
+	GetAccount(ctx context.Context, dto *domain.GetAccountDTO) (domain.Account, error)
+	GetAccountStatusChanges(ctx context.Context, dto *domain.GetAccountDTO) ([]domain.AccountStatusChange, error)
+	// SetCreditLimit changes credit limit and records the change in audit trail
+	SetCreditLimit(ctx context.Context, dto *domain.SetCreditLimitDTO) error
+	GetCreditLimitChanges(ctx context.Context, dto *domain.GetCreditLimitChangesDTO) ([]domain.CreditLimitChange, error)
+	// GrantPromo grants promo credits which expire at dto.ExpiresAt
+	GrantPromo(ctx context.Context, dto *domain.GrantPromoDTO) (domain.PromoGrant, error)
+	GetPromoGrants(ctx context.Context, dto *domain.GetPromoGrantsDTO) ([]domain.PromoGrant, error)
+	// CreateOrder creates order which is reserved later by reservations of its lines
+	CreateOrder(ctx context.Context, dto *domain.CreateOrderDTO) (domain.Order, error)
+	// ReserveOrder reserves all lines of the order or none of them. Every line is reserved as by
+	// ReserveMoney, velocity rules are checked against the total amount
+	ReserveOrder(ctx context.Context, dto *domain.ReserveOrderDTO) (domain.Order, error)
+	GetOrder(ctx context.Context, dto *domain.GetOrderDTO) (domain.Order, error)
+	// RecognizeOrder recognizes revenue of reserved line or of all reserved lines without repeating their fields
+	RecognizeOrder(ctx context.Context, dto *domain.ChangeOrderDTO) (domain.Order, error)
+	// CancelOrder cancels created or reserved line or all such lines, reservations are returned to balance
+	CancelOrder(ctx context.Context, dto *domain.ChangeOrderDTO) (domain.Order, error)
+	// RefundOrder returns payment of fulfilled line or of all fulfilled lines to balance. Fees aren't refunded
+	RefundOrder(ctx context.Context, dto *domain.ChangeOrderDTO) (domain.Order, error)
+	// CreateSubscription creates subscription which is charged by scheduler every period
+	CreateSubscription(ctx context.Context, dto *domain.CreateSubscriptionDTO) (domain.Subscription, error)
+	GetSubscriptions(ctx context.Context, dto *domain.GetSubscriptionsDTO) ([]domain.Subscription, error)
+	CancelSubscription(ctx context.Context, dto *domain.SubscriptionDTO) (domain.Subscription, error)
+	// ResumeSubscription resumes paused subscription, overdue subscription is charged at once
+	ResumeSubscription(ctx context.Context, dto *domain.SubscriptionDTO) (domain.Subscription, error)
+	// ChargeSubscription pays for the current period of due subscription with fee by fee schedule
+	// of the service. Return *VelocityError if charge violates velocity rule
+	ChargeSubscription(ctx context.Context, subscription *domain.Subscription) error
+	// CreateEscrow holds money of buyer until it is released to seller, refunded or settled by timeout policy.
+	// Return *VelocityError if escrow violates velocity rule
+	CreateEscrow(ctx context.Context, dto *domain.CreateEscrowDTO) (domain.Escrow, error)
+	GetEscrow(ctx context.Context, dto *domain.GetEscrowDTO) (domain.Escrow, error)
+	GetEscrows(ctx context.Context, dto *domain.GetEscrowsDTO) ([]domain.Escrow, error)
+	ReleaseEscrow(ctx context.Context, dto *domain.GetEscrowDTO) (domain.Escrow, error)
+	RefundEscrow(ctx context.Context, dto *domain.GetEscrowDTO) (domain.Escrow, error)
+	// DisputeEscrow freezes held escrow, it isn't settled by timeout until it is released or refunded explicitly
+	DisputeEscrow(ctx context.Context, dto *domain.DisputeEscrowDTO) (domain.Escrow, error)
+	CreateService(ctx context.Context, dto *domain.CreateServiceDTO) (domain.Service, error)
+	// UpdateService replaces all fields of the service. Name changes are kept in name history
+	UpdateService(ctx context.Context, dto *domain.UpdateServiceDTO) (domain.Service, error)
+	// DeactivateService rejects further reservations. Services are never deleted to keep reports
+	DeactivateService(ctx context.Context, dto *domain.GetServiceDTO) error
+	GetService(ctx context.Context, dto *domain.GetServiceDTO) (domain.Service, error)
+	GetServices(ctx context.Context, dto *domain.GetServicesDTO) ([]domain.Service, error)
+	GetServiceNames(ctx context.Context, dto *domain.GetServiceDTO) ([]domain.ServiceName, error)
+	SetFeeSchedule(ctx context.Context, dto *domain.SetFeeScheduleDTO) error
+	GetFeeSchedule(ctx context.Context, dto *domain.GetFeeScheduleDTO) ([]domain.FeeRule, error)
+	LoadExchangeRates(ctx context.Context, dto *domain.LoadExchangeRatesDTO) error
+	// GetExchangeRates return currently valid rates
+	GetExchangeRates(ctx context.Context) ([]domain.ExchangeRate, error)
+	// CreateFXQuote locks current rate for FXQuoteTTL
+	CreateFXQuote(ctx context.Context, dto *domain.CreateFXQuoteDTO) (domain.FXQuote, error)
+	// ConvertCurrency moves money between wallets of the same user by quoted or current rate.
+	// Return *VelocityError if conversion violates velocity rule
+	ConvertCurrency(ctx context.Context, dto *domain.ConvertCurrencyDTO) (domain.Conversion, error)
+	// CreateVelocityRule creates rule for dto.UserId or global rule if it isn't set
+	CreateVelocityRule(ctx context.Context, dto *domain.CreateVelocityRuleDTO) (domain.VelocityRule, error)
+	GetVelocityRules(ctx context.Context, dto *domain.GetVelocityRulesDTO) ([]domain.VelocityRule, error)
+	DeleteVelocityRule(ctx context.Context, dto *domain.DeleteVelocityRuleDTO) error
+	// GetVelocityViolations return the latest violations first
+	GetVelocityViolations(ctx context.Context, dto *domain.GetVelocityViolationsDTO) ([]domain.VelocityViolation, error)
+	CreateWebhook(ctx context.Context, dto *domain.CreateWebhookDTO) (domain.Webhook, error)
+	GetWebhooks(ctx context.Context) ([]domain.Webhook, error)
+	DeleteWebhook(ctx context.Context, dto *domain.DeleteWebhookDTO) error
+	// SubscribeUserEvents return events after dto.LastEventId followed by live events.
+	// Channel is closed when ctx is done or events can't be delivered anymore
+	SubscribeUserEvents(ctx context.Context, dto *domain.SubscribeUserEventsDTO) (<-chan domain.UserEvent, error)
+	// ExecuteBatch calls onResult for every operation in order. Atomic batch is executed in one
+	// transaction, onResult is called after commit and *BatchError is returned if any operation fails
+	ExecuteBatch(ctx context.Context, dto *domain.BatchDTO, onResult func(domain.BatchResult) error) error
+	// ImportReplenishments validates CSV and replenishes balances if dto.Commit is set.
+	// Return ErrImportHasInvalidLines with report if commit is requested for file with invalid lines
+	ImportReplenishments(ctx context.Context, dto *domain.ImportReplenishmentsDTO) (domain.ImportReport, error)
+	// GetAuditLog return entries in order of id, up to MaxHistoryRowPerRequest if dto.Limit isn't set
+	GetAuditLog(ctx context.Context, dto *domain.GetAuditLogDTO) ([]domain.AuditEntry, error)
+	// ExportAuditLog writes all entries which match dto to w as CSV, dto.Limit is ignored
+	ExportAuditLog(ctx context.Context, dto *domain.GetAuditLogDTO, w io.Writer) error
+	// VerifyAuditLog walks the hash chain of audit log. Broken chain isn't an error, see domain.AuditVerification
+	VerifyAuditLog(ctx context.Context) (domain.AuditVerification, error)
+	// VerifyTransactionChain walks the hash chain of transactions. Broken chain isn't an error,
+	// see domain.TransactionChainVerification
+	VerifyTransactionChain(ctx context.Context) (domain.TransactionChainVerification, error)
+	// GetChainRoots return daily roots of the transaction chain to be anchored outside
+	GetChainRoots(ctx context.Context, dto *domain.GetChainRootsDTO) ([]domain.ChainRoot, error)
 }
 
 type service struct {
-	repo   repository.Repository
-	logger logging.Logger
-	config *Config
+	repo     repository.Repository
+	notifier repository.Notifier
+	logger   logging.Logger
+	config   *Config
 }
 
-func (s *service) GetBalance(ctx context.Context, dto *domain.GetBalanceDTO) (domain.Money, error) {
+func (s *service) GetBalance(ctx context.Context, dto *domain.GetBalanceDTO) (domain.Balance, error) {
 	s.logger.Tracef("service.GetBalance(%v, %#v)", ctx, *dto)
-	return s.repo.GetBalance(ctx, dto)
+	setDefaultCurrency(&dto.Currency)
+	balance, err := s.repo.GetBalance(ctx, dto)
+	if err != nil {
+		return domain.Balance{}, err
+	}
+	balance.Currency = dto.Currency
+	if dto.Reservations {
+		balance.Reservations, err = s.repo.GetReservations(ctx, dto)
+		if err != nil {
+			return domain.Balance{}, err
+		}
+	}
+	return balance, nil
+}
+
+func (s *service) GetBalanceAt(ctx context.Context, dto *domain.GetBalanceAtDTO) (domain.BalanceAt, error) {
+	s.logger.Tracef("service.GetBalanceAt(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
+	balance, err := s.repo.GetBalanceAt(ctx, dto)
+	if err != nil {
+		return domain.BalanceAt{}, err
+	}
+	balance.Currency = dto.Currency
+	balance.At = dto.At
+	balance.Available, err = balance.Balance.Add(balance.CreditLimit)
+	if err != nil {
+		return domain.BalanceAt{}, err
+	}
+	if balance.Available < 0 {
+		balance.Available = 0
+	}
+	return balance, nil
+}
+
+func (s *service) GetWallets(ctx context.Context, dto *domain.GetBalanceDTO) ([]domain.Wallet, error) {
+	s.logger.Tracef("service.GetWallets(%v, %#v)", ctx, *dto)
+	return s.repo.GetWallets(ctx, dto)
 }
 
 func (s *service) GetMonthlyReportPath(ctx context.Context, dto *domain.GetMonthlyReportDTO) (string, error) {
-	s.logger.Tracef("service.GetBalance(%v, %#v)", ctx, *dto)
-	report, err := s.repo.GetMonthlyReport(ctx, dto)
+	s.logger.Tracef("service.GetMonthlyReportPath(%v, %#v)", ctx, *dto)
+	report, err := s.GetMonthlyReport(ctx, dto)
 	if err != nil {
 		return "", err
 	}
-	s.logger.Debug("Report: ", report)
 
-	for i, row := range report {
-		if row.ServiceName == "" {
-			report[i].ServiceName = fmt.Sprintf("Услуга №%d", row.ServiceId)
-		}
-	}
 	if _, err := os.Stat(s.config.FileServerDirectory); os.IsNotExist(err) {
 		err := os.Mkdir(s.config.FileServerDirectory, 0666)
 		if err != nil {
@@ -65,18 +203,37 @@ func (s *service) GetMonthlyReportPath(ctx context.Context, dto *domain.GetMonth
 	csvWriter.Comma = ';'
 	defer csvWriter.Flush()
 	for _, row := range report {
-		err = csvWriter.Write([]string{row.ServiceName, row.Revenue.String()})
+		err = csvWriter.Write([]string{row.ServiceName, row.Revenue.String(), string(row.Currency), row.PromoRevenue.String(),
+			row.Payouts.String()})
 		if err != nil {
 			s.logger.Errorf("Can't write to file: %v", err)
-			return "", nil
+			return "", err
 		}
 	}
 	file.Sync()
 
 	return filepath, nil
 }
+func (s *service) GetMonthlyReport(ctx context.Context, dto *domain.GetMonthlyReportDTO) (domain.MonthlyReport, error) {
+	s.logger.Tracef("service.GetMonthlyReport(%v, %#v)", ctx, *dto)
+	report, err := s.repo.GetMonthlyReport(ctx, dto)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Debug("Report: ", report)
+
+	for i, row := range report {
+		if row.ServiceName == "" && row.ServiceId != nil {
+			report[i].ServiceName = fmt.Sprintf("Услуга №%d", *row.ServiceId)
+		}
+	}
+	return report, nil
+}
+
 func (s *service) ReplenishBalance(ctx context.Context, dto *domain.ReplenishBalanceDTO) error {
 	s.logger.Tracef("service.ReplenishBalance(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
+	dto.CreateUser = s.config.ImplicitUserCreation
 	if dto.Description == "" {
 		dto.Description = fmt.Sprintf("Пополнение баланса")
 	}
@@ -93,29 +250,83 @@ func (s *service) GetHistory(ctx context.Context, dto *domain.GetHistoryDTO) (do
 
 func (s *service) ReserveMoney(ctx context.Context, dto *domain.ReserveMoneyDTO) error {
 	s.logger.Tracef("service.ReserveMoney(%v, %#v)", ctx, *dto)
-	if dto.ServiceName == "" {
-		dto.ServiceName = fmt.Sprintf("Услуга №%d", dto.ServiceId)
+	if err := s.prepareReservation(ctx, dto); err != nil {
+		return err
+	}
+	return s.withVelocityCheck(ctx, dto.UserId, dto.Currency, dto.Amount, domain.SpendingOperationReserve,
+		func(ctx context.Context) error {
+			return s.repo.ReserveMoney(ctx, dto)
+		})
+}
+
+// prepareReservation sets fields of dto which are computed by service
+func (s *service) prepareReservation(ctx context.Context, dto *domain.ReserveMoneyDTO) error {
+	setDefaultCurrency(&dto.Currency)
+	dto.CreateService = s.config.ImplicitServiceCreation
+	serviceName := dto.ServiceName
+	if serviceName == "" {
+		serviceName = fmt.Sprintf("Услуга №%d", dto.ServiceId)
 	}
 	if dto.Description != "" {
-		dto.Description = fmt.Sprintf("Оказание услуги: %s", dto.ServiceName)
+		dto.Description = fmt.Sprintf("Оказание услуги: %s", serviceName)
+	}
+
+	rules, err := s.repo.GetFeeSchedule(ctx, &domain.GetFeeScheduleDTO{ServiceId: dto.ServiceId})
+	if err != nil {
+		return err
+	}
+	dto.Fee, err = computeFee(rules, dto.Currency, dto.Amount)
+	if err != nil {
+		return err
 	}
-	return s.repo.ReserveMoney(ctx, dto)
+	dto.FeeDescription = fmt.Sprintf("Комиссия за услугу: %s", serviceName)
+	dto.PromoLimit, err = s.promoLimit(dto.ServiceId, dto.Amount)
+	return err
 }
 
 func (s *service) RecognizeRevenue(ctx context.Context, dto *domain.RecognizeRevenueDTO) error {
 	s.logger.Tracef("service.RecognizeRevenue(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
+	var err error
+	dto.Payouts, err = computePayouts(dto.Splits, dto.Amount, dto.RemainderTo)
+	if err != nil {
+		return err
+	}
+	dto.PayoutDescription = fmt.Sprintf("Доля выручки по заказу №%d", dto.OrderId)
 	return s.repo.RecognizeRevenue(ctx, dto)
 }
 
 func (s *service) CancelTransaction(ctx context.Context, dto *domain.CancelTransactionDTO) error {
 	s.logger.Tracef("service.CancelTransaction(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
 	return s.repo.CancelTransaction(ctx, dto)
 }
 
-func NewService(config *Config, repo repository.Repository, logger logging.Logger) Service {
+func (s *service) AdjustBalance(ctx context.Context, dto *domain.AdjustBalanceDTO) error {
+	s.logger.Tracef("service.AdjustBalance(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
+	title := "Корректировка баланса"
+	if dto.Refund {
+		title = "Возврат средств"
+	}
+	description := fmt.Sprintf("%s: %s", title, dto.Reason)
+	if dto.Operator != "" {
+		description = fmt.Sprintf("%s (%s): %s", title, dto.Operator, dto.Reason)
+	}
+	return s.repo.AdjustBalance(ctx, dto, description)
+}
+
+func setDefaultCurrency(currency *domain.Currency) {
+	if *currency == "" {
+		*currency = domain.DefaultCurrency
+	}
+}
+
+func NewService(config *Config, repo repository.Repository, notifier repository.Notifier, logger logging.Logger) Service {
 	return &service{
-		repo:   repo,
-		logger: logger,
-		config: config,
+		repo:     repo,
+		notifier: notifier,
+		logger:   logger,
+		config:   config,
 	}
 }
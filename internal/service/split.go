@@ -0,0 +1,50 @@
+package service
+
+import (
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+// computePayouts shares amount among recipients of splits. Shares in basis points are rounded down,
+// remainder of rounding goes to remainderTo or stays with the service if remainderTo isn't set
+func computePayouts(splits []domain.Split, amount domain.Money, remainderTo uint) ([]domain.Payout, error) {
+	if len(splits) == 0 {
+		return nil, nil
+	}
+	payouts := make([]domain.Payout, 0, len(splits))
+	var basisPoints int64
+	var shared domain.Money
+	for _, split := range splits {
+		payout := domain.Payout{UserId: split.UserId, Amount: split.Amount}
+		if split.BasisPoints != 0 {
+			var err error
+			payout.Amount, err = amount.MulRatioTruncated(split.BasisPoints, domain.MaxBasisPoints)
+			if err != nil {
+				return nil, err
+			}
+			basisPoints += split.BasisPoints
+			shared += payout.Amount
+		}
+		payouts = append(payouts, payout)
+	}
+
+	if remainderTo != 0 {
+		total, err := amount.MulRatioTruncated(basisPoints, domain.MaxBasisPoints)
+		if err != nil {
+			return nil, err
+		}
+		for i := range payouts {
+			if payouts[i].UserId == remainderTo {
+				payouts[i].Amount += total - shared
+			}
+		}
+	}
+
+	var paid domain.Money
+	for _, payout := range payouts {
+		paid += payout.Amount
+	}
+	if paid > amount {
+		return nil, ErrSplitsExceedAmount
+	}
+	return payouts, nil
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+func (s *service) CreateSubscription(ctx context.Context, dto *domain.CreateSubscriptionDTO) (domain.Subscription, error) {
+	s.logger.Tracef("service.CreateSubscription(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
+	return s.repo.CreateSubscription(ctx, dto)
+}
+
+func (s *service) GetSubscriptions(ctx context.Context, dto *domain.GetSubscriptionsDTO) ([]domain.Subscription, error) {
+	s.logger.Tracef("service.GetSubscriptions(%v, %#v)", ctx, *dto)
+	return s.repo.GetSubscriptions(ctx, dto)
+}
+
+func (s *service) CancelSubscription(ctx context.Context, dto *domain.SubscriptionDTO) (domain.Subscription, error) {
+	s.logger.Tracef("service.CancelSubscription(%v, %#v)", ctx, *dto)
+	return s.repo.CancelSubscription(ctx, dto)
+}
+
+func (s *service) ResumeSubscription(ctx context.Context, dto *domain.SubscriptionDTO) (domain.Subscription, error) {
+	s.logger.Tracef("service.ResumeSubscription(%v, %#v)", ctx, *dto)
+	return s.repo.ResumeSubscription(ctx, dto)
+}
+
+func (s *service) ChargeSubscription(ctx context.Context, subscription *domain.Subscription) error {
+	s.logger.Tracef("service.ChargeSubscription(%v, %#v)", ctx, *subscription)
+	description := subscription.Description
+	if description == "" {
+		description = fmt.Sprintf("Оплата подписки №%d", subscription.Id)
+	}
+	dto := domain.ReserveMoneyDTO{
+		UserId:      subscription.UserId,
+		Amount:      subscription.Amount,
+		Currency:    subscription.Currency,
+		ServiceId:   subscription.ServiceId,
+		Description: description,
+	}
+	if err := s.prepareReservation(ctx, &dto); err != nil {
+		return err
+	}
+	return s.withVelocityCheck(ctx, dto.UserId, dto.Currency, dto.Amount, domain.SpendingOperationReserve,
+		func(ctx context.Context) error {
+			return s.repo.ChargeSubscription(ctx, subscription.Id, &dto)
+		})
+}
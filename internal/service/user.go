@@ -0,0 +1,16 @@
+package service
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+func (s *service) CreateUser(ctx context.Context, dto *domain.CreateUserDTO) (domain.User, error) {
+	s.logger.Tracef("service.CreateUser(%v, %#v)", ctx, *dto)
+	return s.repo.CreateUser(ctx, dto)
+}
+
+func (s *service) GetUser(ctx context.Context, dto *domain.GetUserDTO) (domain.User, error) {
+	s.logger.Tracef("service.GetUser(%v, %#v)", ctx, *dto)
+	return s.repo.GetUser(ctx, dto)
+}
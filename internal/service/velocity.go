@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"strconv"
+	"time"
+)
+
+func (s *service) CreateVelocityRule(ctx context.Context, dto *domain.CreateVelocityRuleDTO) (domain.VelocityRule, error) {
+	s.logger.Tracef("service.CreateVelocityRule(%v, %#v)", ctx, *dto)
+	setDefaultCurrency(&dto.Currency)
+	return s.repo.CreateVelocityRule(ctx, dto)
+}
+
+func (s *service) GetVelocityRules(ctx context.Context, dto *domain.GetVelocityRulesDTO) ([]domain.VelocityRule, error) {
+	s.logger.Tracef("service.GetVelocityRules(%v, %#v)", ctx, *dto)
+	return s.repo.GetVelocityRules(ctx, dto)
+}
+
+func (s *service) DeleteVelocityRule(ctx context.Context, dto *domain.DeleteVelocityRuleDTO) error {
+	s.logger.Tracef("service.DeleteVelocityRule(%v, %#v)", ctx, *dto)
+	return s.repo.DeleteVelocityRule(ctx, dto)
+}
+
+func (s *service) GetVelocityViolations(ctx context.Context, dto *domain.GetVelocityViolationsDTO) ([]domain.VelocityViolation, error) {
+	s.logger.Tracef("service.GetVelocityViolations(%v, %#v)", ctx, *dto)
+	if dto.Limit == 0 {
+		dto.Limit = MaxHistoryRowPerRequest
+	}
+	return s.repo.GetVelocityViolations(ctx, dto)
+}
+
+// withVelocityCheck runs fn in a transaction after checking amount against velocity rules of the user.
+// Spending is recorded in the same transaction, so it's discarded if fn fails.
+// Violations are recorded and logged for review
+func (s *service) withVelocityCheck(ctx context.Context, userId uint, currency domain.Currency, amount domain.Money,
+	operation string, fn func(ctx context.Context) error) error {
+	err := s.repo.InTransaction(ctx, func(ctx context.Context) error {
+		if err := s.checkVelocity(ctx, userId, currency, amount, operation); err != nil {
+			return err
+		}
+		return fn(ctx)
+	})
+
+	var velocityErr *VelocityError
+	if errors.As(err, &velocityErr) {
+		s.logger.Warnf("velocity rule %d violated by user %d: %s %s %s, used %s",
+			velocityErr.Rule.Id, userId, operation, amount, currency, velocityErr.Used)
+		violation := domain.VelocityViolation{
+			UserId:    userId,
+			Currency:  currency,
+			RuleId:    velocityErr.Rule.Id,
+			Operation: operation,
+			Amount:    amount,
+			Used:      velocityErr.Used,
+		}
+		if recordErr := s.repo.RecordVelocityViolation(ctx, &violation); recordErr != nil {
+			s.logger.Errorf("can't record velocity violation: %v", recordErr)
+		}
+	}
+	return err
+}
+
+// checkVelocity must be called in a transaction. Lock of user spending is held until it ends,
+// so concurrent operations of the user see spending of each other
+func (s *service) checkVelocity(ctx context.Context, userId uint, currency domain.Currency, amount domain.Money, operation string) error {
+	rules, err := s.repo.GetVelocityRules(ctx, &domain.GetVelocityRulesDTO{UserId: userId})
+	if err != nil {
+		return err
+	}
+	rules = effectiveVelocityRules(rules, currency)
+
+	// spending is recorded without rules too, so new rules take earlier operations into account.
+	// It is locked anyway as cancel of concurrent operation may release it
+	if err := s.repo.LockUserSpending(ctx, userId); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		spending, err := s.repo.GetSpending(ctx, userId, currency, time.Duration(rule.WindowSeconds)*time.Second)
+		if err != nil {
+			return err
+		}
+		switch rule.Metric {
+		case domain.VelocityMetricAmount:
+			total, err := spending.Amount.Add(amount)
+			if err != nil || total > rule.MaxAmount {
+				return &VelocityError{Rule: rule, Used: spending.Amount.String()}
+			}
+		case domain.VelocityMetricCount:
+			if spending.Count+1 > rule.MaxCount {
+				return &VelocityError{Rule: rule, Used: strconv.Itoa(spending.Count)}
+			}
+		}
+	}
+	return s.repo.RecordSpending(ctx, userId, currency, amount, operation)
+}
+
+// effectiveVelocityRules return rules in currency. User rule replaces global rule with the same metric and window
+func effectiveVelocityRules(rules []domain.VelocityRule, currency domain.Currency) []domain.VelocityRule {
+	type key struct {
+		metric string
+		window int
+	}
+	personal := make(map[key]bool)
+	for _, rule := range rules {
+		if rule.Currency == currency && rule.UserId != 0 {
+			personal[key{rule.Metric, rule.WindowSeconds}] = true
+		}
+	}
+
+	var effective []domain.VelocityRule
+	for _, rule := range rules {
+		if rule.Currency != currency {
+			continue
+		}
+		if rule.UserId == 0 && personal[key{rule.Metric, rule.WindowSeconds}] {
+			continue
+		}
+		effective = append(effective, rule)
+	}
+	return effective
+}
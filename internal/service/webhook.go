@@ -0,0 +1,21 @@
+package service
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/domain"
+)
+
+func (s *service) CreateWebhook(ctx context.Context, dto *domain.CreateWebhookDTO) (domain.Webhook, error) {
+	s.logger.Tracef("service.CreateWebhook(%v, %#v)", ctx, dto.URL)
+	return s.repo.CreateWebhook(ctx, dto)
+}
+
+func (s *service) GetWebhooks(ctx context.Context) ([]domain.Webhook, error) {
+	s.logger.Tracef("service.GetWebhooks(%v)", ctx)
+	return s.repo.GetWebhooks(ctx)
+}
+
+func (s *service) DeleteWebhook(ctx context.Context, dto *domain.DeleteWebhookDTO) error {
+	s.logger.Tracef("service.DeleteWebhook(%v, %#v)", ctx, *dto)
+	return s.repo.DeleteWebhook(ctx, dto)
+}
@@ -0,0 +1,9 @@
+package snapshot
+
+import "time"
+
+type Config struct {
+	Interval time.Duration
+	// Lag keeps snapshots behind transactions which are still being committed
+	Lag time.Duration
+}
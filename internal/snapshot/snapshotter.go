@@ -0,0 +1,51 @@
+package snapshot
+
+import (
+	"context"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"github.com/manimadzis/avito-job/pkg/logging"
+	"time"
+)
+
+// Snapshotter takes balance snapshots of changed wallets every Interval, so balances as of past time
+// are computed from the nearest snapshot instead of the whole history
+type Snapshotter interface {
+	// Run blocks until ctx is done
+	Run(ctx context.Context)
+}
+
+type snapshotter struct {
+	config *Config
+	repo   repository.Repository
+	logger logging.Logger
+}
+
+func NewSnapshotter(config *Config, repo repository.Repository, logger logging.Logger) Snapshotter {
+	return &snapshotter{
+		config: config,
+		repo:   repo,
+		logger: logger,
+	}
+}
+
+func (s *snapshotter) Run(ctx context.Context) {
+	s.logger.Info("Starting balance snapshotter...")
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Balance snapshotter stopped")
+			return
+		case <-ticker.C:
+			taken, err := s.repo.TakeBalanceSnapshots(ctx, time.Now().Add(-s.config.Lag))
+			if err != nil {
+				s.logger.Errorf("Can't take balance snapshots: %v", err)
+				continue
+			}
+			if taken > 0 {
+				s.logger.Infof("Took %d balance snapshots", taken)
+			}
+		}
+	}
+}
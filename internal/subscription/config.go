@@ -0,0 +1,14 @@
+package subscription
+
+import "time"
+
+type Config struct {
+	PollInterval time.Duration
+	BatchSize    int
+	// RetryInterval is delay between attempts to charge subscription
+	RetryInterval time.Duration
+	// GracePeriod is how long after due date failed subscription is retried before it is paused
+	GracePeriod time.Duration
+	// MaxFailures is number of attempts failed for lack of money after which subscription is paused
+	MaxFailures int
+}
@@ -0,0 +1,93 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"github.com/manimadzis/avito-job/internal/service"
+	"github.com/manimadzis/avito-job/pkg/logging"
+	"time"
+)
+
+// Scheduler charges due subscriptions. Failed charges are retried every RetryInterval,
+// subscription is paused after MaxFailures attempts without money or when GracePeriod is over
+type Scheduler interface {
+	// Run blocks until ctx is done
+	Run(ctx context.Context)
+}
+
+type scheduler struct {
+	config  *Config
+	repo    repository.Repository
+	service service.Service
+	logger  logging.Logger
+}
+
+func NewScheduler(config *Config, repo repository.Repository, service service.Service, logger logging.Logger) Scheduler {
+	return &scheduler{
+		config:  config,
+		repo:    repo,
+		service: service,
+		logger:  logger,
+	}
+}
+
+func (s *scheduler) Run(ctx context.Context) {
+	s.logger.Info("Starting subscription scheduler...")
+	ctx = domain.WithAuditInfo(ctx, domain.AuditInfo{Actor: "subscription-scheduler", Operation: "charge subscription"})
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Subscription scheduler stopped")
+			return
+		case <-ticker.C:
+			s.chargeDue(ctx)
+		}
+	}
+}
+
+func (s *scheduler) chargeDue(ctx context.Context) {
+	// claimed subscriptions are hidden until the next retry, so a crashed run is retried in time
+	subscriptions, err := s.repo.ClaimDueSubscriptions(ctx, s.config.BatchSize, s.config.RetryInterval)
+	if err != nil {
+		s.logger.Errorf("Can't claim due subscriptions: %v", err)
+		return
+	}
+	for i := range subscriptions {
+		if ctx.Err() != nil {
+			return
+		}
+		s.charge(ctx, &subscriptions[i])
+	}
+}
+
+func (s *scheduler) charge(ctx context.Context, subscription *domain.Subscription) {
+	err := s.service.ChargeSubscription(ctx, subscription)
+	if err == nil {
+		s.logger.Infof("Subscription %d of user %d charged %s %s",
+			subscription.Id, subscription.UserId, subscription.Amount, subscription.Currency)
+		return
+	}
+	if err == repository.ErrSubscriptionNotDue {
+		return
+	}
+
+	failures := subscription.Failures + 1
+	noMoney := errors.Is(err, repository.ErrNotEnoughMoney) || errors.Is(err, repository.ErrCreditLimitExceeded)
+	retryAt := time.Now().Add(s.config.RetryInterval)
+	pause := (noMoney && failures >= s.config.MaxFailures) ||
+		retryAt.After(subscription.NextRunAt.Add(s.config.GracePeriod))
+	if pause {
+		s.logger.Warnf("Subscription %d of user %d paused after %d failures: %v",
+			subscription.Id, subscription.UserId, failures, err)
+	} else {
+		s.logger.Infof("Subscription %d of user %d failed, retry at %v: %v",
+			subscription.Id, subscription.UserId, retryAt, err)
+	}
+	if err := s.repo.MarkSubscriptionFailed(ctx, subscription.Id, err.Error(), retryAt, pause); err != nil {
+		s.logger.Errorf("Can't mark subscription %d failed: %v", subscription.Id, err)
+	}
+}
@@ -0,0 +1,12 @@
+package webhook
+
+import "time"
+
+type Config struct {
+	PollInterval   time.Duration
+	BatchSize      int
+	RequestTimeout time.Duration
+	MaxAttempts    int
+	BaseBackoff    time.Duration
+	MaxBackoff     time.Duration
+}
@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/manimadzis/avito-job/internal/domain"
+	"github.com/manimadzis/avito-job/internal/repository"
+	"github.com/manimadzis/avito-job/pkg/logging"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Dispatcher sends events from the outbox to subscribers
+type Dispatcher interface {
+	// Run blocks until ctx is done
+	Run(ctx context.Context)
+}
+
+type dispatcher struct {
+	config *Config
+	repo   repository.Repository
+	client *http.Client
+	logger logging.Logger
+}
+
+func NewDispatcher(config *Config, repo repository.Repository, logger logging.Logger) Dispatcher {
+	return &dispatcher{
+		config: config,
+		repo:   repo,
+		client: &http.Client{Timeout: config.RequestTimeout},
+		logger: logger,
+	}
+}
+
+func (d *dispatcher) Run(ctx context.Context) {
+	d.logger.Info("Starting webhook dispatcher...")
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			d.logger.Info("Webhook dispatcher stopped")
+			return
+		case <-ticker.C:
+			d.dispatch(ctx)
+		}
+	}
+}
+
+func (d *dispatcher) dispatch(ctx context.Context) {
+	// lease must outlive the slowest possible batch so deliveries are not claimed twice
+	lease := d.config.RequestTimeout*time.Duration(d.config.BatchSize) + d.config.PollInterval
+	deliveries, err := d.repo.ClaimWebhookDeliveries(ctx, d.config.BatchSize, lease)
+	if err != nil {
+		d.logger.Errorf("Can't claim webhook deliveries: %v", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		err := d.send(ctx, &delivery)
+		if err == nil {
+			err = d.repo.MarkWebhookDelivered(ctx, delivery.Id)
+			if err != nil {
+				d.logger.Errorf("Can't mark webhook delivery %d as done: %v", delivery.Id, err)
+			}
+			continue
+		}
+
+		d.logger.Warnf("Webhook delivery %d to %s failed: %v", delivery.Id, delivery.URL, err)
+		attempts := delivery.Attempts + 1
+		dead := attempts >= d.config.MaxAttempts
+		if dead {
+			d.logger.Errorf("Webhook delivery %d moved to dead-letter after %d attempts", delivery.Id, attempts)
+		}
+		err = d.repo.MarkWebhookFailed(ctx, delivery.Id, err.Error(), d.backoff(attempts), dead)
+		if err != nil {
+			d.logger.Errorf("Can't mark webhook delivery %d as failed: %v", delivery.Id, err)
+		}
+	}
+}
+
+func (d *dispatcher) send(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(delivery.Id), 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// backoff return delay before the next attempt: BaseBackoff * 2^(attempts-1) limited by MaxBackoff
+func (d *dispatcher) backoff(attempts int) time.Duration {
+	delay := d.config.BaseBackoff
+	for i := 1; i < attempts && delay < d.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.config.MaxBackoff {
+		delay = d.config.MaxBackoff
+	}
+	return delay
+}
+
+// Sign return "sha256=" followed by hex HMAC-SHA256 of "timestamp.payload" keyed by secret
+func Sign(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
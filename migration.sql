@@ -6,37 +6,248 @@ CREATE TYPE TRANSACTION_STATUS AS ENUM (
     'CANCELED'
 );
 
+-- FEE transactions are platform fees charged together with PAYMENT referenced by fee_of.
+-- REFUND transactions return fulfilled order payment to user.
+-- PAYOUT transactions credit recipients of split payment referenced by payout_of.
+-- ESCROW transactions hold money of buyer and show expected credit of seller until escrow is settled
+CREATE TYPE TRANSACTION_KIND AS ENUM (
+    'PAYMENT',
+    'FEE',
+    'REFUND',
+    'PAYOUT',
+    'ESCROW'
+);
+
+CREATE TABLE IF NOT EXISTS currency (
+    code text PRIMARY KEY,
+    -- number of digits of minor unit
+    exponent int NOT NULL
+);
+
+INSERT INTO currency (code, exponent)
+    VALUES ('RUB', 2), ('USD', 2), ('EUR', 2)
+ON CONFLICT
+    DO NOTHING;
+
+CREATE TYPE ACCOUNT_STATUS AS ENUM (
+    'ACTIVE',
+    'FROZEN',
+    'CLOSED'
+);
+
 CREATE TABLE IF NOT EXISTS "user" (
     id bigint PRIMARY KEY,
+    -- frozen account allows only reads and refunds, closed account allows only reads
+    status ACCOUNT_STATUS NOT NULL DEFAULT 'ACTIVE',
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    -- id of the user in external system
+    external_ref text UNIQUE,
+    metadata jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE IF NOT EXISTS wallet (
+    user_id bigint REFERENCES "user" (id),
+    currency text REFERENCES currency (code),
     balance MONEY_ DEFAULT 0,
-    reserved_balance MONEY_ DEFAULT 0
+    reserved_balance MONEY_ DEFAULT 0,
+    -- reservations may take balance down to - credit_limit
+    credit_limit MONEY_ NOT NULL DEFAULT 0 CHECK (credit_limit >= 0),
+    PRIMARY KEY (user_id, currency)
+);
+
+-- Audit trail of credit limit changes
+CREATE TABLE IF NOT EXISTS credit_limit_change (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    currency text NOT NULL,
+    old_limit MONEY_ NOT NULL,
+    new_limit MONEY_ NOT NULL,
+    "operator" text,
+    reason text NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP
 );
 
 CREATE TABLE IF NOT EXISTS "transaction" (
     id bigserial PRIMARY KEY,
     user_id bigint NOT NULL,
     amount MONEY_ NOT NULL,
+    currency text NOT NULL DEFAULT 'RUB' REFERENCES currency (code),
     "status" TRANSACTION_STATUS NOT NULL,
     service_id bigint,
     order_id bigint,
     "description" text,
     "timestamp" timestamp DEFAULT CURRENT_TIMESTAMP,
-    unique (user_id, amount, service_id, order_id)
+    idempotency_key text UNIQUE,
+    kind TRANSACTION_KIND NOT NULL DEFAULT 'PAYMENT',
+    fee_of bigint REFERENCES "transaction" (id),
+    payout_of bigint REFERENCES "transaction" (id),
+    -- part of - amount paid with promo credits
+    promo_amount MONEY_ NOT NULL DEFAULT 0,
+    -- when status became final, it is set by settle_transaction
+    settled_at timestamp,
+    -- position in hash chain, rows are chained at commit by chain_transaction
+    chain_seq bigint UNIQUE,
+    chained_at timestamp,
+    prev_hash text,
+    hash text,
+    unique (user_id, amount, currency, service_id, order_id, kind)
+);
+
+-- Settled transactions older than archive horizon are moved here by archive_transactions with their hashes,
+-- so the chain spans both tables. Uniqueness is kept for reuse of idempotency keys and orders
+CREATE TABLE IF NOT EXISTS transaction_archive (
+    LIKE "transaction",
+    PRIMARY KEY (id),
+    UNIQUE (chain_seq),
+    UNIQUE (idempotency_key),
+    UNIQUE (user_id, amount, currency, service_id, order_id, kind)
+);
+
+CREATE INDEX IF NOT EXISTS transaction_archive_user_timestamp_idx ON transaction_archive (user_id, currency, "timestamp");
+
+CREATE INDEX IF NOT EXISTS transaction_archive_settled_idx ON transaction_archive (settled_at);
+
+-- Transactions including archived ones. Archived rows are settled, so they can't be in range starting after
+-- the last archived settlement and archive isn't scanned for it. NULL from_ts reaches the whole archive
+CREATE OR REPLACE FUNCTION transactions_since (from_ts timestamp)
+    RETURNS SETOF "transaction"
+    LANGUAGE SQL
+    STABLE
+    AS $$
+    SELECT
+        *
+    FROM
+        "transaction"
+    UNION ALL
+    SELECT
+        *
+    FROM
+        transaction_archive
+    WHERE
+        from_ts IS NULL
+        OR from_ts <= (
+            SELECT
+                max(a.settled_at)
+            FROM
+                transaction_archive a);
+$$;
+
+-- Promo credits can be spent on services but not withdrawn. Expired remaining is moved to expired
+CREATE TABLE IF NOT EXISTS promo_grant (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES "user" (id),
+    currency text NOT NULL DEFAULT 'RUB' REFERENCES currency (code),
+    amount MONEY_ NOT NULL CHECK (amount > 0),
+    remaining MONEY_ NOT NULL,
+    expired MONEY_ NOT NULL DEFAULT 0,
+    expires_at timestamp NOT NULL,
+    "description" text,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS promo_grant_remaining_idx ON promo_grant (user_id, currency, expires_at)
+WHERE
+    remaining > 0;
+
+CREATE TABLE IF NOT EXISTS promo_spending (
+    transaction_id bigint REFERENCES "transaction" (id),
+    grant_id bigint REFERENCES promo_grant (id),
+    amount MONEY_ NOT NULL,
+    PRIMARY KEY (transaction_id, grant_id)
+);
+
+-- Tier of fee schedule, see domain.FeeRule
+CREATE TABLE IF NOT EXISTS fee_rule (
+    id bigserial PRIMARY KEY,
+    service_id bigint NOT NULL,
+    currency text NOT NULL DEFAULT 'RUB' REFERENCES currency (code),
+    min_amount MONEY_ NOT NULL DEFAULT 0,
+    fixed MONEY_ NOT NULL DEFAULT 0,
+    basis_points int NOT NULL DEFAULT 0,
+    min_fee MONEY_ NOT NULL DEFAULT 0,
+    max_fee MONEY_ NOT NULL DEFAULT 0,
+    UNIQUE (service_id, currency, min_amount)
 );
 
 CREATE TABLE IF NOT EXISTS "service" (
     id bigint PRIMARY KEY,
-    "name" text
+    "name" text,
+    "description" text NOT NULL DEFAULT '',
+    category text NOT NULL DEFAULT '',
+    -- reservations for inactive service are rejected
+    active boolean NOT NULL DEFAULT TRUE,
+    default_price MONEY_ NOT NULL DEFAULT 0 CHECK (default_price >= 0),
+    currency text NOT NULL DEFAULT 'RUB' REFERENCES currency (code),
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE OR REPLACE PROCEDURE add_service (service_id bigint, "name" text)
-LANGUAGE SQL
+-- Names of services by time, so reports for past months show names which services had then
+CREATE TABLE IF NOT EXISTS service_name_history (
+    id bigserial PRIMARY KEY,
+    service_id bigint NOT NULL REFERENCES "service" (id),
+    "name" text NOT NULL,
+    valid_from timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS service_name_history_service_idx ON service_name_history (service_id, valid_from);
+
+-- Record name of service if it differs from the last recorded one
+CREATE OR REPLACE PROCEDURE record_service_name (service_id bigint, "name" text)
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    IF "name" IS NULL OR "name" IS NOT DISTINCT FROM (
+        SELECT
+            h.name
+        FROM
+            service_name_history h
+        WHERE
+            h.service_id = record_service_name.service_id
+        ORDER BY
+            h.valid_from DESC,
+            h.id DESC
+        LIMIT 1) THEN
+        RETURN;
+    END IF;
+    INSERT INTO service_name_history (service_id, "name")
+        VALUES (service_id, "name");
+END;
+$$;
+
+-- Check that reservation for service is allowed. Unknown service is created with given name if create_service is set.
+-- Name of existing service is never changed here
+-- Raise exception with message UNKNOWN_SERVICE if service doesn't exist and create_service isn't set
+-- Raise exception with message SERVICE_INACTIVE if service is inactive
+CREATE OR REPLACE PROCEDURE check_service (service_id bigint, "name" text, create_service boolean)
+LANGUAGE plpgsql
 AS $$
-    INSERT INTO "service" (id, "name")
-        VALUES (service_id, "name")
-    ON CONFLICT (id)
-        DO UPDATE SET
-            "name" = add_service."name";
+DECLARE
+    is_active boolean;
+BEGIN
+    SELECT
+        s.active INTO is_active
+    FROM
+        "service" s
+    WHERE
+        s.id = service_id
+    FOR SHARE;
+    IF NOT found THEN
+        IF NOT create_service THEN
+            RAISE EXCEPTION
+                USING MESSAGE = 'UNKNOWN_SERVICE';
+            END IF;
+            INSERT INTO "service" (id, "name")
+                VALUES (service_id, NULLIF ("name", ''))
+            ON CONFLICT
+                DO NOTHING;
+            CALL record_service_name (service_id, NULLIF ("name", ''));
+            RETURN;
+    END IF;
+    IF NOT is_active THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'SERVICE_INACTIVE';
+        END IF;
+END;
 $$;
 
 -- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
@@ -57,136 +268,526 @@ BEGIN
 END;
 $$;
 
-CREATE OR REPLACE PROCEDURE replenish_balance (user_id bigint, amount MONEY_, description text)
+-- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
+-- Raise exception with message ACCOUNT_FROZEN if account is frozen and allow_frozen isn't set
+-- Raise exception with message ACCOUNT_CLOSED if account is closed
+-- Account row stays locked until the end of transaction, so status can't change under money operation
+CREATE OR REPLACE PROCEDURE check_account (user_id bigint, allow_frozen boolean DEFAULT FALSE)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    account_status ACCOUNT_STATUS;
+BEGIN
+    SELECT
+        u.status INTO account_status
+    FROM
+        "user" u
+    WHERE
+        u.id = user_id
+    FOR SHARE;
+    IF NOT found THEN
+        RAISE EXCEPTION no_data_found
+            USING message = 'UNKNOWN_USER';
+        END IF;
+        IF account_status = 'CLOSED' THEN
+            RAISE EXCEPTION
+                USING MESSAGE = 'ACCOUNT_CLOSED';
+            END IF;
+            IF account_status = 'FROZEN' AND NOT allow_frozen THEN
+                RAISE EXCEPTION
+                    USING MESSAGE = 'ACCOUNT_FROZEN';
+                END IF;
+END;
+$$;
+
+-- Raise exception foreign_key_violation if currency is unknown
+CREATE OR REPLACE PROCEDURE ensure_wallet (user_id bigint, currency text)
+LANGUAGE SQL
+AS $$
+    INSERT INTO wallet (user_id, currency)
+        VALUES (user_id, currency)
+    ON CONFLICT
+        DO NOTHING;
+$$;
+
+-- User is created if create_user is set
+-- Raise exception unique_violation if transaction with idempotency_key already exists
+-- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist and create_user isn't set
+CREATE OR REPLACE PROCEDURE replenish_balance (user_id bigint, amount MONEY_, currency text, description text, idempotency_key text DEFAULT NULL, create_user boolean DEFAULT TRUE)
 LANGUAGE plpgsql
 AS $$
 BEGIN
-    BEGIN
+    IF create_user THEN
         INSERT INTO "user" (id)
-            VALUES (user_id);
-    EXCEPTION
-        WHEN unique_violation THEN
-    END;
-INSERT INTO "transaction" (user_id, amount, service_id, order_id, status, description)
-    VALUES (user_id, amount, NULL, NULL, 'DONE', description);
-            UPDATE
-                "user"
-            SET
-                balance = balance + amount
-            WHERE
-                id = user_id;
+            VALUES (user_id)
+        ON CONFLICT
+            DO NOTHING;
+    END IF;
+    CALL check_account (user_id);
+    CALL ensure_wallet (user_id, currency);
+    INSERT INTO "transaction" (user_id, amount, currency, service_id, order_id, status, description, idempotency_key)
+        VALUES (user_id, amount, currency, NULL, NULL, 'DONE', description, idempotency_key);
+    UPDATE
+        wallet w
+    SET
+        balance = w.balance + amount
+    WHERE
+        w.user_id = replenish_balance.user_id
+        AND w.currency = replenish_balance.currency;
+END;
+$$;
+
+-- Raise exception no_data_found if user doesn't exist
+CREATE OR REPLACE FUNCTION get_balance (user_id bigint, currency text)
+    RETURNS TABLE (
+        balance MONEY_,
+        reserved_balance MONEY_,
+        total MONEY_,
+        credit_limit MONEY_,
+        available MONEY_)
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    w wallet;
+BEGIN
+    CALL check_user (user_id);
+    SELECT
+        * INTO w
+    FROM
+        wallet wl
+    WHERE
+        wl.user_id = get_balance.user_id
+        AND wl.currency = get_balance.currency;
+    balance := COALESCE(w.balance, 0);
+    reserved_balance := COALESCE(w.reserved_balance, 0);
+    total := balance + reserved_balance;
+    credit_limit := COALESCE(w.credit_limit, 0);
+    available := GREATEST (balance + credit_limit, 0);
+    RETURN NEXT;
 END;
 $$;
 
 -- Raise exception no_data_found if user doesn't exist
-CREATE OR REPLACE FUNCTION get_balance (user_id bigint)
+CREATE OR REPLACE FUNCTION get_wallets (user_id bigint)
+    RETURNS TABLE (
+        currency text,
+        balance MONEY_,
+        reserved_balance MONEY_,
+        credit_limit MONEY_,
+        available MONEY_,
+        promo_balance MONEY_)
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    CALL check_user (user_id);
+    RETURN QUERY
+    SELECT
+        w.currency,
+        w.balance,
+        w.reserved_balance,
+        w.credit_limit,
+        GREATEST (w.balance + w.credit_limit, 0)::MONEY_,
+        COALESCE((
+            SELECT
+                sum(g.remaining)
+            FROM promo_grant g
+            WHERE
+                g.user_id = w.user_id
+                AND g.currency = w.currency
+                AND g.remaining > 0
+                AND g.expires_at > CURRENT_TIMESTAMP), 0)::MONEY_
+    FROM
+        wallet w
+    WHERE
+        w.user_id = get_wallets.user_id
+    ORDER BY
+        w.currency;
+END;
+$$;
+
+-- Spend up to max_amount of promo credits which expire first and bind them to transaction
+CREATE OR REPLACE FUNCTION spend_promo (user_id bigint, currency text, transaction_id bigint, max_amount MONEY_)
     RETURNS MONEY_
     LANGUAGE plpgsql
     AS $$
 DECLARE
-    amount MONEY_;
+    promo RECORD;
+    part MONEY_;
+    spent MONEY_ := 0;
 BEGIN
+    FOR promo IN
     SELECT
-        balance INTO STRICT amount
+        g.id,
+        g.remaining
     FROM
-        "user"
+        promo_grant g
     WHERE
-        id = user_id;
-    RETURN amount;
+        g.user_id = spend_promo.user_id
+        AND g.currency = spend_promo.currency
+        AND g.remaining > 0
+        AND g.expires_at > CURRENT_TIMESTAMP
+    ORDER BY
+        g.expires_at,
+        g.id
+    FOR UPDATE
+        LOOP
+            EXIT
+            WHEN spent >= max_amount;
+            part := LEAST (promo.remaining, max_amount - spent);
+            UPDATE
+                promo_grant g
+            SET
+                remaining = g.remaining - part
+            WHERE
+                g.id = promo.id;
+            INSERT INTO promo_spending (transaction_id, grant_id, amount)
+                VALUES (transaction_id, promo.id, part);
+            spent := spent + part;
+        END LOOP;
+    RETURN spent;
 END;
 $$;
 
--- Raise exception with message NOT_ENOUGH_MONEY if amount greater than balance
+-- Return promo credits spent by transaction to their grants. Expired credits are swept again
+CREATE OR REPLACE PROCEDURE restore_promo (transaction_id bigint)
+LANGUAGE SQL
+AS $$
+    UPDATE
+        promo_grant g
+    SET
+        remaining = g.remaining + s.amount
+    FROM
+        promo_spending s
+    WHERE
+        s.transaction_id = restore_promo.transaction_id
+        AND g.id = s.grant_id;
+$$;
+
+-- Reserve amount and fee. Up to promo_limit of amount is paid with promo credits.
+-- Fee is written as separate FEE transaction and is paid with real money
+-- Raise exception with message NOT_ENOUGH_MONEY if amount with fee greater than balance
+-- Raise exception with message CREDIT_LIMIT_EXCEEDED if it is greater than balance with credit limit
 -- Raise exception no_data_found if user doesn't exist
-CREATE OR REPLACE PROCEDURE reserve_money (user_id bigint, amount MONEY_, service_id bigint, order_id bigint, description text DEFAULT NULL)
+-- Raise exception with message ORDER_MISMATCH or INVALID_ORDER_TRANSITION, see reserve_order
+CREATE OR REPLACE PROCEDURE reserve_money (user_id bigint, amount MONEY_, currency text, service_id bigint, order_id bigint, description text DEFAULT NULL, fee MONEY_ DEFAULT 0, fee_description text DEFAULT NULL, promo_limit MONEY_ DEFAULT 0)
 LANGUAGE plpgsql
 AS $$
 BEGIN
-    IF get_balance (user_id) < amount THEN
-        RAISE EXCEPTION
-            USING MESSAGE = 'NOT_ENOUGH_MONEY';
-        END IF;
+    PERFORM
+        reserve_payment (user_id, amount, currency, service_id, order_id, description, fee, fee_description, promo_limit);
+END;
+$$;
+
+-- reserve_money returning id of PAYMENT transaction
+CREATE OR REPLACE FUNCTION reserve_payment (user_id bigint, amount MONEY_, currency text, service_id bigint, order_id bigint, description text DEFAULT NULL, fee MONEY_ DEFAULT 0, fee_description text DEFAULT NULL, promo_limit MONEY_ DEFAULT 0)
+    RETURNS bigint
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    payment_id bigint;
+    promo MONEY_ := 0;
+    has_credit boolean;
+BEGIN
+    CALL check_account (user_id);
+    INSERT INTO "transaction" (user_id, amount, currency, service_id, order_id, "status", "description")
+        VALUES (user_id, - amount, currency, service_id, order_id, 'PENDING', "description")
+    RETURNING
+        id INTO payment_id;
+    IF promo_limit > 0 THEN
+        promo := spend_promo (user_id, currency, payment_id, LEAST (promo_limit, amount));
         UPDATE
-            "user"
+            "transaction" t
         SET
-            reserved_balance = reserved_balance + amount,
-            balance = balance - amount
+            promo_amount = promo
+        WHERE
+            t.id = payment_id;
+    END IF;
+    UPDATE
+        wallet w
+    SET
+        reserved_balance = w.reserved_balance + amount - promo + fee,
+        balance = w.balance - amount + promo - fee
+    WHERE
+        w.user_id = reserve_payment.user_id
+        AND w.currency = reserve_payment.currency
+        AND w.balance + w.credit_limit >= amount - promo + fee;
+    IF NOT found THEN
+        SELECT
+            w.credit_limit > 0 INTO has_credit
+        FROM
+            wallet w
         WHERE
-            id = user_id;
-        INSERT INTO "transaction" (user_id, amount, service_id, order_id, "status", "description")
-            VALUES (user_id, - amount, service_id, order_id, 'PENDING', "description");
+            w.user_id = reserve_payment.user_id
+            AND w.currency = reserve_payment.currency;
+        IF has_credit THEN
+            RAISE EXCEPTION
+                USING MESSAGE = 'CREDIT_LIMIT_EXCEEDED';
+            END IF;
+            RAISE EXCEPTION
+                USING MESSAGE = 'NOT_ENOUGH_MONEY';
+        END IF;
+        IF fee > 0 THEN
+            INSERT INTO "transaction" (user_id, amount, currency, service_id, order_id, "status", "description", kind, fee_of)
+                VALUES (user_id, - fee, currency, service_id, order_id, 'PENDING', fee_description, 'FEE', payment_id);
+        END IF;
+        -- subscription charges have no order
+        IF order_id IS NOT NULL THEN
+            CALL reserve_order (order_id, user_id, amount, currency, service_id, payment_id);
+        END IF;
+        RETURN payment_id;
 END;
 $$;
 
--- Raise exception with message UNKNOWN_TRANSACTION if don't update any transaction
-CREATE OR REPLACE PROCEDURE recognize_revenue (user_id bigint, amount MONEY_, service_id bigint, order_id bigint)
-LANGUAGE plpgsql
-AS $$
+-- m * num / den rounded half to even to minor units, the same as domain.Money.MulRatio.
+-- Operands are scaled to minor units, so div truncates toward zero to whole minor units of the result
+-- and the exact remainder decides rounding
+CREATE OR REPLACE FUNCTION money_mul_ratio (m MONEY_, num MONEY_, den MONEY_)
+    RETURNS MONEY_
+    LANGUAGE plpgsql
+    IMMUTABLE
+    AS $$
 DECLARE
-    affected_number int;
+    -- minor units, so division remainder is exact
+    product numeric := m * 100 * num * 100;
+    divisor numeric := den * 100;
+    quo numeric := div(product, divisor);
+    twice_rem numeric := 2 * abs(product - quo * divisor);
 BEGIN
+    IF twice_rem > abs(divisor) OR (twice_rem = abs(divisor) AND mod(quo, 2) <> 0) THEN
+        quo := quo + sign(product) * sign(divisor);
+    END IF;
+    RETURN quo / 100;
+END;
+$$;
+
+-- Set status of fees of payment and return their sum
+CREATE OR REPLACE FUNCTION settle_fees (payment_id bigint, new_status TRANSACTION_STATUS)
+    RETURNS MONEY_
+    LANGUAGE SQL
+    AS $$
     WITH cte AS (
         UPDATE
             "transaction" t
         SET
-            status = 'DONE'
+            status = new_status
         WHERE
-            t.user_id = recognize_revenue.user_id
-            AND t.service_id = recognize_revenue.service_id
-            AND t.order_id = recognize_revenue.order_id
-            AND t.amount = - recognize_revenue.amount
-            and t.status = 'PENDING'
+            t.fee_of = payment_id
+            AND t.status = 'PENDING'
         RETURNING
-            1
+            - t.amount AS fee
 )
     SELECT
-        count(*) INTO affected_number
+        COALESCE(sum(fee), 0)
     FROM
         cte;
-    IF affected_number < 1 THEN
+$$;
+
+-- Raise exception with message CURRENCY_MISMATCH if pending transaction with given fields
+-- exists in another currency
+CREATE OR REPLACE PROCEDURE check_currency_mismatch (user_id bigint, amount MONEY_, currency text, service_id bigint, order_id bigint)
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    PERFORM
+        1
+    FROM
+        "transaction" t
+    WHERE
+        t.user_id = check_currency_mismatch.user_id
+        AND t.service_id = check_currency_mismatch.service_id
+        AND t.order_id = check_currency_mismatch.order_id
+        AND t.amount = - check_currency_mismatch.amount
+        AND t.currency <> check_currency_mismatch.currency
+        AND t.kind = 'PAYMENT'
+        AND t.status = 'PENDING';
+    IF found THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'CURRENCY_MISMATCH';
+        END IF;
+END;
+$$;
+
+-- Check user who gets money from another user. Unlike check_account errors refer to the recipient
+-- Raise exception with message UNKNOWN_RECIPIENT if recipient doesn't exist
+-- Raise exception with message RECIPIENT_INACTIVE if account of recipient is frozen or closed
+CREATE OR REPLACE PROCEDURE check_recipient (user_id bigint)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    recipient_status ACCOUNT_STATUS;
+BEGIN
+    SELECT
+        u.status INTO recipient_status
+    FROM
+        "user" u
+    WHERE
+        u.id = check_recipient.user_id
+    FOR SHARE;
+    IF NOT found THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'UNKNOWN_RECIPIENT';
+        END IF;
+        IF recipient_status <> 'ACTIVE' THEN
+            RAISE EXCEPTION
+                USING MESSAGE = 'RECIPIENT_INACTIVE';
+            END IF;
+END;
+$$;
+
+-- Credit parts of recognized payment to recipients. Zero parts are skipped
+-- Raise exceptions of check_recipient
+CREATE OR REPLACE PROCEDURE pay_out (payment_id bigint, recipient_ids bigint[], amounts MONEY_[], description text)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    payment "transaction";
+BEGIN
+    SELECT
+        * INTO payment
+    FROM
+        "transaction" t
+    WHERE
+        t.id = pay_out.payment_id;
+    FOR i IN 1..array_length(recipient_ids, 1)
+    LOOP
+        CALL check_recipient (recipient_ids[i]);
+        CONTINUE
+        WHEN amounts[i] = 0;
+        CALL ensure_wallet (recipient_ids[i], payment.currency);
+        INSERT INTO "transaction" (user_id, amount, currency, service_id, order_id, "status", "description", kind, payout_of)
+            VALUES (recipient_ids[i], amounts[i], payment.currency, payment.service_id, payment.order_id, 'DONE', pay_out.description, 'PAYOUT', payment.id);
+        UPDATE
+            wallet w
+        SET
+            balance = w.balance + amounts[i]
+        WHERE
+            w.user_id = recipient_ids[i]
+            AND w.currency = payment.currency;
+    END LOOP;
+END;
+$$;
+
+-- Fees of the transaction are recognized together with it. Payouts of split payment are credited
+-- to recipient_ids, the rest is revenue of the service
+-- Raise exception with message UNKNOWN_TRANSACTION if don't update any transaction
+-- Raise exception with message CURRENCY_MISMATCH if transaction is in another currency
+-- Raise exceptions of pay_out
+CREATE OR REPLACE PROCEDURE recognize_revenue (user_id bigint, amount MONEY_, currency text, service_id bigint, order_id bigint, recipient_ids bigint[] DEFAULT NULL, payouts MONEY_[] DEFAULT NULL, payout_description text DEFAULT NULL)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    payment_id bigint;
+    promo MONEY_;
+    fee MONEY_;
+BEGIN
+    CALL check_account (user_id);
+    UPDATE
+        "transaction" t
+    SET
+        status = 'DONE'
+    WHERE
+        t.user_id = recognize_revenue.user_id
+        AND t.service_id = recognize_revenue.service_id
+        AND t.order_id = recognize_revenue.order_id
+        AND t.amount = - recognize_revenue.amount
+        AND t.currency = recognize_revenue.currency
+        AND t.kind = 'PAYMENT'
+        AND t.status = 'PENDING'
+    RETURNING
+        t.id,
+        t.promo_amount INTO payment_id,
+        promo;
+    IF NOT found THEN
+        CALL check_currency_mismatch (user_id, amount, currency, service_id, order_id);
         RAISE EXCEPTION
             USING MESSAGE = 'UNKNOWN_TRANSACTION';
         END IF;
+        fee := settle_fees (payment_id, 'DONE');
         UPDATE
-            "user"
+            wallet w
         SET
-            reserved_balance = reserved_balance - amount;
+            reserved_balance = w.reserved_balance - amount + promo - fee
+        WHERE
+            w.user_id = recognize_revenue.user_id
+            AND w.currency = recognize_revenue.currency;
+        CALL settle_order (payment_id, 'FULFILLED');
+        IF array_length(recipient_ids, 1) > 0 THEN
+            CALL pay_out (payment_id, recipient_ids, payouts, payout_description);
+        END IF;
 END;
 $$;
 
+-- Fees of all services are booked to platform account, its row has NULL service_id and goes first in currency.
+-- Payouts of split payments are shown by service apart from its revenue.
+-- Services are named as they were named at the end of month
 CREATE OR REPLACE FUNCTION get_month_report (month int, year int)
     RETURNS TABLE (
         service_name text,
         service_id bigint,
-        revenue MONEY_)
+        currency text,
+        revenue MONEY_,
+        promo_revenue MONEY_,
+        payouts MONEY_)
     LANGUAGE SQL
     AS $$
     SELECT
-        COALESCE(s.name, ''),
-        service_id,
-        t.amount
+        CASE WHEN t.platform THEN
+            'Комиссия платформы'
+        ELSE
+            COALESCE((
+                SELECT
+                    h.name
+                FROM service_name_history h
+                WHERE
+                    h.service_id = t.service_id
+                    AND h.valid_from < make_date(year, month, 1) + interval '1 month'
+                ORDER BY
+                    h.valid_from DESC, h.id DESC
+                LIMIT 1), s.name, '')
+        END,
+        t.service_id,
+        t.currency,
+        t.amount,
+        t.promo_amount,
+        t.payouts
     FROM (
         SELECT
-            service_id,
-            - sum(amount) amount
+            kind = 'FEE' platform,
+            CASE WHEN kind = 'FEE' THEN
+                NULL
+            ELSE
+                service_id
+            END service_id,
+            currency,
+            - COALESCE(sum(amount) FILTER (WHERE kind <> 'PAYOUT'), 0) amount,
+            sum(promo_amount) promo_amount,
+            COALESCE(sum(amount) FILTER (WHERE kind = 'PAYOUT'), 0) payouts
         FROM
-            "transaction"
+            transactions_since (make_timestamp(year, month, 1, 0, 0, 0.0))
         WHERE
             "status" = 'DONE'
             AND "timestamp" >= make_timestamp(year, month, 1, 0, 0, 0.0)
-            AND "timestamp" < make_timestamp(year + (month + 1) / 12, (month + 1) % 12 + 1 * (month + 1) / 12, 1, 0, 0, 0.0)
+            AND "timestamp" < make_date(year, month, 1) + interval '1 month'
         GROUP BY
-            service_id) t
+            1,
+            2,
+            currency) t
     LEFT JOIN "service" s ON t.service_id = s.id
 WHERE
-    service_id IS NOT NULL
+    t.platform
+    OR t.service_id IS NOT NULL
+ORDER BY
+    t.currency,
+    t.platform DESC,
+    t.service_id
 $$;
 
 -- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
-CREATE OR REPLACE FUNCTION get_history_sorted_by_timestamp (user_id bigint, "offset" bigint, "limit" bigint, reverse boolean DEFAULT FALSE)
+CREATE OR REPLACE FUNCTION get_history_sorted_by_timestamp (user_id bigint, "offset" bigint, "limit" bigint, reverse boolean DEFAULT FALSE, from_ts timestamp DEFAULT NULL, to_ts timestamp DEFAULT NULL)
     RETURNS TABLE (
         "timestamp" timestamp,
         amount MONEY_,
+        currency text,
         "description" text)
     LANGUAGE plpgsql
     AS $$
@@ -197,11 +798,16 @@ BEGIN
         SELECT
             t."timestamp",
             t.amount,
+            t.currency,
             coalesce(t."description", 'No desctiption')
         FROM
-            "transaction" t
+            transactions_since (from_ts) t
         WHERE
             t.user_id = get_history_sorted_by_timestamp.user_id
+            AND (from_ts IS NULL
+                OR t."timestamp" >= from_ts)
+            AND (to_ts IS NULL
+                OR t."timestamp" < to_ts)
         ORDER BY
             t."timestamp" ASC
         LIMIT "limit" OFFSET "offset";
@@ -210,11 +816,16 @@ BEGIN
         SELECT
             t."timestamp",
             t.amount,
+            t.currency,
             coalesce(t."description", 'No desctiption')
         FROM
-            "transaction" t
+            transactions_since (from_ts) t
         WHERE
             t.user_id = get_history_sorted_by_timestamp.user_id
+            AND (from_ts IS NULL
+                OR t."timestamp" >= from_ts)
+            AND (to_ts IS NULL
+                OR t."timestamp" < to_ts)
         ORDER BY
             t."timestamp" DESC
         LIMIT "limit" OFFSET "offset";
@@ -223,10 +834,11 @@ END;
 $$;
 
 -- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
-CREATE OR REPLACE FUNCTION get_history_sorted_by_amount (user_id bigint, "offset" bigint, "limit" bigint, reverse boolean DEFAULT FALSE)
+CREATE OR REPLACE FUNCTION get_history_sorted_by_amount (user_id bigint, "offset" bigint, "limit" bigint, reverse boolean DEFAULT FALSE, from_ts timestamp DEFAULT NULL, to_ts timestamp DEFAULT NULL)
     RETURNS TABLE (
         "timestamp" timestamp,
         amount MONEY_,
+        currency text,
         "description" text)
     LANGUAGE plpgsql
     AS $$
@@ -237,11 +849,16 @@ BEGIN
         SELECT
             t."timestamp",
             t.amount,
+            t.currency,
             coalesce(t."description", 'No desctiption')
         FROM
-            "transaction" t
+            transactions_since (from_ts) t
         WHERE
             t.user_id = get_history_sorted_by_amount.user_id
+            AND (from_ts IS NULL
+                OR t."timestamp" >= from_ts)
+            AND (to_ts IS NULL
+                OR t."timestamp" < to_ts)
         ORDER BY
             t.amount ASC
         LIMIT "limit" OFFSET "offset";
@@ -250,11 +867,16 @@ BEGIN
         SELECT
             t."timestamp",
             t.amount,
+            t.currency,
             coalesce(t."description", 'No desctiption')
         FROM
-            "transaction" t
+            transactions_since (from_ts) t
         WHERE
             t.user_id = get_history_sorted_by_amount.user_id
+            AND (from_ts IS NULL
+                OR t."timestamp" >= from_ts)
+            AND (to_ts IS NULL
+                OR t."timestamp" < to_ts)
         ORDER BY
             t.amount DESC
         LIMIT "limit" OFFSET "offset";
@@ -264,39 +886,2651 @@ $$;
 
 
 
+-- Manual correction of balance. Amount may be negative. Refund must be positive and is allowed for frozen account
+-- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
+-- Raise exception with message NOT_ENOUGH_MONEY if balance would become negative
+CREATE OR REPLACE PROCEDURE adjust_balance (user_id bigint, amount MONEY_, currency text, description text, refund boolean DEFAULT FALSE)
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    CALL check_account (user_id, allow_frozen => refund AND amount > 0);
+    CALL ensure_wallet (user_id, currency);
+    UPDATE
+        wallet w
+    SET
+        balance = w.balance + amount
+    WHERE
+        w.user_id = adjust_balance.user_id
+        AND w.currency = adjust_balance.currency
+        AND w.balance + amount >= 0;
+    IF NOT found THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'NOT_ENOUGH_MONEY';
+        END IF;
+        INSERT INTO "transaction" (user_id, amount, currency, service_id, order_id, "status", "description")
+            VALUES (user_id, amount, currency, NULL, NULL, 'DONE', "description");
+END;
+$$;
+
+-- Fees are returned in proportion to canceled amount. Cancel covers the whole reservation, so all pending fees
+-- are canceled
 -- Raise exception with message UNKNOWN_TRANSACTION if don't update any transaction
-CREATE OR REPLACE PROCEDURE cancel_transaction (user_id bigint, amount MONEY_, service_id bigint, order_id bigint)
+-- Raise exception with message CURRENCY_MISMATCH if transaction is in another currency
+CREATE OR REPLACE PROCEDURE cancel_transaction (user_id bigint, amount MONEY_, currency text, service_id bigint, order_id bigint)
 LANGUAGE plpgsql
 AS $$
 DECLARE
-    affected_number int;
+    payment_id bigint;
+    promo MONEY_;
+    fee MONEY_;
 BEGIN
-    WITH cte AS (
-        UPDATE
-            "transaction" t
-        SET
-            status = 'CANCELED'
-        WHERE
-            t.user_id = cancel_transaction.user_id
-            AND t.service_id = cancel_transaction.service_id
-            AND t.order_id = cancel_transaction.order_id
-            AND t.amount = - cancel_transaction.amount
-            and t.status = 'PENDING'
-        RETURNING
-            1
-)
-    SELECT
-        count(*) INTO affected_number
-    FROM
-        cte;
-    IF affected_number < 1 THEN
+    CALL check_account (user_id, allow_frozen => TRUE);
+    UPDATE
+        "transaction" t
+    SET
+        status = 'CANCELED'
+    WHERE
+        t.user_id = cancel_transaction.user_id
+        AND t.service_id = cancel_transaction.service_id
+        AND t.order_id = cancel_transaction.order_id
+        AND t.amount = - cancel_transaction.amount
+        AND t.currency = cancel_transaction.currency
+        AND t.kind = 'PAYMENT'
+        AND t.status = 'PENDING'
+    RETURNING
+        t.id,
+        t.promo_amount INTO payment_id,
+        promo;
+    IF NOT found THEN
+        CALL check_currency_mismatch (user_id, amount, currency, service_id, order_id);
         RAISE EXCEPTION
             USING MESSAGE = 'UNKNOWN_TRANSACTION';
         END IF;
+        fee := settle_fees (payment_id, 'CANCELED');
+        CALL restore_promo (payment_id);
         UPDATE
-            "user"
+            wallet w
         SET
-            reserved_balance = reserved_balance - amount,
-            balance = balance + amount;
+            reserved_balance = w.reserved_balance - amount + promo - fee,
+            balance = w.balance + amount - promo + fee
+        WHERE
+            w.user_id = cancel_transaction.user_id
+            AND w.currency = cancel_transaction.currency;
+        CALL settle_order (payment_id, 'CANCELED');
+END;
+$$;
+
+CREATE TYPE WEBHOOK_DELIVERY_STATUS AS ENUM (
+    'PENDING',
+    'DONE',
+    'DEAD'
+);
+
+CREATE TABLE IF NOT EXISTS webhook_subscription (
+    id bigserial PRIMARY KEY,
+    url text NOT NULL,
+    events text[] NOT NULL,
+    secret text NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Transactional outbox. Rows are written in the same transaction as the money change
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+    id bigserial PRIMARY KEY,
+    subscription_id bigint NOT NULL REFERENCES webhook_subscription (id) ON DELETE CASCADE,
+    event text NOT NULL,
+    payload jsonb NOT NULL,
+    "status" WEBHOOK_DELIVERY_STATUS NOT NULL DEFAULT 'PENDING',
+    attempts int NOT NULL DEFAULT 0,
+    next_attempt_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error text,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webhook_outbox_pending_idx ON webhook_outbox (next_attempt_at)
+WHERE
+    "status" = 'PENDING';
+
+-- Put event into outbox of every subscription which listens to it
+CREATE OR REPLACE PROCEDURE enqueue_event (event text, payload jsonb)
+LANGUAGE SQL
+AS $$
+    INSERT INTO webhook_outbox (subscription_id, event, payload)
+    SELECT
+        s.id,
+        enqueue_event.event,
+        enqueue_event.payload
+    FROM
+        webhook_subscription s
+    WHERE
+        enqueue_event.event = ANY (s.events);
+$$;
+
+-- Lock due deliveries for lease_seconds so concurrent dispatchers don't send them twice
+CREATE OR REPLACE FUNCTION claim_webhook_deliveries ("limit" int, lease_seconds int)
+    RETURNS TABLE (
+        id bigint,
+        subscription_id bigint,
+        url text,
+        secret text,
+        event text,
+        payload jsonb,
+        attempts int)
+    LANGUAGE SQL
+    AS $$
+    WITH due AS (
+        SELECT
+            o.id
+        FROM
+            webhook_outbox o
+        WHERE
+            o."status" = 'PENDING'
+            AND o.next_attempt_at <= CURRENT_TIMESTAMP
+        ORDER BY
+            o.next_attempt_at
+        LIMIT "limit"
+        FOR UPDATE
+            SKIP LOCKED
+),
+claimed AS (
+    UPDATE
+        webhook_outbox o
+    SET
+        next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => lease_seconds)
+    FROM
+        due
+    WHERE
+        o.id = due.id
+    RETURNING
+        o.*
+)
+SELECT
+    c.id,
+    c.subscription_id,
+    s.url,
+    s.secret,
+    c.event,
+    c.payload,
+    c.attempts
+FROM
+    claimed c
+    JOIN webhook_subscription s ON s.id = c.subscription_id
+$$;
+
+-- Change of user transaction with balances of its wallet right after the change. id orders changes of
+-- all users by commit and is used to resume event stream
+CREATE TABLE IF NOT EXISTS user_event (
+    id bigserial PRIMARY KEY,
+    transaction_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    amount MONEY_ NOT NULL,
+    currency text NOT NULL,
+    "status" TRANSACTION_STATUS NOT NULL,
+    service_id bigint NOT NULL,
+    order_id bigint NOT NULL,
+    "description" text NOT NULL,
+    "timestamp" timestamp NOT NULL,
+    balance MONEY_ NOT NULL,
+    reserved_balance MONEY_ NOT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS user_event_user_idx ON user_event (user_id, id);
+
+CREATE INDEX IF NOT EXISTS user_event_created_idx ON user_event (created_at);
+
+-- Remove at most "limit" events created before, they can't be resumed anymore. Return number of removed events
+CREATE OR REPLACE FUNCTION prune_user_events (before timestamp, "limit" int)
+    RETURNS int
+    LANGUAGE SQL
+    AS $$
+    WITH pruned AS (
+        DELETE FROM user_event e
+        WHERE e.id IN (
+                SELECT
+                    o.id
+                FROM
+                    user_event o
+                WHERE
+                    o.created_at < before
+                ORDER BY
+                    o.id
+                LIMIT "limit")
+        RETURNING
+            1
+)
+    SELECT
+        count(*)::int
+    FROM
+        pruned;
+$$;
+
+-- Record insert or status change of transaction and notify listeners of channel user_events. Trigger is deferred,
+-- so balances are as of commit. It takes the chain lock
+-- held until commit, so event ids grow in order of commit and resumed stream doesn't skip late commits
+CREATE OR REPLACE FUNCTION notify_transaction ()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    e user_event;
+BEGIN
+    -- chaining and settlement time aren't changes of transaction
+    IF TG_OP = 'UPDATE' AND OLD."status" = NEW."status" THEN
+        RETURN NULL;
+    END IF;
+    PERFORM
+        pg_advisory_xact_lock(hashtext('chain'));
+    INSERT INTO user_event (transaction_id, user_id, amount, currency, "status", service_id, order_id, "description", "timestamp", balance, reserved_balance)
+    SELECT
+        NEW.id,
+        NEW.user_id,
+        NEW.amount,
+        NEW.currency,
+        NEW."status",
+        COALESCE(NEW.service_id, 0),
+        COALESCE(NEW.order_id, 0),
+        left(COALESCE(NEW."description", ''), 1000),
+        NEW."timestamp",
+        w.balance,
+        w.reserved_balance
+    FROM
+        wallet w
+    WHERE
+        w.user_id = NEW.user_id
+        AND w.currency = NEW.currency
+    RETURNING
+        * INTO e;
+    IF e.id IS NULL THEN
+        RETURN NULL;
+    END IF;
+    PERFORM
+        pg_notify('user_events', json_build_object(
+            'id', e.id,
+            'transaction_id', e.transaction_id,
+            'user_id', e.user_id,
+            'amount', e.amount::text,
+            'currency', e.currency,
+            'status', e."status",
+            'service_id', e.service_id,
+            'order_id', e.order_id,
+            'description', e."description",
+            'timestamp', to_char(e."timestamp", 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+            'balance', e.balance::text,
+            'reserved_balance', e.reserved_balance::text)::text);
+    RETURN NULL;
+END;
+$$;
+
+CREATE CONSTRAINT TRIGGER transaction_notify
+    AFTER INSERT OR UPDATE ON "transaction" DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW
+    EXECUTE FUNCTION notify_transaction ();
+
+-- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
+CREATE OR REPLACE FUNCTION get_user_events_after (user_id bigint, after_id bigint, "limit" bigint)
+    RETURNS TABLE (
+        id bigint,
+        transaction_id bigint,
+        user_id bigint,
+        amount MONEY_,
+        currency text,
+        "status" TRANSACTION_STATUS,
+        service_id bigint,
+        order_id bigint,
+        "description" text,
+        "timestamp" timestamp,
+        balance MONEY_,
+        reserved_balance MONEY_)
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    CALL check_user (get_user_events_after.user_id);
+    RETURN QUERY
+    SELECT
+        e.id,
+        e.transaction_id,
+        e.user_id,
+        e.amount,
+        e.currency,
+        e."status",
+        e.service_id,
+        e.order_id,
+        e."description",
+        e."timestamp",
+        e.balance,
+        e.reserved_balance
+    FROM
+        user_event e
+    WHERE
+        e.user_id = get_user_events_after.user_id
+        AND e.id > after_id
+    ORDER BY
+        e.id
+    LIMIT "limit";
+END;
+$$;
+
+-- 1 base = rate quote
+CREATE TABLE IF NOT EXISTS exchange_rate (
+    id bigserial PRIMARY KEY,
+    base text NOT NULL REFERENCES currency (code),
+    quote text NOT NULL REFERENCES currency (code),
+    rate numeric(20, 10) NOT NULL CHECK (rate > 0),
+    valid_from timestamp NOT NULL,
+    valid_to timestamp,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    CHECK (base <> quote),
+    UNIQUE (base, quote, valid_from)
+);
+
+CREATE TABLE IF NOT EXISTS fx_quote (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    from_currency text NOT NULL,
+    to_currency text NOT NULL,
+    rate numeric(20, 10) NOT NULL,
+    expires_at timestamp NOT NULL,
+    used boolean NOT NULL DEFAULT FALSE
+);
+
+-- Ledger of conversions. Residue is the part of converted amount lost to rounding
+CREATE TABLE IF NOT EXISTS fx_conversion (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    from_currency text NOT NULL,
+    to_currency text NOT NULL,
+    from_amount MONEY_ NOT NULL,
+    to_amount MONEY_ NOT NULL,
+    rate numeric(20, 10) NOT NULL,
+    residue numeric(30, 12) NOT NULL,
+    quote_id bigint REFERENCES fx_quote (id),
+    from_transaction_id bigint NOT NULL REFERENCES "transaction" (id),
+    to_transaction_id bigint NOT NULL REFERENCES "transaction" (id),
+    "timestamp" timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Return rate valid at given time, inverse rate is used if there is no direct one
+-- Raise exception with message UNKNOWN_RATE if there is no rate
+CREATE OR REPLACE FUNCTION get_exchange_rate (from_currency text, to_currency text, at timestamp DEFAULT CURRENT_TIMESTAMP)
+    RETURNS numeric
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    result numeric;
+BEGIN
+    SELECT
+        r.rate INTO result
+    FROM
+        exchange_rate r
+    WHERE
+        r.base = from_currency
+        AND r.quote = to_currency
+        AND r.valid_from <= at
+        AND (r.valid_to IS NULL
+            OR r.valid_to > at)
+    ORDER BY
+        r.valid_from DESC,
+        r.id DESC
+    LIMIT 1;
+    IF found THEN
+        RETURN result;
+    END IF;
+    SELECT
+        round(1 / r.rate, 10) INTO result
+    FROM
+        exchange_rate r
+    WHERE
+        r.base = to_currency
+        AND r.quote = from_currency
+        AND r.valid_from <= at
+        AND (r.valid_to IS NULL
+            OR r.valid_to > at)
+    ORDER BY
+        r.valid_from DESC,
+        r.id DESC
+    LIMIT 1;
+    IF NOT found THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'UNKNOWN_RATE';
+    END IF;
+    RETURN result;
+END;
+$$;
+
+-- Lock current rate for user for ttl_seconds
+-- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
+-- Raise exception with message UNKNOWN_RATE if there is no rate
+CREATE OR REPLACE FUNCTION create_fx_quote (user_id bigint, from_currency text, to_currency text, ttl_seconds int)
+    RETURNS TABLE (
+        id bigint,
+        rate numeric,
+        expires_at timestamp)
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    CALL check_account (user_id);
+    RETURN QUERY INSERT INTO fx_quote AS q (user_id, from_currency, to_currency, rate, expires_at)
+        VALUES (user_id, from_currency, to_currency, get_exchange_rate (from_currency, to_currency), CURRENT_TIMESTAMP + make_interval(secs => ttl_seconds))
+    RETURNING
+        q.id,
+        q.rate,
+        q.expires_at;
+END;
+$$;
+
+-- Move amount from from_currency wallet to to_currency wallet of the same user.
+-- Quoted rate is used if quote_id is given, current rate otherwise.
+-- Converted amount is rounded to exponent of to_currency, the rest is stored as residue
+-- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
+-- Raise exception with message UNKNOWN_RATE if there is no rate
+-- Raise exception with message UNKNOWN_QUOTE if quote doesn't exist, is used or is for other currencies
+-- Raise exception with message QUOTE_EXPIRED if quote is expired
+-- Raise exception with message NOT_ENOUGH_MONEY if amount greater than balance
+-- Raise exception with message AMOUNT_TOO_SMALL if converted amount rounds to zero
+CREATE OR REPLACE FUNCTION convert_currency (user_id bigint, amount MONEY_, from_currency text, to_currency text, quote_id bigint DEFAULT NULL)
+    RETURNS TABLE (
+        id bigint,
+        rate numeric,
+        to_amount MONEY_,
+        residue numeric)
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    applied_rate numeric;
+    exact numeric;
+    converted MONEY_;
+    from_id bigint;
+    to_id bigint;
+    conversion_id bigint;
+    to_exponent int;
+BEGIN
+    CALL check_account (user_id);
+    IF quote_id IS NULL THEN
+        applied_rate := get_exchange_rate (from_currency, to_currency);
+    ELSE
+        UPDATE
+            fx_quote q
+        SET
+            used = TRUE
+        WHERE
+            q.id = convert_currency.quote_id
+            AND q.user_id = convert_currency.user_id
+            AND q.from_currency = convert_currency.from_currency
+            AND q.to_currency = convert_currency.to_currency
+            AND NOT q.used
+        RETURNING
+            CASE WHEN q.expires_at > CURRENT_TIMESTAMP THEN
+                q.rate
+            END INTO applied_rate;
+        IF NOT found THEN
+            RAISE EXCEPTION
+                USING MESSAGE = 'UNKNOWN_QUOTE';
+            END IF;
+            IF applied_rate IS NULL THEN
+                RAISE EXCEPTION
+                    USING MESSAGE = 'QUOTE_EXPIRED';
+                END IF;
+    END IF;
+    SELECT
+        c.exponent INTO to_exponent
+    FROM
+        currency c
+    WHERE
+        c.code = to_currency;
+    exact := amount * applied_rate;
+    converted := round(exact, to_exponent);
+    IF converted <= 0 THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'AMOUNT_TOO_SMALL';
+    END IF;
+    UPDATE
+        wallet w
+    SET
+        balance = w.balance - amount
+    WHERE
+        w.user_id = convert_currency.user_id
+        AND w.currency = from_currency
+        AND w.balance >= amount;
+    IF NOT found THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'NOT_ENOUGH_MONEY';
+    END IF;
+    CALL ensure_wallet (user_id, to_currency);
+    UPDATE
+        wallet w
+    SET
+        balance = w.balance + converted
+    WHERE
+        w.user_id = convert_currency.user_id
+        AND w.currency = to_currency;
+    INSERT INTO "transaction" (user_id, amount, currency, "status", "description")
+        VALUES (user_id, - amount, from_currency, 'DONE', format('Конвертация %s → %s по курсу %s', from_currency, to_currency, applied_rate))
+    RETURNING
+        "transaction".id INTO from_id;
+    INSERT INTO "transaction" (user_id, amount, currency, "status", "description")
+        VALUES (user_id, converted, to_currency, 'DONE', format('Конвертация %s → %s по курсу %s', from_currency, to_currency, applied_rate))
+    RETURNING
+        "transaction".id INTO to_id;
+    INSERT INTO fx_conversion (user_id, from_currency, to_currency, from_amount, to_amount, rate, residue, quote_id, from_transaction_id, to_transaction_id)
+        VALUES (user_id, from_currency, to_currency, amount, converted, applied_rate, exact - converted, quote_id, from_id, to_id)
+    RETURNING
+        fx_conversion.id INTO conversion_id;
+    RETURN QUERY
+    SELECT
+        conversion_id,
+        applied_rate,
+        converted,
+        exact - converted;
+END;
+$$;
+
+-- Grant promo credits to existing user
+-- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
+-- Raise exception foreign_key_violation if currency is unknown
+CREATE OR REPLACE FUNCTION grant_promo (user_id bigint, amount MONEY_, currency text, expires_at timestamp, description text)
+    RETURNS TABLE (
+        id bigint,
+        created_at timestamp)
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    CALL check_account (user_id);
+    CALL ensure_wallet (user_id, currency);
+    INSERT INTO promo_grant AS g (user_id, currency, amount, remaining, expires_at, "description")
+        VALUES (user_id, currency, amount, amount, expires_at, description)
+    RETURNING
+        g.id,
+        g.created_at INTO grant_promo.id, grant_promo.created_at;
+    CALL audit_change ('promo_grant', grant_promo.id::text, user_id, currency, jsonb_build_object('amount', amount, 'expires_at', expires_at, 'description', description));
+    RETURN NEXT;
+END;
+$$;
+
+-- Move remaining of expired grants to expired and return number of swept grants
+CREATE OR REPLACE FUNCTION expire_promo_grants ()
+    RETURNS int
+    LANGUAGE SQL
+    AS $$
+    WITH swept AS (
+        UPDATE
+            promo_grant g
+        SET
+            expired = g.expired + g.remaining,
+            remaining = 0
+        WHERE
+            g.remaining > 0
+            AND g.expires_at <= CURRENT_TIMESTAMP
+        RETURNING
+            1
+)
+    SELECT
+        count(*)::int
+    FROM
+        swept;
+$$;
+
+-- Set credit limit and record the change
+-- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
+-- Raise exception foreign_key_violation if currency is unknown
+CREATE OR REPLACE PROCEDURE set_credit_limit (user_id bigint, currency text, new_limit MONEY_, "operator" text, reason text)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    old_limit MONEY_;
+BEGIN
+    CALL check_user (user_id);
+    CALL ensure_wallet (user_id, currency);
+    SELECT
+        w.credit_limit INTO old_limit
+    FROM
+        wallet w
+    WHERE
+        w.user_id = set_credit_limit.user_id
+        AND w.currency = set_credit_limit.currency
+    FOR UPDATE;
+    UPDATE
+        wallet w
+    SET
+        credit_limit = new_limit
+    WHERE
+        w.user_id = set_credit_limit.user_id
+        AND w.currency = set_credit_limit.currency;
+    INSERT INTO credit_limit_change (user_id, currency, old_limit, new_limit, "operator", reason)
+        VALUES (user_id, currency, old_limit, new_limit, "operator", reason);
+    CALL audit_change ('credit_limit', user_id || ':' || currency, user_id, currency, jsonb_build_object('old_limit', old_limit, 'new_limit', new_limit, 'operator', "operator", 'reason', reason));
+END;
+$$;
+
+CREATE TYPE VELOCITY_METRIC AS ENUM (
+    'AMOUNT',
+    'COUNT'
+);
+
+-- Rule without user_id is global. User rule replaces global rule with the same metric and window
+CREATE TABLE IF NOT EXISTS velocity_rule (
+    id bigserial PRIMARY KEY,
+    user_id bigint,
+    currency text NOT NULL DEFAULT 'RUB' REFERENCES currency (code),
+    metric VELOCITY_METRIC NOT NULL,
+    window_seconds int NOT NULL CHECK (window_seconds > 0),
+    max_amount MONEY_ NOT NULL DEFAULT 0,
+    max_count int NOT NULL DEFAULT 0,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Spending checked by velocity rules. Payments and escrows of the operation are linked to its spending
+-- by transaction_ids. Canceled and refunded money is released by negative rows with released_id of the
+-- spending and its created_at, operations is -1 when the whole operation is released
+CREATE TABLE IF NOT EXISTS spending (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    currency text NOT NULL,
+    amount MONEY_ NOT NULL,
+    operation text NOT NULL,
+    operations int NOT NULL DEFAULT 1,
+    transaction_ids bigint[] NOT NULL DEFAULT '{}',
+    released_id bigint REFERENCES spending (id),
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS spending_user_idx ON spending (user_id, currency, created_at);
+
+CREATE INDEX IF NOT EXISTS spending_transaction_idx ON spending USING gin (transaction_ids);
+
+CREATE INDEX IF NOT EXISTS spending_released_idx ON spending (released_id)
+WHERE
+    released_id IS NOT NULL;
+
+-- Serialize velocity checks and releases of spending of the user until the end of transaction
+CREATE OR REPLACE PROCEDURE lock_spending (user_id bigint)
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    PERFORM
+        pg_advisory_xact_lock(hashtextextended ('spending:' || user_id, 0));
+END;
+$$;
+
+-- Record spending of operation. Payments and escrows of the user written next in the transaction
+-- are linked to it, until spending of another operation is recorded
+CREATE OR REPLACE PROCEDURE record_spending (user_id bigint, currency text, amount MONEY_, operation text)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    spending_id bigint;
+BEGIN
+    INSERT INTO spending (user_id, currency, amount, operation)
+        VALUES (user_id, currency, amount, operation)
+    RETURNING
+        id INTO spending_id;
+    PERFORM
+        set_config('spending.id', spending_id::text, TRUE);
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION link_spending ()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    UPDATE
+        spending s
+    SET
+        transaction_ids = s.transaction_ids || NEW.id
+    WHERE
+        s.id = NULLIF (current_setting('spending.id', TRUE), '')::bigint
+        AND s.user_id = NEW.user_id
+        AND s.currency = NEW.currency;
+    RETURN NULL;
+END;
+$$;
+
+CREATE TRIGGER transaction_link_spending
+    AFTER INSERT ON "transaction"
+    FOR EACH ROW
+    WHEN (NEW.kind IN ('PAYMENT', 'ESCROW') AND NEW.amount < 0)
+    EXECUTE FUNCTION link_spending ();
+
+-- Release at most amount of spending linked to transaction, so it isn't counted by velocity rules
+CREATE OR REPLACE PROCEDURE release_spending (transaction_id bigint, amount MONEY_)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    s spending;
+    remaining MONEY_;
+BEGIN
+    SELECT
+        * INTO s
+    FROM
+        spending sp
+    WHERE
+        sp.transaction_ids @> ARRAY[release_spending.transaction_id];
+    IF NOT found THEN
+        RETURN;
+    END IF;
+    CALL lock_spending (s.user_id);
+    SELECT
+        s.amount + COALESCE(sum(r.amount), 0) INTO remaining
+    FROM
+        spending r
+    WHERE
+        r.released_id = s.id;
+    IF remaining <= 0 THEN
+        RETURN;
+    END IF;
+    INSERT INTO spending (user_id, currency, amount, operation, operations, released_id, created_at)
+        VALUES (s.user_id, s.currency, - LEAST (amount, remaining), 'release', CASE WHEN amount >= remaining THEN
+                -1
+            ELSE
+                0
+            END, s.id, s.created_at);
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION release_canceled_spending ()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    CALL release_spending (NEW.id, - NEW.amount);
+    RETURN NULL;
+END;
+$$;
+
+CREATE TRIGGER transaction_release_spending
+    AFTER UPDATE OF "status" ON "transaction"
+    FOR EACH ROW
+    WHEN (OLD."status" = 'PENDING' AND NEW."status" = 'CANCELED' AND NEW.kind IN ('PAYMENT', 'ESCROW'))
+    EXECUTE FUNCTION release_canceled_spending ();
+
+CREATE TABLE IF NOT EXISTS velocity_violation (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    currency text NOT NULL,
+    rule_id bigint REFERENCES velocity_rule (id) ON DELETE SET NULL,
+    operation text NOT NULL,
+    amount MONEY_ NOT NULL,
+    -- spent amount or number of operations in window of the rule before the operation
+    used numeric NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS account_status_change (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES "user" (id),
+    old_status ACCOUNT_STATUS NOT NULL,
+    new_status ACCOUNT_STATUS NOT NULL,
+    "operator" text,
+    reason text NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Change account status and record the change. Closed account can't be reopened
+-- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
+-- Raise exception with message INVALID_STATUS_TRANSITION if account is closed
+-- Raise exception with message ACCOUNT_NOT_EMPTY if closing account has balance or reservations
+CREATE OR REPLACE PROCEDURE set_account_status (user_id bigint, new_status ACCOUNT_STATUS, "operator" text, reason text)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    old_status ACCOUNT_STATUS;
+BEGIN
+    SELECT
+        u.status INTO old_status
+    FROM
+        "user" u
+    WHERE
+        u.id = user_id
+    FOR UPDATE;
+    IF NOT found THEN
+        RAISE EXCEPTION no_data_found
+            USING message = 'UNKNOWN_USER';
+        END IF;
+        IF old_status = new_status THEN
+            RETURN;
+        END IF;
+        IF old_status = 'CLOSED' THEN
+            RAISE EXCEPTION
+                USING MESSAGE = 'INVALID_STATUS_TRANSITION';
+            END IF;
+            IF new_status = 'CLOSED' THEN
+                PERFORM
+                    1
+                FROM
+                    wallet w
+                WHERE
+                    w.user_id = set_account_status.user_id
+                    AND (w.balance <> 0
+                        OR w.reserved_balance <> 0);
+                IF found THEN
+                    RAISE EXCEPTION
+                        USING MESSAGE = 'ACCOUNT_NOT_EMPTY';
+                    END IF;
+                END IF;
+                UPDATE
+                    "user" u
+                SET
+                    status = new_status
+                WHERE
+                    u.id = user_id;
+                INSERT INTO account_status_change (user_id, old_status, new_status, "operator", reason)
+                    VALUES (user_id, old_status, new_status, "operator", reason);
+                CALL audit_change ('account', user_id::text, user_id, NULL, jsonb_build_object('old_status', old_status, 'new_status', new_status, 'operator', "operator", 'reason', reason));
+END;
+$$;
+
+-- Allowed transitions of order lines:
+-- CREATED -> RESERVED, CANCELED
+-- RESERVED -> FULFILLED, CANCELED
+-- FULFILLED -> REFUNDED
+CREATE TYPE ORDER_STATUS AS ENUM (
+    'CREATED',
+    'RESERVED',
+    'FULFILLED',
+    'CANCELED',
+    'REFUNDED'
+);
+
+-- Order is identified by order_id of reservation. Status is derived from statuses of lines, see refresh_order_status
+CREATE TABLE IF NOT EXISTS "order" (
+    id bigint PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES "user" (id),
+    -- sum of amounts of lines
+    amount MONEY_ NOT NULL CHECK (amount > 0),
+    currency text NOT NULL DEFAULT 'RUB' REFERENCES currency (code),
+    "status" ORDER_STATUS NOT NULL DEFAULT 'CREATED',
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Line of order is reserved, recognized and canceled as PAYMENT transaction payment_id
+CREATE TABLE IF NOT EXISTS order_line (
+    order_id bigint NOT NULL REFERENCES "order" (id),
+    service_id bigint NOT NULL REFERENCES "service" (id),
+    amount MONEY_ NOT NULL CHECK (amount > 0),
+    "status" ORDER_STATUS NOT NULL DEFAULT 'CREATED',
+    payment_id bigint REFERENCES "transaction" (id),
+    updated_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (order_id, service_id)
+);
+
+CREATE INDEX IF NOT EXISTS order_line_payment_idx ON order_line (payment_id);
+
+CREATE OR REPLACE FUNCTION order_transition_allowed (old_status ORDER_STATUS, new_status ORDER_STATUS)
+    RETURNS boolean
+    LANGUAGE SQL
+    IMMUTABLE
+    AS $$
+    SELECT
+        (old_status, new_status) IN (('CREATED', 'RESERVED'), ('CREATED', 'CANCELED'), ('RESERVED', 'FULFILLED'), ('RESERVED', 'CANCELED'), ('FULFILLED', 'REFUNDED'));
+$$;
+
+-- Order is CREATED or RESERVED while any line is, CANCELED if all lines are canceled,
+-- FULFILLED while any line is fulfilled and REFUNDED otherwise
+CREATE OR REPLACE PROCEDURE refresh_order_status (order_id bigint)
+LANGUAGE SQL
+AS $$
+    UPDATE
+        "order" o
+    SET
+        status = s.status,
+        updated_at = CURRENT_TIMESTAMP
+    FROM (
+        SELECT
+            CASE WHEN bool_or(l.status = 'CREATED') THEN
+                'CREATED'
+            WHEN bool_or(l.status = 'RESERVED') THEN
+                'RESERVED'
+            WHEN bool_and(l.status = 'CANCELED') THEN
+                'CANCELED'
+            WHEN bool_or(l.status = 'FULFILLED') THEN
+                'FULFILLED'
+            ELSE
+                'REFUNDED'
+            END::ORDER_STATUS status
+        FROM
+            order_line l
+        WHERE
+            l.order_id = refresh_order_status.order_id) s
+WHERE
+    o.id = refresh_order_status.order_id
+    AND o.status <> s.status;
+$$;
+
+-- Raise exception with message UNKNOWN_ORDER if line doesn't exist
+-- Raise exception with message INVALID_ORDER_TRANSITION if transition isn't allowed
+CREATE OR REPLACE PROCEDURE set_order_line_status (order_id bigint, service_id bigint, new_status ORDER_STATUS, payment_id bigint DEFAULT NULL)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    old_status ORDER_STATUS;
+BEGIN
+    SELECT
+        l.status INTO old_status
+    FROM
+        order_line l
+    WHERE
+        l.order_id = set_order_line_status.order_id
+        AND l.service_id = set_order_line_status.service_id
+    FOR UPDATE;
+    IF NOT found THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'UNKNOWN_ORDER';
+        END IF;
+        IF NOT order_transition_allowed (old_status, new_status) THEN
+            RAISE EXCEPTION
+                USING MESSAGE = 'INVALID_ORDER_TRANSITION';
+            END IF;
+            UPDATE
+                order_line l
+            SET
+                status = new_status,
+                payment_id = COALESCE(set_order_line_status.payment_id, l.payment_id),
+                updated_at = CURRENT_TIMESTAMP
+            WHERE
+                l.order_id = set_order_line_status.order_id
+                AND l.service_id = set_order_line_status.service_id;
+            CALL refresh_order_status (order_id);
+END;
+$$;
+
+-- Unknown order is created with single line by its first reservation
+-- Raise exception with message ORDER_MISMATCH if order has another user or currency or has no such line
+-- Raise exception with message INVALID_ORDER_TRANSITION if line isn't in CREATED status
+CREATE OR REPLACE PROCEDURE reserve_order (order_id bigint, user_id bigint, amount MONEY_, currency text, service_id bigint, payment_id bigint)
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    INSERT INTO "order" (id, user_id, amount, currency)
+        VALUES (order_id, user_id, amount, currency)
+    ON CONFLICT
+        DO NOTHING;
+    IF found THEN
+        INSERT INTO order_line (order_id, service_id, amount)
+            VALUES (order_id, service_id, amount);
+    END IF;
+    PERFORM
+        1
+    FROM
+        "order" o
+    WHERE
+        o.id = reserve_order.order_id
+        AND (o.user_id <> reserve_order.user_id
+            OR o.currency <> reserve_order.currency)
+    FOR UPDATE;
+    IF found THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'ORDER_MISMATCH';
+        END IF;
+        PERFORM
+            1
+        FROM
+            order_line l
+        WHERE
+            l.order_id = reserve_order.order_id
+            AND l.service_id = reserve_order.service_id
+            AND l.amount = reserve_order.amount;
+        IF NOT found THEN
+            RAISE EXCEPTION
+                USING MESSAGE = 'ORDER_MISMATCH';
+            END IF;
+            CALL set_order_line_status (order_id, service_id, 'RESERVED', payment_id);
+END;
+$$;
+
+-- Move order line of payment to new status. Payments made before orders were introduced have no line
+CREATE OR REPLACE PROCEDURE settle_order (payment_id bigint, new_status ORDER_STATUS)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    line order_line;
+BEGIN
+    SELECT
+        * INTO line
+    FROM
+        order_line l
+    WHERE
+        l.payment_id = settle_order.payment_id;
+    IF found THEN
+        CALL set_order_line_status (line.order_id, line.service_id, new_status);
+    END IF;
+END;
+$$;
+
+-- Lines are given by service_ids and amounts of the same length, services must be distinct
+-- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
+-- Raise exception with message UNKNOWN_SERVICE or SERVICE_INACTIVE, see check_service
+-- Raise exception unique_violation if order exists
+CREATE OR REPLACE PROCEDURE create_order (order_id bigint, user_id bigint, currency text, service_ids bigint[], amounts MONEY_[])
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    CALL check_account (user_id);
+    FOR i IN 1..array_length(service_ids, 1)
+    LOOP
+        CALL check_service (service_ids[i], NULL, FALSE);
+    END LOOP;
+    INSERT INTO "order" (id, user_id, amount, currency)
+        VALUES (order_id, user_id, (
+                SELECT
+                    sum(a)
+                FROM unnest(amounts) a), currency);
+    INSERT INTO order_line (order_id, service_id, amount)
+    SELECT
+        create_order.order_id,
+        t.service_id,
+        t.amount
+    FROM
+        unnest(service_ids, amounts) AS t (service_id, amount);
+END;
+$$;
+
+-- Raise exception with message UNKNOWN_ORDER if line doesn't exist
+-- Raise exception with message INVALID_ORDER_TRANSITION if line isn't reserved
+CREATE OR REPLACE PROCEDURE recognize_order_line (order_id bigint, service_id bigint)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    o "order";
+    line order_line;
+BEGIN
+    SELECT
+        * INTO line
+    FROM
+        order_line l
+    WHERE
+        l.order_id = recognize_order_line.order_id
+        AND l.service_id = recognize_order_line.service_id
+    FOR UPDATE;
+    IF NOT found THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'UNKNOWN_ORDER';
+        END IF;
+        IF line.status <> 'RESERVED' THEN
+            RAISE EXCEPTION
+                USING MESSAGE = 'INVALID_ORDER_TRANSITION';
+            END IF;
+            SELECT
+                * INTO o
+            FROM
+                "order"
+            WHERE
+                id = recognize_order_line.order_id;
+            CALL recognize_revenue (o.user_id, line.amount, o.currency, line.service_id, o.id);
+END;
+$$;
+
+-- Reserved line is canceled with its reservation, created line is just marked canceled
+-- Raise exception with message UNKNOWN_ORDER if line doesn't exist
+-- Raise exception with message INVALID_ORDER_TRANSITION if line is fulfilled or already closed
+CREATE OR REPLACE PROCEDURE cancel_order_line (order_id bigint, service_id bigint)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    o "order";
+    line order_line;
+BEGIN
+    SELECT
+        * INTO line
+    FROM
+        order_line l
+    WHERE
+        l.order_id = cancel_order_line.order_id
+        AND l.service_id = cancel_order_line.service_id
+    FOR UPDATE;
+    IF NOT found THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'UNKNOWN_ORDER';
+        END IF;
+        IF line.status <> 'RESERVED' THEN
+            CALL set_order_line_status (order_id, service_id, 'CANCELED');
+            RETURN;
+        END IF;
+        SELECT
+            * INTO o
+        FROM
+            "order"
+        WHERE
+            id = cancel_order_line.order_id;
+        CALL cancel_transaction (o.user_id, line.amount, o.currency, line.service_id, o.id);
+END;
+$$;
+
+-- Return payment of fulfilled line to balance. Promo part is returned to promo grants, platform fees
+-- of the payment are returned in proportion of the line to the paid amount
+-- Refund is allowed for frozen accounts
+-- Raise exception with message UNKNOWN_ORDER if line doesn't exist
+-- Raise exception with message INVALID_ORDER_TRANSITION if line isn't fulfilled
+CREATE OR REPLACE PROCEDURE refund_order_line (order_id bigint, service_id bigint, description text DEFAULT NULL)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    o "order";
+    line order_line;
+    paid MONEY_;
+    promo MONEY_;
+    fee MONEY_;
+BEGIN
+    SELECT
+        * INTO line
+    FROM
+        order_line l
+    WHERE
+        l.order_id = refund_order_line.order_id
+        AND l.service_id = refund_order_line.service_id
+    FOR UPDATE;
+    IF NOT found THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'UNKNOWN_ORDER';
+        END IF;
+        CALL set_order_line_status (order_id, service_id, 'REFUNDED');
+        SELECT
+            * INTO o
+        FROM
+            "order"
+        WHERE
+            id = refund_order_line.order_id;
+        CALL check_account (o.user_id, allow_frozen => TRUE);
+        SELECT
+            - t.amount,
+            t.promo_amount INTO paid,
+            promo
+        FROM
+            "transaction" t
+        WHERE
+            t.id = line.payment_id;
+        CALL release_spending (line.payment_id, line.amount);
+        CALL restore_promo (line.payment_id);
+        INSERT INTO "transaction" (user_id, amount, currency, service_id, order_id, "status", "description", kind, promo_amount)
+            VALUES (o.user_id, line.amount, o.currency, line.service_id, o.id, 'DONE', "description", 'REFUND', - promo);
+        -- fees are returned in proportion to refunded amount as fee rows of the payment
+        SELECT
+            COALESCE(money_mul_ratio (- sum(t.amount), line.amount, paid), 0) INTO fee
+        FROM
+            "transaction" t
+        WHERE
+            t.fee_of = line.payment_id
+            AND t.kind = 'FEE'
+            AND t."status" = 'DONE'
+            AND t.amount < 0;
+        IF fee > 0 THEN
+            INSERT INTO "transaction" (user_id, amount, currency, service_id, order_id, "status", "description", kind, fee_of)
+                VALUES (o.user_id, fee, o.currency, line.service_id, o.id, 'DONE', 'Возврат комиссии', 'FEE', line.payment_id);
+        END IF;
+        UPDATE
+            wallet w
+        SET
+            balance = w.balance + line.amount - promo + fee
+        WHERE
+            w.user_id = o.user_id
+            AND w.currency = o.currency;
+END;
+$$;
+
+-- Lock order and raise exception with message UNKNOWN_ORDER if it doesn't exist
+-- or with message INVALID_ORDER_TRANSITION if its status isn't one of statuses
+CREATE OR REPLACE PROCEDURE check_order_status (order_id bigint, statuses ORDER_STATUS[])
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    current_status ORDER_STATUS;
+BEGIN
+    SELECT
+        o.status INTO current_status
+    FROM
+        "order" o
+    WHERE
+        o.id = check_order_status.order_id
+    FOR UPDATE;
+    IF NOT found THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'UNKNOWN_ORDER';
+        END IF;
+        IF current_status <> ALL (statuses) THEN
+            RAISE EXCEPTION
+                USING MESSAGE = 'INVALID_ORDER_TRANSITION';
+            END IF;
+END;
+$$;
+
+-- Recognize all reserved lines of the order
+CREATE OR REPLACE PROCEDURE recognize_order (order_id bigint)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    line_service_id bigint;
+BEGIN
+    CALL check_order_status (order_id, ARRAY['RESERVED']::ORDER_STATUS[]);
+    FOR line_service_id IN
+    SELECT
+        l.service_id
+    FROM
+        order_line l
+    WHERE
+        l.order_id = recognize_order.order_id
+        AND l.status = 'RESERVED'
+    ORDER BY
+        l.service_id LOOP
+            CALL recognize_order_line (order_id, line_service_id);
+        END LOOP;
+END;
+$$;
+
+-- Cancel all created and reserved lines of the order
+CREATE OR REPLACE PROCEDURE cancel_order (order_id bigint)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    line_service_id bigint;
+BEGIN
+    CALL check_order_status (order_id, ARRAY['CREATED', 'RESERVED']::ORDER_STATUS[]);
+    FOR line_service_id IN
+    SELECT
+        l.service_id
+    FROM
+        order_line l
+    WHERE
+        l.order_id = cancel_order.order_id
+        AND l.status IN ('CREATED', 'RESERVED')
+    ORDER BY
+        l.service_id LOOP
+            CALL cancel_order_line (order_id, line_service_id);
+        END LOOP;
+END;
+$$;
+
+-- Refund all fulfilled lines of the order
+CREATE OR REPLACE PROCEDURE refund_order (order_id bigint, description text DEFAULT NULL)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    line_service_id bigint;
+BEGIN
+    CALL check_order_status (order_id, ARRAY['FULFILLED']::ORDER_STATUS[]);
+    FOR line_service_id IN
+    SELECT
+        l.service_id
+    FROM
+        order_line l
+    WHERE
+        l.order_id = refund_order.order_id
+        AND l.status = 'FULFILLED'
+    ORDER BY
+        l.service_id LOOP
+            CALL refund_order_line (order_id, line_service_id, description);
+        END LOOP;
+END;
+$$;
+
+CREATE TYPE SUBSCRIPTION_PERIOD AS ENUM (
+    'DAY',
+    'WEEK',
+    'MONTH',
+    'YEAR'
+);
+
+-- PAUSED subscriptions aren't charged until resumed, CANCELED can't be resumed
+CREATE TYPE SUBSCRIPTION_STATUS AS ENUM (
+    'ACTIVE',
+    'PAUSED',
+    'CANCELED'
+);
+
+CREATE TABLE IF NOT EXISTS subscription (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES "user" (id),
+    service_id bigint NOT NULL REFERENCES "service" (id),
+    amount MONEY_ NOT NULL CHECK (amount > 0),
+    currency text NOT NULL DEFAULT 'RUB' REFERENCES currency (code),
+    period SUBSCRIPTION_PERIOD NOT NULL,
+    "description" text NOT NULL DEFAULT '',
+    "status" SUBSCRIPTION_STATUS NOT NULL DEFAULT 'ACTIVE',
+    next_run_at timestamp NOT NULL,
+    -- failed attempts to charge since the last successful charge
+    failures int NOT NULL DEFAULT 0,
+    last_error text,
+    -- next attempt after failure, also hides claimed subscription from other schedulers
+    retry_at timestamp,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS subscription_due_idx ON subscription (COALESCE(retry_at, next_run_at))
+WHERE
+    status = 'ACTIVE';
+
+CREATE TABLE IF NOT EXISTS subscription_charge (
+    id bigserial PRIMARY KEY,
+    subscription_id bigint NOT NULL REFERENCES subscription (id),
+    payment_id bigint NOT NULL REFERENCES "transaction" (id),
+    -- start of the charged period
+    period_start timestamp NOT NULL,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Claim up to limit due subscriptions and hide them from other callers for lease_seconds
+CREATE OR REPLACE FUNCTION claim_due_subscriptions ("limit" int, lease_seconds int)
+    RETURNS SETOF subscription
+    LANGUAGE SQL
+    AS $$
+    UPDATE
+        subscription s
+    SET
+        retry_at = CURRENT_TIMESTAMP + make_interval(secs => lease_seconds)
+    WHERE
+        s.id IN (
+            SELECT
+                d.id
+            FROM
+                subscription d
+            WHERE
+                d.status = 'ACTIVE'
+                AND COALESCE(d.retry_at, d.next_run_at) <= CURRENT_TIMESTAMP
+            ORDER BY
+                COALESCE(d.retry_at, d.next_run_at)
+            LIMIT "limit"
+            FOR UPDATE
+                SKIP LOCKED)
+    RETURNING
+        s.*;
+$$;
+
+-- Charge the subscription for the period starting at next_run_at: amount with fee is paid at once
+-- like reservation recognized immediately. Periods missed while subscription was paused or failing
+-- aren't charged. Return id of PAYMENT transaction
+-- Raise exception with message SUBSCRIPTION_NOT_DUE if subscription isn't active or isn't due yet
+-- Raise exceptions of check_service and reserve_money
+CREATE OR REPLACE FUNCTION charge_subscription (subscription_id bigint, fee MONEY_, fee_description text, promo_limit MONEY_, description text)
+    RETURNS bigint
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    sub subscription;
+    payment_id bigint;
+    promo MONEY_;
+    next_run timestamp;
+BEGIN
+    SELECT
+        * INTO sub
+    FROM
+        subscription s
+    WHERE
+        s.id = charge_subscription.subscription_id
+    FOR UPDATE;
+    IF NOT found OR sub.status <> 'ACTIVE' OR sub.next_run_at > CURRENT_TIMESTAMP THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'SUBSCRIPTION_NOT_DUE';
+        END IF;
+        CALL check_service (sub.service_id, NULL, FALSE);
+        payment_id := reserve_payment (sub.user_id, sub.amount, sub.currency, sub.service_id, NULL, description, fee, fee_description, promo_limit);
+        UPDATE
+            "transaction" t
+        SET
+            status = 'DONE'
+        WHERE
+            t.id = payment_id
+        RETURNING
+            t.promo_amount INTO promo;
+        fee := settle_fees (payment_id, 'DONE');
+        UPDATE
+            wallet w
+        SET
+            reserved_balance = w.reserved_balance - sub.amount + promo - fee
+        WHERE
+            w.user_id = sub.user_id
+            AND w.currency = sub.currency;
+        INSERT INTO subscription_charge (subscription_id, payment_id, period_start)
+            VALUES (sub.id, payment_id, sub.next_run_at);
+        next_run := sub.next_run_at;
+        LOOP
+            next_run := next_run + ('1 ' || lower(sub.period::text))::interval;
+            EXIT
+            WHEN next_run > CURRENT_TIMESTAMP;
+        END LOOP;
+        UPDATE
+            subscription s
+        SET
+            next_run_at = next_run,
+            failures = 0,
+            last_error = NULL,
+            retry_at = NULL,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE
+            s.id = sub.id;
+        RETURN payment_id;
+END;
+$$;
+
+-- HELD money of buyer is released to seller or refunded to buyer. DISPUTED escrow is settled
+-- only explicitly, timeout doesn't apply to it
+CREATE TYPE ESCROW_STATUS AS ENUM (
+    'HELD',
+    'DISPUTED',
+    'RELEASED',
+    'REFUNDED'
+);
+
+-- Settlement of HELD escrow after expires_at
+CREATE TYPE ESCROW_TIMEOUT_POLICY AS ENUM (
+    'RELEASE',
+    'REFUND'
+);
+
+CREATE TABLE IF NOT EXISTS escrow (
+    id bigserial PRIMARY KEY,
+    buyer_id bigint NOT NULL REFERENCES "user" (id),
+    seller_id bigint NOT NULL REFERENCES "user" (id),
+    amount MONEY_ NOT NULL CHECK (amount > 0),
+    currency text NOT NULL DEFAULT 'RUB' REFERENCES currency (code),
+    "description" text NOT NULL DEFAULT '',
+    "status" ESCROW_STATUS NOT NULL DEFAULT 'HELD',
+    timeout_policy ESCROW_TIMEOUT_POLICY NOT NULL,
+    expires_at timestamp NOT NULL,
+    -- ESCROW transactions of buyer and seller
+    payment_id bigint REFERENCES "transaction" (id),
+    credit_id bigint REFERENCES "transaction" (id),
+    dispute_reason text,
+    created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp DEFAULT CURRENT_TIMESTAMP,
+    CHECK (buyer_id <> seller_id)
+);
+
+CREATE INDEX IF NOT EXISTS escrow_expires_idx ON escrow (expires_at)
+WHERE
+    status = 'HELD';
+
+CREATE INDEX IF NOT EXISTS escrow_buyer_idx ON escrow (buyer_id);
+
+CREATE INDEX IF NOT EXISTS escrow_seller_idx ON escrow (seller_id);
+
+-- Hold amount of buyer like reservation and show pending credit to seller. Return id of escrow
+-- Raise exceptions of check_account for buyer and of check_recipient for seller
+-- Raise exception with message NOT_ENOUGH_MONEY or CREDIT_LIMIT_EXCEEDED if buyer can't pay
+CREATE OR REPLACE FUNCTION create_escrow (buyer_id bigint, seller_id bigint, amount MONEY_, currency text, description text, timeout_policy ESCROW_TIMEOUT_POLICY, expires_at timestamp)
+    RETURNS bigint
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    escrow_id bigint;
+    hold_id bigint;
+    pending_credit_id bigint;
+    note text;
+    has_credit boolean;
+BEGIN
+    CALL check_account (buyer_id);
+    CALL check_recipient (seller_id);
+    CALL ensure_wallet (seller_id, currency);
+    INSERT INTO escrow (buyer_id, seller_id, amount, currency, "description", timeout_policy, expires_at)
+        VALUES (buyer_id, seller_id, amount, currency, COALESCE(description, ''), timeout_policy, expires_at)
+    RETURNING
+        id INTO escrow_id;
+    note := 'Эскроу №' || escrow_id || CASE WHEN COALESCE(description, '') <> '' THEN
+        ': ' || description
+    ELSE
+        ''
+    END;
+    INSERT INTO "transaction" (user_id, amount, currency, "status", "description", kind)
+        VALUES (buyer_id, - amount, currency, 'PENDING', note, 'ESCROW')
+    RETURNING
+        id INTO hold_id;
+    UPDATE
+        wallet w
+    SET
+        reserved_balance = w.reserved_balance + amount,
+        balance = w.balance - amount
+    WHERE
+        w.user_id = create_escrow.buyer_id
+        AND w.currency = create_escrow.currency
+        AND w.balance + w.credit_limit >= amount;
+    IF NOT found THEN
+        SELECT
+            w.credit_limit > 0 INTO has_credit
+        FROM
+            wallet w
+        WHERE
+            w.user_id = create_escrow.buyer_id
+            AND w.currency = create_escrow.currency;
+        IF has_credit THEN
+            RAISE EXCEPTION
+                USING MESSAGE = 'CREDIT_LIMIT_EXCEEDED';
+            END IF;
+            RAISE EXCEPTION
+                USING MESSAGE = 'NOT_ENOUGH_MONEY';
+        END IF;
+        INSERT INTO "transaction" (user_id, amount, currency, "status", "description", kind)
+            VALUES (seller_id, amount, currency, 'PENDING', note, 'ESCROW')
+        RETURNING
+            id INTO pending_credit_id;
+        UPDATE
+            escrow e
+        SET
+            payment_id = hold_id,
+            credit_id = pending_credit_id
+        WHERE
+            e.id = escrow_id;
+        RETURN escrow_id;
+END;
+$$;
+
+-- Lock escrow which is in one of statuses
+-- Raise exception with message UNKNOWN_ESCROW if escrow doesn't exist
+-- Raise exception with message INVALID_ESCROW_TRANSITION if escrow is in another status
+CREATE OR REPLACE FUNCTION lock_escrow (escrow_id bigint, statuses ESCROW_STATUS[])
+    RETURNS escrow
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    e escrow;
+BEGIN
+    SELECT
+        * INTO e
+    FROM
+        escrow
+    WHERE
+        id = lock_escrow.escrow_id
+    FOR UPDATE;
+    IF NOT found THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'UNKNOWN_ESCROW';
+        END IF;
+        IF NOT e.status = ANY (statuses) THEN
+            RAISE EXCEPTION
+                USING MESSAGE = 'INVALID_ESCROW_TRANSITION';
+            END IF;
+            RETURN e;
+END;
+$$;
+
+-- Credit held money to seller. Held or disputed escrow may be released
+-- Raise exceptions of lock_escrow and of check_recipient for seller
+CREATE OR REPLACE PROCEDURE release_escrow (escrow_id bigint)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    e escrow;
+BEGIN
+    e := lock_escrow (escrow_id, '{HELD,DISPUTED}');
+    CALL check_recipient (e.seller_id);
+    UPDATE
+        "transaction" t
+    SET
+        status = 'DONE'
+    WHERE
+        t.id IN (e.payment_id, e.credit_id);
+    UPDATE
+        wallet w
+    SET
+        reserved_balance = w.reserved_balance - e.amount
+    WHERE
+        w.user_id = e.buyer_id
+        AND w.currency = e.currency;
+    UPDATE
+        wallet w
+    SET
+        balance = w.balance + e.amount
+    WHERE
+        w.user_id = e.seller_id
+        AND w.currency = e.currency;
+    UPDATE
+        escrow
+    SET
+        status = 'RELEASED',
+        updated_at = CURRENT_TIMESTAMP
+    WHERE
+        id = e.id;
+END;
+$$;
+
+-- Return held money to buyer. Held or disputed escrow may be refunded, refund is allowed for frozen account
+-- Raise exceptions of lock_escrow and of check_account for buyer
+CREATE OR REPLACE PROCEDURE refund_escrow (escrow_id bigint)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    e escrow;
+BEGIN
+    e := lock_escrow (escrow_id, '{HELD,DISPUTED}');
+    CALL check_account (e.buyer_id, allow_frozen => TRUE);
+    UPDATE
+        "transaction" t
+    SET
+        status = 'CANCELED'
+    WHERE
+        t.id IN (e.payment_id, e.credit_id);
+    UPDATE
+        wallet w
+    SET
+        reserved_balance = w.reserved_balance - e.amount,
+        balance = w.balance + e.amount
+    WHERE
+        w.user_id = e.buyer_id
+        AND w.currency = e.currency;
+    UPDATE
+        escrow
+    SET
+        status = 'REFUNDED',
+        updated_at = CURRENT_TIMESTAMP
+    WHERE
+        id = e.id;
+END;
+$$;
+
+-- Raise exceptions of lock_escrow
+CREATE OR REPLACE PROCEDURE dispute_escrow (escrow_id bigint, reason text)
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    PERFORM
+        lock_escrow (escrow_id, '{HELD}');
+    UPDATE
+        escrow
+    SET
+        status = 'DISPUTED',
+        dispute_reason = reason,
+        updated_at = CURRENT_TIMESTAMP
+    WHERE
+        id = escrow_id;
+END;
+$$;
+
+-- Settle expired held escrow by its timeout policy
+-- Raise exception with message ESCROW_NOT_EXPIRED if escrow isn't held or isn't expired, so it is settled once
+-- Raise exceptions of release_escrow and refund_escrow
+CREATE OR REPLACE PROCEDURE expire_escrow (escrow_id bigint)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    e escrow;
+BEGIN
+    SELECT
+        * INTO e
+    FROM
+        escrow
+    WHERE
+        id = expire_escrow.escrow_id
+    FOR UPDATE;
+    IF NOT found OR e.status <> 'HELD' OR e.expires_at > CURRENT_TIMESTAMP THEN
+        RAISE EXCEPTION
+            USING MESSAGE = 'ESCROW_NOT_EXPIRED';
+        END IF;
+        IF e.timeout_policy = 'RELEASE' THEN
+            CALL release_escrow (e.id);
+        ELSE
+            CALL refund_escrow (e.id);
+        END IF;
+END;
+$$;
+
+-- Append-only audit log of state changes. Wallet entries keep balances before and after the change,
+-- other entries describe the changed entity in details. Each entry is chained to the previous one by hash,
+-- so changed or removed entries are found by verify_audit_log
+CREATE TABLE IF NOT EXISTS audit_log (
+    id bigserial PRIMARY KEY,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    actor text NOT NULL,
+    client text NOT NULL DEFAULT '',
+    request_id text NOT NULL DEFAULT '',
+    source_ip text NOT NULL DEFAULT '',
+    operation text NOT NULL DEFAULT '',
+    -- changed entity: wallet, credit_limit, account, promo_grant, service, fee_schedule, exchange_rate,
+    -- velocity_rule or webhook
+    entity text NOT NULL DEFAULT 'wallet',
+    entity_id text NOT NULL DEFAULT '',
+    -- zero for entities which don't belong to user
+    user_id bigint NOT NULL DEFAULT 0,
+    currency text NOT NULL DEFAULT '',
+    balance_before MONEY_ NOT NULL DEFAULT 0,
+    balance_after MONEY_ NOT NULL DEFAULT 0,
+    reserved_before MONEY_ NOT NULL DEFAULT 0,
+    reserved_after MONEY_ NOT NULL DEFAULT 0,
+    -- new state of entity as json
+    details text NOT NULL DEFAULT '{}',
+    -- sha256 of request body in hex
+    payload_hash text NOT NULL DEFAULT '',
+    prev_hash text NOT NULL DEFAULT '',
+    hash text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_user_idx ON audit_log (user_id, id);
+
+CREATE INDEX IF NOT EXISTS audit_log_request_idx ON audit_log (request_id);
+
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity, entity_id, id);
+
+CREATE OR REPLACE FUNCTION audit_log_hash (a audit_log)
+    RETURNS text
+    LANGUAGE SQL
+    IMMUTABLE
+    AS $$
+    SELECT
+        encode(sha256(convert_to(json_build_array(a.id, to_char(a.created_at, 'YYYY-MM-DD"T"HH24:MI:SS.US'), a.actor, a.client, a.request_id, a.source_ip, a.operation, a.entity, a.entity_id, a.user_id, a.currency, a.balance_before::text, a.balance_after::text, a.reserved_before::text, a.reserved_after::text, a.details, a.payload_hash, a.prev_hash)::text, 'UTF8')), 'hex');
+$$;
+
+-- Append entry to audit log. Who makes the change is read from transaction settings
+-- audit.actor, audit.client, audit.request_id, audit.source_ip, audit.operation and audit.payload_hash.
+-- Changes made without them are attributed to database user.
+-- It is called by deferred triggers only, so writers are serialized only at commit and can't deadlock
+-- on rows locked after the chain. Audit log and transaction chain share the lock, so commits take it
+-- in one order. Entries are chained in order of id
+CREATE OR REPLACE PROCEDURE append_audit_log (entry audit_log)
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    PERFORM
+        pg_advisory_xact_lock(hashtext('chain'));
+    entry.id := nextval(pg_get_serial_sequence('audit_log', 'id'));
+    entry.created_at := CURRENT_TIMESTAMP;
+    entry.actor := COALESCE(NULLIF (current_setting('audit.actor', TRUE), ''), session_user);
+    entry.client := COALESCE(current_setting('audit.client', TRUE), '');
+    entry.request_id := COALESCE(current_setting('audit.request_id', TRUE), '');
+    entry.source_ip := COALESCE(current_setting('audit.source_ip', TRUE), '');
+    entry.operation := COALESCE(current_setting('audit.operation', TRUE), '');
+    entry.payload_hash := COALESCE(current_setting('audit.payload_hash', TRUE), '');
+    entry.prev_hash := COALESCE((
+        SELECT
+            a.hash
+        FROM audit_log a
+        ORDER BY a.id DESC
+        LIMIT 1), '');
+    entry.hash := audit_log_hash (entry);
+    INSERT INTO audit_log
+    SELECT
+        entry.*;
+END;
+$$;
+
+-- Write audit entry for changed balances of wallet
+CREATE OR REPLACE FUNCTION audit_wallet ()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    entry audit_log;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        IF NEW.balance = 0 AND NEW.reserved_balance = 0 THEN
+            RETURN NULL;
+        END IF;
+        entry.balance_before := 0;
+        entry.reserved_before := 0;
+    ELSE
+        IF OLD.balance = NEW.balance AND OLD.reserved_balance = NEW.reserved_balance THEN
+            RETURN NULL;
+        END IF;
+        entry.balance_before := OLD.balance;
+        entry.reserved_before := OLD.reserved_balance;
+    END IF;
+    entry.entity := 'wallet';
+    entry.entity_id := NEW.user_id || ':' || NEW.currency;
+    entry.user_id := NEW.user_id;
+    entry.currency := NEW.currency;
+    entry.balance_after := NEW.balance;
+    entry.reserved_after := NEW.reserved_balance;
+    entry.details := '{}';
+    CALL append_audit_log (entry);
+    RETURN NULL;
+END;
+$$;
+
+CREATE CONSTRAINT TRIGGER wallet_audit
+    AFTER INSERT OR UPDATE ON wallet DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW
+    EXECUTE FUNCTION audit_wallet ();
+
+-- Changes of entities other than wallet waiting for commit to be appended to audit log.
+-- Rows are removed by the trigger which appends them
+CREATE TABLE IF NOT EXISTS audit_pending (
+    id bigserial PRIMARY KEY,
+    entity text NOT NULL,
+    entity_id text NOT NULL,
+    user_id bigint NOT NULL DEFAULT 0,
+    currency text NOT NULL DEFAULT '',
+    details text NOT NULL DEFAULT '{}'
+);
+
+-- Record change of entity in audit log. It must be called in transaction of the change,
+-- the entry is appended at commit, so rolled back changes aren't audited
+CREATE OR REPLACE PROCEDURE audit_change (entity text, entity_id text, user_id bigint, currency text, details jsonb)
+LANGUAGE SQL
+AS $$
+    INSERT INTO audit_pending (entity, entity_id, user_id, currency, details)
+        VALUES ($1, $2, COALESCE($3, 0), COALESCE($4, ''), COALESCE($5, '{}')::text);
+$$;
+
+CREATE OR REPLACE FUNCTION audit_pending_change ()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    entry audit_log;
+BEGIN
+    entry.entity := NEW.entity;
+    entry.entity_id := NEW.entity_id;
+    entry.user_id := NEW.user_id;
+    entry.currency := NEW.currency;
+    entry.balance_before := 0;
+    entry.balance_after := 0;
+    entry.reserved_before := 0;
+    entry.reserved_after := 0;
+    entry.details := NEW.details;
+    CALL append_audit_log (entry);
+    DELETE FROM audit_pending p
+    WHERE p.id = NEW.id;
+    RETURN NULL;
+END;
+$$;
+
+CREATE CONSTRAINT TRIGGER audit_pending_append
+    AFTER INSERT ON audit_pending DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW
+    EXECUTE FUNCTION audit_pending_change ();
+
+CREATE OR REPLACE FUNCTION forbid_audit_log_change ()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    RAISE EXCEPTION
+        USING MESSAGE = 'AUDIT_LOG_APPEND_ONLY';
+    END;
+$$;
+
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW
+    EXECUTE FUNCTION forbid_audit_log_change ();
+
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION forbid_audit_log_change ();
+
+-- Walk the hash chain. broken_id is the first entry which doesn't match its hash or the previous entry.
+-- Removed tail isn't detected by the chain itself, so last_hash should be kept outside
+CREATE OR REPLACE FUNCTION verify_audit_log ()
+    RETURNS TABLE (
+        checked int,
+        broken_id bigint,
+        last_id bigint,
+        last_hash text)
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    a audit_log;
+BEGIN
+    checked := 0;
+    last_hash := '';
+    FOR a IN
+    SELECT
+        *
+    FROM
+        audit_log
+    ORDER BY
+        id LOOP
+            IF a.prev_hash <> last_hash OR a.hash <> audit_log_hash (a) THEN
+                broken_id := a.id;
+                RETURN NEXT;
+                RETURN;
+            END IF;
+            checked := checked + 1;
+            last_id := a.id;
+            last_hash := a.hash;
+        END LOOP;
+    RETURN NEXT;
+END;
+$$;
+
+-- Hash of transaction content with status as of chaining and of the previous link in chain.
+-- Settlement of transaction chained as PENDING is a separate link in transaction_settlement
+CREATE OR REPLACE FUNCTION transaction_hash (t "transaction")
+    RETURNS text
+    LANGUAGE SQL
+    IMMUTABLE
+    AS $$
+    SELECT
+        encode(sha256(convert_to(json_build_array(t.chain_seq, to_char(t.chained_at, 'YYYY-MM-DD"T"HH24:MI:SS.US'), t.id, t.user_id, t.amount::text, t.currency, t."status", t.service_id, t.order_id, t."description", to_char(t."timestamp", 'YYYY-MM-DD"T"HH24:MI:SS.US'), t.idempotency_key, t.kind, t.fee_of, t.payout_of, t.promo_amount::text, to_char(t.settled_at, 'YYYY-MM-DD"T"HH24:MI:SS.US'), t.prev_hash)::text, 'UTF8')), 'hex');
+$$;
+
+-- Hash of transaction as it was chained. Settled transaction was PENDING then
+CREATE OR REPLACE FUNCTION chained_transaction_hash (t "transaction", settled boolean)
+    RETURNS text
+    LANGUAGE plpgsql
+    IMMUTABLE
+    AS $$
+BEGIN
+    IF settled THEN
+        t."status" := 'PENDING';
+        t.settled_at := NULL;
+    END IF;
+    RETURN transaction_hash (t);
+END;
+$$;
+
+-- Settlement of chained PENDING transaction. It is a link of the same chain, so status can't be changed
+-- unnoticed after chaining. Transaction is settled once, archived transactions keep their settlements
+CREATE TABLE IF NOT EXISTS transaction_settlement (
+    chain_seq bigint PRIMARY KEY,
+    chained_at timestamp NOT NULL,
+    transaction_id bigint NOT NULL UNIQUE,
+    -- hash of the settled transaction link
+    transaction_hash text NOT NULL,
+    "status" TRANSACTION_STATUS NOT NULL,
+    settled_at timestamp NOT NULL,
+    prev_hash text NOT NULL,
+    hash text NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION transaction_settlement_hash (s transaction_settlement)
+    RETURNS text
+    LANGUAGE SQL
+    IMMUTABLE
+    AS $$
+    SELECT
+        encode(sha256(convert_to(json_build_array(s.chain_seq, to_char(s.chained_at, 'YYYY-MM-DD"T"HH24:MI:SS.US'), s.transaction_id, s.transaction_hash, s."status", to_char(s.settled_at, 'YYYY-MM-DD"T"HH24:MI:SS.US'), s.prev_hash)::text, 'UTF8')), 'hex');
+$$;
+
+-- The last link of transaction chain, either transaction or settlement
+CREATE OR REPLACE FUNCTION last_chain_link ()
+    RETURNS TABLE (
+        chain_seq bigint,
+        hash text)
+    LANGUAGE SQL
+    STABLE
+    AS $$
+    SELECT
+        l.chain_seq,
+        l.hash
+    FROM ( SELECT
+            tr.chain_seq,
+            tr.hash
+        FROM
+            transactions_since (NULL) tr
+        WHERE
+            tr.chain_seq IS NOT NULL
+        UNION ALL
+        SELECT
+            s.chain_seq,
+            s.hash
+        FROM
+            transaction_settlement s) l
+    ORDER BY
+        l.chain_seq DESC
+    LIMIT 1;
+$$;
+
+-- Chain new transaction to the last chained one. Trigger is deferred, so rows are chained in order of commit
+-- with content written by the whole transaction
+CREATE OR REPLACE FUNCTION chain_transaction ()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    t "transaction";
+    last_seq bigint;
+    last_hash text;
+BEGIN
+    PERFORM
+        pg_advisory_xact_lock(hashtext('chain'));
+    SELECT
+        * INTO t
+    FROM
+        "transaction" tr
+    WHERE
+        tr.id = NEW.id;
+    SELECT
+        l.chain_seq,
+        l.hash INTO last_seq,
+        last_hash
+    FROM
+        last_chain_link () l;
+    t.chain_seq := COALESCE(last_seq, 0) + 1;
+    t.chained_at := clock_timestamp();
+    t.prev_hash := COALESCE(last_hash, '');
+    t.hash := transaction_hash (t);
+    UPDATE
+        "transaction" tr
+    SET
+        chain_seq = t.chain_seq,
+        chained_at = t.chained_at,
+        prev_hash = t.prev_hash,
+        hash = t.hash
+    WHERE
+        tr.id = t.id;
+    RETURN NULL;
+END;
+$$;
+
+CREATE CONSTRAINT TRIGGER transaction_chain
+    AFTER INSERT ON "transaction" DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW
+    EXECUTE FUNCTION chain_transaction ();
+
+-- Chain settlement of transaction which was chained as PENDING. Transaction settled before its own
+-- chaining is chained with final status
+CREATE OR REPLACE FUNCTION chain_settlement ()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    t "transaction";
+    s transaction_settlement;
+    last_seq bigint;
+    last_hash text;
+BEGIN
+    PERFORM
+        pg_advisory_xact_lock(hashtext('chain'));
+    SELECT
+        * INTO t
+    FROM
+        "transaction" tr
+    WHERE
+        tr.id = NEW.id;
+    SELECT
+        l.chain_seq,
+        l.hash INTO last_seq,
+        last_hash
+    FROM
+        last_chain_link () l;
+    s.chain_seq := COALESCE(last_seq, 0) + 1;
+    s.chained_at := clock_timestamp();
+    s.transaction_id := t.id;
+    s.transaction_hash := t.hash;
+    s."status" := t."status";
+    s.settled_at := t.settled_at;
+    s.prev_hash := COALESCE(last_hash, '');
+    s.hash := transaction_settlement_hash (s);
+    INSERT INTO transaction_settlement
+    SELECT
+        s.*;
+    RETURN NULL;
+END;
+$$;
+
+CREATE CONSTRAINT TRIGGER transaction_settlement_chain
+    AFTER UPDATE OF "status" ON "transaction" DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW
+    WHEN (OLD.hash IS NOT NULL AND OLD."status" IS DISTINCT FROM NEW."status")
+    EXECUTE FUNCTION chain_settlement ();
+
+CREATE OR REPLACE FUNCTION forbid_transaction_settlement_change ()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    RAISE EXCEPTION
+        USING MESSAGE = 'TRANSACTION_SETTLEMENT_APPEND_ONLY';
+    END;
+$$;
+
+CREATE TRIGGER transaction_settlement_append_only
+    BEFORE UPDATE OR DELETE ON transaction_settlement
+    FOR EACH ROW
+    EXECUTE FUNCTION forbid_transaction_settlement_change ();
+
+CREATE TRIGGER transaction_settlement_no_truncate
+    BEFORE TRUNCATE ON transaction_settlement
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION forbid_transaction_settlement_change ();
+
+-- Chained content can't be changed. Chained PENDING transaction can only be settled to DONE or CANCELED,
+-- settled_at is set then by transaction_settle trigger which fires after this one.
+-- Transaction can be deleted only after the same chained row is archived
+-- Raise exception with message TRANSACTION_IMMUTABLE otherwise
+CREATE OR REPLACE FUNCTION protect_transaction ()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        IF OLD.hash IS NOT NULL AND EXISTS (
+            SELECT
+            FROM
+                transaction_archive a
+            WHERE
+                a.id = OLD.id
+                AND a.hash = OLD.hash) THEN
+            RETURN OLD;
+        END IF;
+        RAISE EXCEPTION
+            USING MESSAGE = 'TRANSACTION_IMMUTABLE';
+        END IF;
+        IF OLD.hash IS NOT NULL AND ROW (OLD.id, OLD.user_id, OLD.amount, OLD.currency, OLD.service_id, OLD.order_id, OLD."description", OLD."timestamp", OLD.idempotency_key, OLD.kind, OLD.fee_of, OLD.payout_of, OLD.promo_amount, OLD.chain_seq, OLD.chained_at, OLD.prev_hash, OLD.hash) IS DISTINCT FROM ROW (NEW.id, NEW.user_id, NEW.amount, NEW.currency, NEW.service_id, NEW.order_id, NEW."description", NEW."timestamp", NEW.idempotency_key, NEW.kind, NEW.fee_of, NEW.payout_of, NEW.promo_amount, NEW.chain_seq, NEW.chained_at, NEW.prev_hash, NEW.hash) THEN
+            RAISE EXCEPTION
+                USING MESSAGE = 'TRANSACTION_IMMUTABLE';
+            END IF;
+            IF OLD.hash IS NOT NULL AND (NEW.settled_at IS DISTINCT FROM OLD.settled_at OR (NEW."status" <> OLD."status" AND OLD."status" <> 'PENDING')) THEN
+                RAISE EXCEPTION
+                    USING MESSAGE = 'TRANSACTION_IMMUTABLE';
+                END IF;
+                RETURN NEW;
+END;
+$$;
+
+CREATE TRIGGER transaction_protect
+    BEFORE UPDATE OR DELETE ON "transaction"
+    FOR EACH ROW
+    EXECUTE FUNCTION protect_transaction ();
+
+-- Walk the transaction chain in order of chain_seq. broken_seq is the first link which doesn't match its hash,
+-- the previous link or follows a gap left by removed link. Settlement link is broken as well when status
+-- or settlement time of its transaction differ. Unchained rows are written bypassing the trigger
+CREATE OR REPLACE FUNCTION verify_transaction_chain ()
+    RETURNS TABLE (
+        checked int,
+        broken_seq bigint,
+        broken_id bigint,
+        unchained int,
+        last_seq bigint,
+        last_hash text)
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    link record;
+BEGIN
+    checked := 0;
+    last_seq := 0;
+    last_hash := '';
+    SELECT
+        count(*)::int INTO unchained
+    FROM
+        "transaction" tr
+    WHERE
+        tr.hash IS NULL;
+    FOR link IN
+    SELECT
+        tr.chain_seq,
+        tr.id transaction_id,
+        tr.prev_hash,
+        tr.hash,
+        chained_transaction_hash (tr, s.transaction_id IS NOT NULL) expected_hash
+    FROM
+        transactions_since (NULL) tr
+        LEFT JOIN transaction_settlement s ON s.transaction_id = tr.id
+    WHERE
+        tr.chain_seq IS NOT NULL
+    UNION ALL
+    SELECT
+        s.chain_seq,
+        s.transaction_id,
+        s.prev_hash,
+        s.hash,
+        CASE WHEN tr.hash = s.transaction_hash
+            AND tr."status" = s."status"
+            AND tr.settled_at = s.settled_at THEN
+            transaction_settlement_hash (s)
+        END
+    FROM
+        transaction_settlement s
+        LEFT JOIN transactions_since (NULL) tr ON tr.id = s.transaction_id
+    ORDER BY
+        chain_seq LOOP
+        IF link.chain_seq <> last_seq + 1 OR link.prev_hash IS DISTINCT FROM last_hash OR link.hash IS DISTINCT FROM link.expected_hash THEN
+            broken_seq := link.chain_seq;
+            broken_id := link.transaction_id;
+            RETURN NEXT;
+            RETURN;
+        END IF;
+        checked := checked + 1;
+        last_seq := link.chain_seq;
+        last_hash := link.hash;
+    END LOOP;
+    RETURN NEXT;
+END;
+$$;
+
+-- Hash of the last link chained by the end of each day. It covers all links before, so it can be anchored outside.
+-- Root of the current day changes until the day is over
+CREATE OR REPLACE FUNCTION get_transaction_chain_roots (from_day date, to_day date)
+    RETURNS TABLE (
+        "day" date,
+        "count" int,
+        last_seq bigint,
+        root_hash text)
+    LANGUAGE SQL
+    AS $$
+    SELECT DISTINCT ON (l.chained_at::date)
+        l.chained_at::date,
+        (count(*) OVER (PARTITION BY l.chained_at::date))::int,
+        l.chain_seq,
+        l.hash
+    FROM ( SELECT
+            t.chain_seq,
+            t.chained_at,
+            t.hash
+        FROM
+            transactions_since (NULL) t
+        WHERE
+            t.chain_seq IS NOT NULL
+        UNION ALL
+        SELECT
+            s.chain_seq,
+            s.chained_at,
+            s.hash
+        FROM
+            transaction_settlement s) l
+    WHERE (from_day IS NULL
+        OR l.chained_at >= from_day)
+        AND (to_day IS NULL
+            OR l.chained_at < to_day + 1)
+    ORDER BY
+        l.chained_at::date,
+        l.chain_seq DESC;
+$$;
+
+-- Record when transaction leaves PENDING, so balances can be computed as of past time
+CREATE OR REPLACE FUNCTION settle_transaction ()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        IF NEW."status" <> 'PENDING' THEN
+            NEW.settled_at := NEW."timestamp";
+        END IF;
+    ELSIF NEW."status" <> OLD."status" THEN
+        NEW.settled_at := CURRENT_TIMESTAMP;
+    END IF;
+    RETURN NEW;
+END;
+$$;
+
+CREATE TRIGGER transaction_settle
+    BEFORE INSERT OR UPDATE ON "transaction"
+    FOR EACH ROW
+    EXECUTE FUNCTION settle_transaction ();
+
+CREATE INDEX IF NOT EXISTS transaction_user_timestamp_idx ON "transaction" (user_id, currency, "timestamp");
+
+CREATE INDEX IF NOT EXISTS transaction_user_settled_idx ON "transaction" (user_id, currency, settled_at)
+WHERE
+    settled_at IS NOT NULL;
+
+-- Balances of wallet as of taken_at. Snapshot is a starting point of get_balance_at
+CREATE TABLE IF NOT EXISTS balance_snapshot (
+    user_id bigint NOT NULL,
+    currency text NOT NULL,
+    taken_at timestamp NOT NULL,
+    balance MONEY_ NOT NULL,
+    reserved_balance MONEY_ NOT NULL,
+    PRIMARY KEY (user_id, currency, taken_at)
+);
+
+-- Effect of transaction on balance and reserved balance of wallet at as_of.
+-- Part paid with promo credits doesn't touch the wallet. Debit holds money in reserved balance until it is
+-- settled and returns it if it is canceled. Credit is added to balance when it is done
+CREATE OR REPLACE FUNCTION transaction_effect (t "transaction", as_of timestamp, OUT balance numeric, OUT reserved numeric)
+LANGUAGE plpgsql
+IMMUTABLE
+AS $$
+DECLARE
+    real_amount numeric := t.amount + t.promo_amount;
+    settled boolean := t.settled_at IS NOT NULL AND t.settled_at <= as_of;
+BEGIN
+    balance := 0;
+    reserved := 0;
+    IF t."timestamp" > as_of THEN
+        RETURN;
+    END IF;
+    IF real_amount < 0 THEN
+        IF settled AND t."status" = 'CANCELED' THEN
+            RETURN;
+        END IF;
+        balance := real_amount;
+        IF NOT settled THEN
+            reserved := - real_amount;
+        END IF;
+    ELSIF settled AND t."status" = 'DONE' THEN
+        balance := real_amount;
+    END IF;
+END;
+$$;
+
+-- Balances of wallet at as_of computed from the latest snapshot before it and transactions changed since
+CREATE OR REPLACE FUNCTION wallet_balance_at (user_id bigint, currency text, as_of timestamp, OUT balance MONEY_, OUT reserved_balance MONEY_)
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    snapshot balance_snapshot;
+    since timestamp;
+BEGIN
+    SELECT
+        * INTO snapshot
+    FROM
+        balance_snapshot s
+    WHERE
+        s.user_id = wallet_balance_at.user_id
+        AND s.currency = wallet_balance_at.currency
+        AND s.taken_at <= as_of
+    ORDER BY
+        s.taken_at DESC
+    LIMIT 1;
+    since := COALESCE(snapshot.taken_at, '-infinity');
+    SELECT
+        COALESCE(snapshot.balance, 0) + COALESCE(sum((transaction_effect (t, as_of)).balance - (transaction_effect (t, since)).balance), 0),
+        COALESCE(snapshot.reserved_balance, 0) + COALESCE(sum((transaction_effect (t, as_of)).reserved - (transaction_effect (t, since)).reserved), 0) INTO balance,
+        reserved_balance
+    FROM
+        transactions_since (since) t
+    WHERE
+        t.user_id = wallet_balance_at.user_id
+        AND t.currency = wallet_balance_at.currency
+        AND ((t."timestamp" > since
+                AND t."timestamp" <= as_of)
+            OR (t.settled_at > since
+                AND t.settled_at <= as_of));
+END;
+$$;
+
+-- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
+CREATE OR REPLACE FUNCTION get_balance_at (user_id bigint, currency text, as_of timestamp)
+    RETURNS TABLE (
+        balance MONEY_,
+        reserved_balance MONEY_,
+        credit_limit MONEY_)
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    b MONEY_;
+    reserved MONEY_;
+    limit_at MONEY_;
+BEGIN
+    CALL check_user (user_id);
+    SELECT
+        * INTO b,
+        reserved
+    FROM
+        wallet_balance_at (user_id, currency, as_of);
+    -- credit limit is the last one set before as_of or the one replaced by the first change after it
+    SELECT
+        c.new_limit INTO limit_at
+    FROM
+        credit_limit_change c
+    WHERE
+        c.user_id = get_balance_at.user_id
+        AND c.currency = get_balance_at.currency
+        AND c.created_at <= as_of
+    ORDER BY
+        c.created_at DESC,
+        c.id DESC
+    LIMIT 1;
+    IF NOT found THEN
+        SELECT
+            c.old_limit INTO limit_at
+        FROM
+            credit_limit_change c
+        WHERE
+            c.user_id = get_balance_at.user_id
+            AND c.currency = get_balance_at.currency
+        ORDER BY
+            c.created_at,
+            c.id
+        LIMIT 1;
+    END IF;
+    IF limit_at IS NULL THEN
+        SELECT
+            w.credit_limit INTO limit_at
+        FROM
+            wallet w
+        WHERE
+            w.user_id = get_balance_at.user_id
+            AND w.currency = get_balance_at.currency;
+    END IF;
+    RETURN QUERY
+    SELECT
+        b,
+        reserved,
+        COALESCE(limit_at, 0)::MONEY_;
+END;
+$$;
+
+-- Snapshot balances at as_of of wallets whose transactions changed since their last snapshot. Return number of snapshots
+CREATE OR REPLACE FUNCTION take_balance_snapshots (as_of timestamp)
+    RETURNS int
+    LANGUAGE SQL
+    AS $$
+    WITH changed AS (
+        SELECT
+            w.user_id,
+            w.currency
+        FROM
+            wallet w
+            LEFT JOIN LATERAL (
+                SELECT
+                    max(s.taken_at) taken_at
+                FROM
+                    balance_snapshot s
+                WHERE
+                    s.user_id = w.user_id
+                    AND s.currency = w.currency) snap ON TRUE
+        WHERE (snap.taken_at IS NULL
+            OR snap.taken_at < as_of)
+        AND EXISTS (
+            SELECT
+            FROM
+                transactions_since (snap.taken_at) t
+            WHERE
+                t.user_id = w.user_id
+                AND t.currency = w.currency
+                AND ((t."timestamp" > COALESCE(snap.taken_at, '-infinity')
+                        AND t."timestamp" <= as_of)
+                    OR (t.settled_at > COALESCE(snap.taken_at, '-infinity')
+                        AND t.settled_at <= as_of)))
+),
+inserted AS (
+INSERT INTO balance_snapshot (user_id, currency, taken_at, balance, reserved_balance)
+    SELECT
+        c.user_id,
+        c.currency,
+        as_of,
+        b.balance,
+        b.reserved_balance
+    FROM
+        changed c,
+        wallet_balance_at (c.user_id, c.currency, as_of) b
+    RETURNING
+        1
+)
+SELECT
+    count(*)::int
+FROM
+    inserted;
+$$;
+
+CREATE INDEX IF NOT EXISTS transaction_pending_idx ON "transaction" (user_id, currency)
+WHERE
+    "status" = 'PENDING';
+
+CREATE INDEX IF NOT EXISTS transaction_fee_of_idx ON "transaction" (fee_of)
+WHERE
+    fee_of IS NOT NULL;
+
+-- Pending debits which hold money in reserved balance, oldest first. Amount is held money:
+-- the part not paid with promo credits and pending fees
+-- Raise exception no_data_found with message UNKNOWN_USER if user doesn't exist
+CREATE OR REPLACE FUNCTION get_reservations (user_id bigint, currency text)
+    RETURNS TABLE (
+        transaction_id bigint,
+        kind text,
+        service_id bigint,
+        service_name text,
+        order_id bigint,
+        amount MONEY_,
+        fee MONEY_,
+        promo_amount MONEY_,
+        created_at timestamp,
+        age_seconds bigint)
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    CALL check_user (user_id);
+    RETURN QUERY
+    SELECT
+        t.id,
+        t.kind::text,
+        COALESCE(t.service_id, 0),
+        COALESCE(s.name, ''),
+        COALESCE(t.order_id, 0),
+        (- t.amount - t.promo_amount + COALESCE(f.fee, 0))::MONEY_,
+        COALESCE(f.fee, 0)::MONEY_,
+        t.promo_amount,
+        t."timestamp",
+        extract(epoch FROM CURRENT_TIMESTAMP::timestamp - t."timestamp")::bigint
+    FROM
+        "transaction" t
+    LEFT JOIN "service" s ON s.id = t.service_id
+    LEFT JOIN LATERAL (
+        SELECT
+            sum(- ft.amount) fee
+        FROM
+            "transaction" ft
+        WHERE
+            ft.fee_of = t.id
+            AND ft."status" = 'PENDING') f ON TRUE
+    WHERE
+        t.user_id = get_reservations.user_id
+        AND t.currency = get_reservations.currency
+        AND t."status" = 'PENDING'
+        AND t.kind IN ('PAYMENT', 'ESCROW')
+        AND t.amount < 0
+    ORDER BY
+        t."timestamp",
+        t.id;
+END;
+$$;
+
+-- Archived rows keep idempotency keys and orders used, as unique constraints of "transaction" don't see them
+-- Raise exception unique_violation if archived transaction has the same key or order
+CREATE OR REPLACE FUNCTION check_archived_transaction ()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    IF EXISTS (
+        SELECT
+        FROM
+            transaction_archive a
+        WHERE
+            a.idempotency_key = NEW.idempotency_key
+            OR (a.user_id = NEW.user_id
+                AND a.amount = NEW.amount
+                AND a.currency = NEW.currency
+                AND a.service_id = NEW.service_id
+                AND a.order_id = NEW.order_id
+                AND a.kind = NEW.kind)) THEN
+        RAISE EXCEPTION unique_violation
+            USING MESSAGE = 'TRANSACTION_ARCHIVED';
+        END IF;
+        RETURN NEW;
+END;
+$$;
+
+CREATE TRIGGER transaction_check_archived
+    BEFORE INSERT ON "transaction"
+    FOR EACH ROW
+    EXECUTE FUNCTION check_archived_transaction ();
+
+-- Raise exception with message TRANSACTION_IMMUTABLE
+CREATE OR REPLACE FUNCTION forbid_transaction_archive_change ()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    RAISE EXCEPTION
+        USING MESSAGE = 'TRANSACTION_IMMUTABLE';
+    END;
+$$;
+
+CREATE TRIGGER transaction_archive_append_only
+    BEFORE UPDATE OR DELETE ON transaction_archive
+    FOR EACH ROW
+    EXECUTE FUNCTION forbid_transaction_archive_change ();
+
+CREATE TRIGGER transaction_archive_no_truncate
+    BEFORE TRUNCATE ON transaction_archive
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION forbid_transaction_archive_change ();
+
+-- Move at most "limit" chained transactions settled before "before" to transaction_archive. Balances are snapshotted
+-- at "before" first, so balances since then are computed without the archive.
+-- Transactions referenced by promo spendings, conversions, orders, subscriptions and escrows stay as they are
+-- looked up by them. Payment stays until its fees and payouts can be archived too, rows are taken from the newest,
+-- so they are moved together. Return number of archived transactions
+CREATE OR REPLACE FUNCTION archive_transactions (before timestamp, "limit" int)
+    RETURNS int
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+    ids bigint[];
+BEGIN
+    PERFORM
+        take_balance_snapshots (before);
+    SELECT
+        array_agg(c.id) INTO ids
+    FROM (
+        SELECT
+            t.id
+        FROM
+            "transaction" t
+        WHERE
+            t.settled_at < before
+            AND t.hash IS NOT NULL
+            AND NOT EXISTS (
+                SELECT
+                FROM
+                    "transaction" d
+                WHERE (d.fee_of = t.id
+                    OR d.payout_of = t.id)
+                AND NOT (d.settled_at < before
+                    AND d.hash IS NOT NULL))
+            AND NOT EXISTS (
+                SELECT
+                FROM
+                    promo_spending p
+                WHERE
+                    p.transaction_id = t.id)
+            AND NOT EXISTS (
+                SELECT
+                FROM
+                    fx_conversion f
+                WHERE
+                    f.from_transaction_id = t.id
+                    OR f.to_transaction_id = t.id)
+            AND NOT EXISTS (
+                SELECT
+                FROM
+                    order_line l
+                WHERE
+                    l.payment_id = t.id)
+            AND NOT EXISTS (
+                SELECT
+                FROM
+                    subscription_charge sc
+                WHERE
+                    sc.payment_id = t.id)
+            AND NOT EXISTS (
+                SELECT
+                FROM
+                    escrow e
+                WHERE
+                    e.payment_id = t.id
+                    OR e.credit_id = t.id)
+        ORDER BY
+            t.id DESC
+        LIMIT "limit"
+        FOR UPDATE OF t) c;
+    IF ids IS NULL THEN
+        RETURN 0;
+    END IF;
+    INSERT INTO transaction_archive
+    SELECT
+        *
+    FROM
+        "transaction" t
+    WHERE
+        t.id = ANY (ids);
+    DELETE FROM "transaction" t
+    WHERE t.id = ANY (ids);
+    RETURN array_length(ids, 1);
 END;
 $$;
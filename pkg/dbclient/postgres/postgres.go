@@ -3,7 +3,8 @@ package postgres
 import (
 	"fmt"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"time"
 )
 
 type Config struct {
@@ -14,9 +15,12 @@ type Config struct {
 	Database string
 }
 
+func (c Config) ConnString() string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", c.Username, c.Password, c.Host, c.Port, c.Database)
+}
+
 func New(config Config) (conn *sqlx.DB, err error) {
-	connString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", config.Username, config.Password, config.Host, config.Port, config.Database)
-	conn, err = sqlx.Connect("postgres", connString)
+	conn, err = sqlx.Connect("postgres", config.ConnString())
 	if err != nil {
 		return
 	}
@@ -27,3 +31,9 @@ func New(config Config) (conn *sqlx.DB, err error) {
 
 	return
 }
+
+// NewListener return listener for LISTEN/NOTIFY which reconnects automatically.
+// eventCallback may be nil
+func NewListener(config Config, eventCallback pq.EventCallbackType) *pq.Listener {
+	return pq.NewListener(config.ConnString(), 10*time.Second, time.Minute, eventCallback)
+}
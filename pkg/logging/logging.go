@@ -32,8 +32,7 @@ func Init(level string) error {
 	log.SetOutput(os.Stdout)
 	lvl, err := logrus.ParseLevel(level)
 	if err != nil {
-		fmt.Errorf("invalid level")
-		return err
+		return fmt.Errorf("invalid level: %v", err)
 	}
 	log.SetLevel(lvl)
 	logger = logrus.NewEntry(log)